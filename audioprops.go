@@ -0,0 +1,215 @@
+package main
+
+import (
+    "bytes"
+    "encoding/binary"
+    "fmt"
+    "io"
+    "math"
+    "path/filepath"
+    "strings"
+)
+
+// audioHeaderBytes is how much of a file is read to find its audio properties.
+const audioHeaderBytes = 256 * 1024
+
+// audioProps are the properties -match audio-props groups files by.
+type audioProps struct {
+    seconds    int
+    sampleRate int
+    channels   int
+}
+
+// readAudioProps parses the WAV, AIFF or MP3 header of the file at path.
+func readAudioProps(path string, size int64) (audioProps, error) {
+    file, err := openSource(path)
+    if err != nil {
+        return audioProps{}, err
+    }
+    defer file.Close()
+
+    head, err := io.ReadAll(io.LimitReader(file, audioHeaderBytes))
+    if err != nil {
+        return audioProps{}, err
+    }
+
+    switch strings.ToLower(filepath.Ext(path)) {
+    case ".wav":
+        return wavProps(head)
+    case ".aif", ".aiff":
+        return aiffProps(head)
+    case ".mp3":
+        return mp3Props(head, size)
+    }
+    return audioProps{}, fmt.Errorf("unsupported format")
+}
+
+func wavProps(data []byte) (audioProps, error) {
+    if len(data) < 12 || string(data[:4]) != "RIFF" || string(data[8:12]) != "WAVE" {
+        return audioProps{}, fmt.Errorf("not a WAV file")
+    }
+
+    var props audioProps
+    var byteRate uint32
+    for pos := 12; pos+8 <= len(data); {
+        id := string(data[pos : pos+4])
+        size := int(binary.LittleEndian.Uint32(data[pos+4 : pos+8]))
+        body := pos + 8
+        switch id {
+        case "fmt ":
+            if body+12 > len(data) {
+                return audioProps{}, fmt.Errorf("truncated fmt chunk")
+            }
+            props.channels = int(binary.LittleEndian.Uint16(data[body+2:]))
+            props.sampleRate = int(binary.LittleEndian.Uint32(data[body+4:]))
+            byteRate = binary.LittleEndian.Uint32(data[body+8:])
+        case "data":
+            if byteRate == 0 {
+                return audioProps{}, fmt.Errorf("data chunk before fmt chunk")
+            }
+            props.seconds = int(math.Round(float64(size) / float64(byteRate)))
+            return props, nil
+        }
+        pos = body + size + size%2
+    }
+    return audioProps{}, fmt.Errorf("no data chunk in the first %d bytes", audioHeaderBytes)
+}
+
+func aiffProps(data []byte) (audioProps, error) {
+    if len(data) < 12 || string(data[:4]) != "FORM" || (string(data[8:12]) != "AIFF" && string(data[8:12]) != "AIFC") {
+        return audioProps{}, fmt.Errorf("not an AIFF file")
+    }
+
+    for pos := 12; pos+8 <= len(data); {
+        id := string(data[pos : pos+4])
+        size := int(binary.BigEndian.Uint32(data[pos+4 : pos+8]))
+        body := pos + 8
+        if id == "COMM" {
+            if body+18 > len(data) {
+                return audioProps{}, fmt.Errorf("truncated COMM chunk")
+            }
+            frames := binary.BigEndian.Uint32(data[body+2:])
+            rate := extendedFloat(data[body+8 : body+18])
+            if rate <= 0 {
+                return audioProps{}, fmt.Errorf("invalid sample rate")
+            }
+            return audioProps{
+                seconds:    int(math.Round(float64(frames) / rate)),
+                sampleRate: int(math.Round(rate)),
+                channels:   int(binary.BigEndian.Uint16(data[body:])),
+            }, nil
+        }
+        pos = body + size + size%2
+    }
+    return audioProps{}, fmt.Errorf("no COMM chunk in the first %d bytes", audioHeaderBytes)
+}
+
+// extendedFloat decodes the 80-bit IEEE 754 extended float AIFF uses for its
+// sample rate.
+func extendedFloat(b []byte) float64 {
+    exponent := int(binary.BigEndian.Uint16(b[:2]) & 0x7fff)
+    mantissa := binary.BigEndian.Uint64(b[2:10])
+    value := math.Ldexp(float64(mantissa), exponent-16383-63)
+    if b[0]&0x80 != 0 {
+        value = -value
+    }
+    return value
+}
+
+var (
+    mp3Bitrates = [2][3][16]int{
+        { // MPEG-1: layer I, II, III
+            {0, 32, 64, 96, 128, 160, 192, 224, 256, 288, 320, 352, 384, 416, 448},
+            {0, 32, 48, 56, 64, 80, 96, 112, 128, 160, 192, 224, 256, 320, 384},
+            {0, 32, 40, 48, 56, 64, 80, 96, 112, 128, 160, 192, 224, 256, 320},
+        },
+        { // MPEG-2 and 2.5
+            {0, 32, 48, 56, 64, 80, 96, 112, 128, 144, 160, 176, 192, 224, 256},
+            {0, 8, 16, 24, 32, 40, 48, 56, 64, 80, 96, 112, 128, 144, 160},
+            {0, 8, 16, 24, 32, 40, 48, 56, 64, 80, 96, 112, 128, 144, 160},
+        },
+    }
+    mp3SampleRates = [3][3]int{
+        {44100, 48000, 32000}, // MPEG-1
+        {22050, 24000, 16000}, // MPEG-2
+        {11025, 12000, 8000},  // MPEG-2.5
+    }
+)
+
+// mp3Props reads the first MPEG audio frame. The duration comes from a
+// Xing/Info header when the encoder wrote one, and is otherwise estimated from
+// the bitrate, which is exact for constant-bitrate files.
+func mp3Props(data []byte, size int64) (audioProps, error) {
+    start := 0
+    if len(data) >= 10 && string(data[:3]) == "ID3" {
+        start = 10 + syncsafe(data[6:10])
+        if data[5]&0x10 != 0 {
+            start += 10 // footer
+        }
+    }
+
+    for pos := start; pos+4 <= len(data); pos++ {
+        if data[pos] != 0xff || data[pos+1]&0xe0 != 0xe0 {
+            continue
+        }
+        versionBits := (data[pos+1] >> 3) & 0x3
+        layerBits := (data[pos+1] >> 1) & 0x3
+        bitrateIndex := data[pos+2] >> 4
+        rateIndex := (data[pos+2] >> 2) & 0x3
+        if versionBits == 1 || layerBits == 0 || bitrateIndex == 0 || bitrateIndex == 15 || rateIndex == 3 {
+            continue
+        }
+
+        version := map[byte]int{3: 0, 2: 1, 0: 2}[versionBits]
+        layer := 3 - int(layerBits) // 0 = layer I
+        bitrate := mp3Bitrates[min(version, 1)][layer][bitrateIndex] * 1000
+        sampleRate := mp3SampleRates[version][rateIndex]
+        channels := 2
+        if data[pos+3]>>6 == 3 {
+            channels = 1
+        }
+
+        samplesPerFrame := 1152
+        switch {
+        case layer == 0:
+            samplesPerFrame = 384
+        case layer == 2 && version > 0:
+            samplesPerFrame = 576
+        }
+
+        props := audioProps{sampleRate: sampleRate, channels: channels}
+        if frames := xingFrames(data[pos:]); frames > 0 {
+            props.seconds = int(math.Round(float64(frames) * float64(samplesPerFrame) / float64(sampleRate)))
+        } else {
+            props.seconds = int(math.Round(float64(size-int64(pos)) * 8 / float64(bitrate)))
+        }
+        return props, nil
+    }
+    return audioProps{}, fmt.Errorf("no MPEG audio frame in the first %d bytes", audioHeaderBytes)
+}
+
+// xingFrames returns the frame count from a Xing, Info or VBRI header in the
+// first frame, or 0 if there is none.
+func xingFrames(frame []byte) int {
+    window := frame[:min(len(frame), 200)]
+    for _, tag := range []string{"Xing", "Info"} {
+        i := bytes.Index(window, []byte(tag))
+        // The frame count is present when bit 0 of the flags is set.
+        if i >= 0 && i+12 <= len(frame) && binary.BigEndian.Uint32(frame[i+4:])&0x1 != 0 {
+            return int(binary.BigEndian.Uint32(frame[i+8:]))
+        }
+    }
+    if i := bytes.Index(window, []byte("VBRI")); i >= 0 && i+18 <= len(frame) {
+        return int(binary.BigEndian.Uint32(frame[i+14:]))
+    }
+    return 0
+}
+
+// audioPropsKey groups files for -match audio-props: the normalized name
+// without its extension, so re-encodes to another format still match, plus
+// the audio properties.
+func audioPropsKey(fileInfo *FileInfo, props audioProps) string {
+    name := normalizeName(matchName(fileInfo.Name))
+    name = strings.TrimSuffix(name, strings.ToLower(filepath.Ext(fileInfo.Name)))
+    return fmt.Sprintf("%s|%ds|%dHz|%dch", keyField(name), props.seconds, props.sampleRate, props.channels)
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+    "encoding/binary"
+    "fmt"
+    "io"
+    "math/rand/v2"
+    "os"
+    "path/filepath"
+    "time"
+)
+
+// benchmarkSeed fixes the synthetic tree so runs with different settings
+// hash exactly the same files.
+const benchmarkSeed = 20240601
+
+// runBenchmark scans a generated tree of n files with the current settings
+// and prints the throughput. Nothing outside the temporary tree is touched.
+func runBenchmark(n int) error {
+    dir, err := os.MkdirTemp(tmpDir, "dedupe-music-benchmark-")
+    if err != nil {
+        return err
+    }
+    defer os.RemoveAll(dir)
+
+    tree := filepath.Join(dir, "tree")
+    fmt.Printf("Generating %d files in %s\n", n, tree)
+    totalBytes, err := generateTree(tree, n)
+    if err != nil {
+        return fmt.Errorf("error generating benchmark files: %v", err)
+    }
+
+    // The results file and any -checkpoint are written relative to the
+    // working directory, so move into the temporary directory for the scan.
+    wd, err := os.Getwd()
+    if err != nil {
+        return err
+    }
+    if err := os.Chdir(dir); err != nil {
+        return err
+    }
+    defer os.Chdir(wd)
+
+    sourceDirs = DirList{tree}
+    minSize = 0
+    extMinSize = nil
+    forceOverwrite = true
+    out := infoOut
+    infoOut = io.Discard
+    start := time.Now()
+    _, err = run()
+    elapsed := time.Since(start)
+    infoOut = out
+    if err != nil {
+        return err
+    }
+
+    seconds := elapsed.Seconds()
+    fmt.Printf("Scanned %d files (%s) in %v with %d workers, queue size %d, %d KB buffers\n",
+        n, formatSize(totalBytes), elapsed.Round(time.Millisecond), numWorkers, queueSize, bufferSizeKB)
+    fmt.Printf("%.1f files/sec, %.1f MiB/sec\n", float64(n)/seconds, float64(totalBytes)/(1<<20)/seconds)
+    return nil
+}
+
+// generateTree writes n pseudo-random .wav files of 256 KB to 2 MB under
+// root, in directories of 100. Every fifth file is a copy of an earlier one
+// in a "copies" directory, so duplicate grouping is exercised too. It
+// returns the total size written.
+func generateTree(root string, n int) (int64, error) {
+    rng := rand.New(rand.NewPCG(benchmarkSeed, benchmarkSeed))
+    buf := make([]byte, 2<<20)
+    var total int64
+    var names []string
+    var sizes []int
+
+    for i := 0; i < n; i++ {
+        name := fmt.Sprintf("track%05d.wav", i)
+        dir := filepath.Join(root, fmt.Sprintf("%03d", i/100))
+        var data []byte
+        if i%5 == 4 && len(sizes) > 0 {
+            // Copy an earlier file under its own name.
+            j := rng.IntN(len(sizes))
+            name = names[j]
+            dir = filepath.Join(root, "copies", fmt.Sprintf("%05d", i))
+            data = benchmarkContent(buf, j, sizes[j])
+        } else {
+            size := 256<<10 + rng.IntN(2<<20-256<<10)
+            names = append(names, name)
+            sizes = append(sizes, size)
+            data = benchmarkContent(buf, len(sizes)-1, size)
+        }
+
+        if err := os.MkdirAll(dir, 0755); err != nil {
+            return 0, err
+        }
+        if err := os.WriteFile(filepath.Join(dir, name), data, 0644); err != nil {
+            return 0, err
+        }
+        total += int64(len(data))
+    }
+    return total, nil
+}
+
+// benchmarkContent fills buf with the content of the i-th generated file.
+func benchmarkContent(buf []byte, i, size int) []byte {
+    rng := rand.New(rand.NewPCG(benchmarkSeed, uint64(i)))
+    data := buf[:size]
+    for off := 0; off+8 <= size; off += 8 {
+        binary.LittleEndian.PutUint64(data[off:], rng.Uint64())
+    }
+    return data
+}
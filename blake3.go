@@ -0,0 +1,370 @@
+package main
+
+import (
+    "context"
+    "encoding/binary"
+    "errors"
+    "hash"
+    "io"
+    "math/bits"
+    "os"
+    "runtime"
+    "sync"
+)
+
+// BLAKE3 splits its input into 1 KiB chunks and combines their chaining
+// values in a binary tree, so any run of whole, aligned chunks can be hashed
+// independently. That lets a single large file be hashed on every CPU; see
+// hashParallel.
+const (
+    blake3ChunkLen = 1024
+    blake3BlockLen = 64
+
+    blake3ChunkStart = 1 << 0
+    blake3ChunkEnd   = 1 << 1
+    blake3Parent     = 1 << 2
+    blake3Root       = 1 << 3
+
+    // blake3SegmentSize is how much of a file each goroutine hashes at a
+    // time. It must be a power of two number of chunks.
+    blake3SegmentSize   = 1 << 20
+    blake3SegmentChunks = blake3SegmentSize / blake3ChunkLen
+)
+
+var blake3IV = [8]uint32{
+    0x6a09e667, 0xbb67ae85, 0x3c6ef372, 0xa54ff53a,
+    0x510e527f, 0x9b05688c, 0x1f83d9ab, 0x5be0cd19,
+}
+
+var blake3Permutation = [16]int{2, 6, 3, 10, 7, 0, 4, 13, 1, 11, 12, 5, 9, 14, 15, 8}
+
+func blake3G(s *[16]uint32, a, b, c, d int, x, y uint32) {
+    s[a] += s[b] + x
+    s[d] = bits.RotateLeft32(s[d]^s[a], -16)
+    s[c] += s[d]
+    s[b] = bits.RotateLeft32(s[b]^s[c], -12)
+    s[a] += s[b] + y
+    s[d] = bits.RotateLeft32(s[d]^s[a], -8)
+    s[c] += s[d]
+    s[b] = bits.RotateLeft32(s[b]^s[c], -7)
+}
+
+func blake3Compress(cv [8]uint32, block [16]uint32, counter uint64, blockLen, flags uint32) [16]uint32 {
+    s := [16]uint32{
+        cv[0], cv[1], cv[2], cv[3], cv[4], cv[5], cv[6], cv[7],
+        blake3IV[0], blake3IV[1], blake3IV[2], blake3IV[3],
+        uint32(counter), uint32(counter >> 32), blockLen, flags,
+    }
+    m := block
+    for round := 0; round < 7; round++ {
+        blake3G(&s, 0, 4, 8, 12, m[0], m[1])
+        blake3G(&s, 1, 5, 9, 13, m[2], m[3])
+        blake3G(&s, 2, 6, 10, 14, m[4], m[5])
+        blake3G(&s, 3, 7, 11, 15, m[6], m[7])
+        blake3G(&s, 0, 5, 10, 15, m[8], m[9])
+        blake3G(&s, 1, 6, 11, 12, m[10], m[11])
+        blake3G(&s, 2, 7, 8, 13, m[12], m[13])
+        blake3G(&s, 3, 4, 9, 14, m[14], m[15])
+        var permuted [16]uint32
+        for i, j := range blake3Permutation {
+            permuted[i] = m[j]
+        }
+        m = permuted
+    }
+    for i := range 8 {
+        s[i] ^= s[i+8]
+        s[i+8] ^= cv[i]
+    }
+    return s
+}
+
+func blake3Words(block []byte) [16]uint32 {
+    var padded [blake3BlockLen]byte
+    copy(padded[:], block)
+    var words [16]uint32
+    for i := range words {
+        words[i] = binary.LittleEndian.Uint32(padded[i*4:])
+    }
+    return words
+}
+
+// blake3Output is a compression that has not been run yet, because whether
+// it is the root of the tree is only known once all input has been seen.
+type blake3Output struct {
+    cv       [8]uint32
+    block    [16]uint32
+    counter  uint64
+    blockLen uint32
+    flags    uint32
+}
+
+func (o blake3Output) chainingValue() [8]uint32 {
+    s := blake3Compress(o.cv, o.block, o.counter, o.blockLen, o.flags)
+    return [8]uint32(s[:8])
+}
+
+func (o blake3Output) rootBytes() []byte {
+    s := blake3Compress(o.cv, o.block, 0, o.blockLen, o.flags|blake3Root)
+    out := make([]byte, 32)
+    for i := range 8 {
+        binary.LittleEndian.PutUint32(out[i*4:], s[i])
+    }
+    return out
+}
+
+func blake3ParentOutput(left, right [8]uint32) blake3Output {
+    var block [16]uint32
+    copy(block[:8], left[:])
+    copy(block[8:], right[:])
+    return blake3Output{cv: blake3IV, block: block, blockLen: blake3BlockLen, flags: blake3Parent}
+}
+
+// blake3Chunk is the state of the chunk currently being hashed.
+type blake3Chunk struct {
+    cv         [8]uint32
+    counter    uint64
+    block      [blake3BlockLen]byte
+    blockLen   uint8
+    compressed uint8
+}
+
+func newBlake3Chunk(counter uint64) blake3Chunk {
+    return blake3Chunk{cv: blake3IV, counter: counter}
+}
+
+func (c *blake3Chunk) len() int {
+    return int(c.compressed)*blake3BlockLen + int(c.blockLen)
+}
+
+func (c *blake3Chunk) startFlag() uint32 {
+    if c.compressed == 0 {
+        return blake3ChunkStart
+    }
+    return 0
+}
+
+func (c *blake3Chunk) update(p []byte) {
+    for len(p) > 0 {
+        // The last block is held back since it is compressed with
+        // blake3ChunkEnd.
+        if c.blockLen == blake3BlockLen {
+            s := blake3Compress(c.cv, blake3Words(c.block[:]), c.counter, blake3BlockLen, c.startFlag())
+            c.cv = [8]uint32(s[:8])
+            c.compressed++
+            c.blockLen = 0
+        }
+        n := copy(c.block[c.blockLen:], p)
+        c.blockLen += uint8(n)
+        p = p[n:]
+    }
+}
+
+func (c *blake3Chunk) output() blake3Output {
+    return blake3Output{
+        cv:       c.cv,
+        block:    blake3Words(c.block[:c.blockLen]),
+        counter:  c.counter,
+        blockLen: uint32(c.blockLen),
+        flags:    c.startFlag() | blake3ChunkEnd,
+    }
+}
+
+// blake3Digest is an unkeyed BLAKE3 hash.Hash with a 32-byte output. It
+// implements encoding.BinaryMarshaler so -resume can checkpoint it.
+type blake3Digest struct {
+    chunk blake3Chunk
+    // stack holds the chaining values of completed subtrees, largest first.
+    stack [][8]uint32
+}
+
+func newBlake3() hash.Hash {
+    d := &blake3Digest{}
+    d.Reset()
+    return d
+}
+
+func (d *blake3Digest) Reset() {
+    d.chunk = newBlake3Chunk(0)
+    d.stack = d.stack[:0]
+}
+
+func (d *blake3Digest) Size() int      { return 32 }
+func (d *blake3Digest) BlockSize() int { return blake3BlockLen }
+
+func (d *blake3Digest) Write(p []byte) (int, error) {
+    n := len(p)
+    for len(p) > 0 {
+        if d.chunk.len() == blake3ChunkLen {
+            d.pushSubtree(d.chunk.output().chainingValue(), 1)
+        }
+        take := min(blake3ChunkLen-d.chunk.len(), len(p))
+        d.chunk.update(p[:take])
+        p = p[take:]
+    }
+    return n, nil
+}
+
+// pushSubtree adds the chaining value of the next chunks chunks, which must
+// be a power of two and start on a multiple of chunks, and starts a new empty
+// chunk after them. Subtrees on the stack that are now complete are merged.
+func (d *blake3Digest) pushSubtree(cv [8]uint32, chunks uint64) {
+    total := d.chunk.counter + chunks
+    for n := total / chunks; n&1 == 0; n >>= 1 {
+        cv = blake3ParentOutput(d.stack[len(d.stack)-1], cv).chainingValue()
+        d.stack = d.stack[:len(d.stack)-1]
+    }
+    d.stack = append(d.stack, cv)
+    d.chunk = newBlake3Chunk(total)
+}
+
+func (d *blake3Digest) Sum(b []byte) []byte {
+    out := d.chunk.output()
+    for i := len(d.stack) - 1; i >= 0; i-- {
+        out = blake3ParentOutput(d.stack[i], out.chainingValue())
+    }
+    return append(b, out.rootBytes()...)
+}
+
+const blake3StateMagic = "b3\x01"
+
+func (d *blake3Digest) MarshalBinary() ([]byte, error) {
+    data := []byte(blake3StateMagic)
+    for _, w := range d.chunk.cv {
+        data = binary.LittleEndian.AppendUint32(data, w)
+    }
+    data = binary.LittleEndian.AppendUint64(data, d.chunk.counter)
+    data = append(data, d.chunk.block[:]...)
+    data = append(data, d.chunk.blockLen, d.chunk.compressed, byte(len(d.stack)))
+    for _, cv := range d.stack {
+        for _, w := range cv {
+            data = binary.LittleEndian.AppendUint32(data, w)
+        }
+    }
+    return data, nil
+}
+
+func (d *blake3Digest) UnmarshalBinary(data []byte) error {
+    const fixed = len(blake3StateMagic) + 32 + 8 + blake3BlockLen + 3
+    if len(data) < fixed || string(data[:len(blake3StateMagic)]) != blake3StateMagic {
+        return errors.New("blake3: invalid hash state")
+    }
+    data = data[len(blake3StateMagic):]
+    var chunk blake3Chunk
+    for i := range chunk.cv {
+        chunk.cv[i] = binary.LittleEndian.Uint32(data[i*4:])
+    }
+    chunk.counter = binary.LittleEndian.Uint64(data[32:])
+    copy(chunk.block[:], data[40:])
+    rest := data[40+blake3BlockLen:]
+    chunk.blockLen, chunk.compressed = rest[0], rest[1]
+    depth := int(rest[2])
+    rest = rest[3:]
+    if chunk.blockLen > blake3BlockLen || chunk.len() > blake3ChunkLen || len(rest) != depth*32 {
+        return errors.New("blake3: invalid hash state")
+    }
+    stack := make([][8]uint32, depth)
+    for i := range stack {
+        for j := range stack[i] {
+            stack[i][j] = binary.LittleEndian.Uint32(rest[i*32+j*4:])
+        }
+    }
+    d.chunk, d.stack = chunk, stack
+    return nil
+}
+
+// blake3Subtree returns the chaining value of data, a power of two number of
+// whole chunks, numbered from first.
+func blake3Subtree(data []byte, first uint64) [8]uint32 {
+    cvs := make([][8]uint32, len(data)/blake3ChunkLen)
+    for i := range cvs {
+        chunk := newBlake3Chunk(first + uint64(i))
+        chunk.update(data[i*blake3ChunkLen : (i+1)*blake3ChunkLen])
+        cvs[i] = chunk.output().chainingValue()
+    }
+    for len(cvs) > 1 {
+        for i := range len(cvs) / 2 {
+            cvs[i] = blake3ParentOutput(cvs[2*i], cvs[2*i+1]).chainingValue()
+        }
+        cvs = cvs[:len(cvs)/2]
+    }
+    return cvs[0]
+}
+
+// canHashParallel reports whether whole segments can be pushed onto d, which
+// is true for a new digest and one restored from a checkpoint made by
+// hashParallel.
+func (d *blake3Digest) canHashParallel() bool {
+    return d.chunk.len() == 0 && d.chunk.counter%blake3SegmentChunks == 0
+}
+
+var blake3SegmentPool = sync.Pool{
+    New: func() interface{} {
+        buf := make([]byte, blake3SegmentSize)
+        return &buf
+    },
+}
+
+// hashParallel hashes the file from offset, the number of bytes already
+// hashed into d plus start, in segments spread over every CPU. Segments are
+// read in batches of hashChunkSize; after each batch read is called with the
+// bytes hashed and checkpoint with the new offset. It stops short of the end
+// of the file, leaving at least one byte for the caller to hash with Write,
+// and returns the offset it reached.
+func (d *blake3Digest) hashParallel(ctx context.Context, f *os.File, offset, size int64, read func(n int64), checkpoint func(offset int64)) (int64, error) {
+    workers := runtime.GOMAXPROCS(0)
+    for offset+blake3SegmentSize < size {
+        count := min((size-offset-1)/blake3SegmentSize, hashChunkSize/blake3SegmentSize)
+        cvs := make([][8]uint32, count)
+        first := d.chunk.counter
+
+        var wg sync.WaitGroup
+        var errMutex sync.Mutex
+        var firstErr error
+        next := make(chan int64)
+        for range min(workers, int(count)) {
+            wg.Add(1)
+            go func() {
+                defer wg.Done()
+                buf := blake3SegmentPool.Get().(*[]byte)
+                defer blake3SegmentPool.Put(buf)
+                for i := range next {
+                    err := ctx.Err()
+                    if err == nil && readLimiter != nil {
+                        err = readLimiter.wait(ctx, blake3SegmentSize)
+                    }
+                    if err == nil {
+                        _, err = f.ReadAt(*buf, offset+i*blake3SegmentSize)
+                    }
+                    if err != nil {
+                        if err == io.EOF {
+                            err = io.ErrUnexpectedEOF
+                        }
+                        errMutex.Lock()
+                        if firstErr == nil {
+                            firstErr = err
+                        }
+                        errMutex.Unlock()
+                        continue
+                    }
+                    cvs[i] = blake3Subtree(*buf, first+uint64(i)*blake3SegmentChunks)
+                }
+            }()
+        }
+        for i := range count {
+            next <- i
+        }
+        close(next)
+        wg.Wait()
+        if firstErr != nil {
+            return offset, firstErr
+        }
+
+        for _, cv := range cvs {
+            d.pushSubtree(cv, blake3SegmentChunks)
+        }
+        offset += count * blake3SegmentSize
+        read(count * blake3SegmentSize)
+        checkpoint(offset)
+    }
+    return offset, nil
+}
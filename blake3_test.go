@@ -0,0 +1,104 @@
+package main
+
+import (
+    "context"
+    "encoding/hex"
+    "io"
+    "os"
+    "path/filepath"
+    "testing"
+)
+
+// blake3Input returns the input of the official test vectors: the bytes
+// 0, 1, ..., 250 repeated.
+func blake3Input(n int) []byte {
+    data := make([]byte, n)
+    for i := range data {
+        data[i] = byte(i % 251)
+    }
+    return data
+}
+
+func TestBlake3Vectors(t *testing.T) {
+    // From test_vectors.json in the BLAKE3 reference repository.
+    tests := []struct {
+        length int
+        want   string
+    }{
+        {0, "af1349b9f5f9a1a6a0404dea36dcc9499bcb25c9adc112b7cc9a93cae41f3262"},
+        {1, "2d3adedff11b61f14c886e35afa036736dcd87a74d27b5c1510225d0f592e213"},
+        {1023, "10108970eeda3eb932baac1428c7a2163b0e924c9a9e25b35bba72b28f70bd11"},
+        {1024, "42214739f095a406f3fc83deb889744ac00df831c10daa55189b5d121c855af7"},
+        {1025, "d00278ae47eb27b34faecf67b4fe263f82d5412916c1ffd97c8cb7fb814b8444"},
+        {2048, "e776b6028c7cd22a4d0ba182a8bf62205d2ef576467e838ed6f2529b85fba24a"},
+        {102400, "bc3e3d41a1146b069abffad3c0d44860cf664390afce4d9661f7902e7943e085"},
+    }
+    for _, tt := range tests {
+        data := blake3Input(tt.length)
+
+        h := newBlake3()
+        h.Write(data)
+        if got := hex.EncodeToString(h.Sum(nil)); got != tt.want {
+            t.Errorf("length %d: got %s, want %s", tt.length, got, tt.want)
+        }
+
+        // Writing in uneven pieces must not change the result.
+        h.Reset()
+        for len(data) > 0 {
+            n := min(len(data), 100)
+            h.Write(data[:n])
+            data = data[n:]
+        }
+        if got := hex.EncodeToString(h.Sum(nil)); got != tt.want {
+            t.Errorf("length %d in pieces: got %s, want %s", tt.length, got, tt.want)
+        }
+    }
+}
+
+func TestBlake3ParallelMatchesSequential(t *testing.T) {
+    data := blake3Input(5*blake3SegmentSize + 1234)
+    path := filepath.Join(t.TempDir(), "large.wav")
+    if err := os.WriteFile(path, data, 0644); err != nil {
+        t.Fatal(err)
+    }
+
+    sequential := newBlake3()
+    sequential.Write(data)
+    want := hex.EncodeToString(sequential.Sum(nil))
+
+    f, err := os.Open(path)
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer f.Close()
+
+    d := newBlake3().(*blake3Digest)
+    var hashed int64
+    offset, err := d.hashParallel(context.Background(), f, 0, int64(len(data)), func(n int64) { hashed += n }, func(int64) {})
+    if err != nil {
+        t.Fatal(err)
+    }
+    if offset == 0 || offset >= int64(len(data)) || hashed != offset {
+        t.Fatalf("hashParallel reached offset %d after reading %d of %d bytes", offset, hashed, len(data))
+    }
+
+    // Checkpointing and restoring the state must carry on where it stopped.
+    state, err := d.MarshalBinary()
+    if err != nil {
+        t.Fatal(err)
+    }
+    restored := newBlake3().(*blake3Digest)
+    if err := restored.UnmarshalBinary(state); err != nil {
+        t.Fatal(err)
+    }
+
+    if _, err := f.Seek(offset, io.SeekStart); err != nil {
+        t.Fatal(err)
+    }
+    if _, err := io.Copy(restored, f); err != nil {
+        t.Fatal(err)
+    }
+    if got := hex.EncodeToString(restored.Sum(nil)); got != want {
+        t.Errorf("parallel hash %s, want sequential %s", got, want)
+    }
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+    "os"
+    "slices"
+    "strings"
+    "sync"
+)
+
+// brokenLink is a symbolic link in a scanned directory whose target cannot be
+// reached. It cannot be scanned even with -follow-symlinks, but a dangling
+// one usually means a library file was moved or deleted, so it is worth
+// reporting.
+type brokenLink struct {
+    Path   string `json:"path"`
+    Target string `json:"target"`
+    Error  string `json:"error"`
+}
+
+var (
+    brokenLinks []brokenLink
+    brokenMutex sync.Mutex
+)
+
+// checkBrokenLink records path if it is a symbolic link whose target cannot
+// be stat'ed.
+func checkBrokenLink(path string, info os.FileInfo) {
+    if info.Mode()&os.ModeSymlink == 0 {
+        return
+    }
+    _, err := os.Stat(path)
+    if err == nil {
+        return
+    }
+    target, _ := os.Readlink(path)
+    log("Skipping broken symlink %s -> %s: %v", path, target, err)
+    brokenMutex.Lock()
+    brokenLinks = append(brokenLinks, brokenLink{Path: path, Target: target, Error: err.Error()})
+    brokenMutex.Unlock()
+}
+
+// sortedBrokenLinks returns the broken links ordered by path.
+func sortedBrokenLinks() []brokenLink {
+    brokenMutex.Lock()
+    defer brokenMutex.Unlock()
+    sorted := slices.Clone(brokenLinks)
+    slices.SortFunc(sorted, func(a, b brokenLink) int { return strings.Compare(a.Path, b.Path) })
+    return sorted
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+    "encoding/json"
+    "io"
+    "os"
+    "path/filepath"
+    "slices"
+    "testing"
+)
+
+func TestCheckBrokenLink(t *testing.T) {
+    setFlag(t, &brokenLinks, nil)
+    dir := t.TempDir()
+    song := filepath.Join(dir, "song.wav")
+    if err := os.WriteFile(song, nil, 0644); err != nil {
+        t.Fatal(err)
+    }
+    links := map[string]string{
+        "good.wav":     "song.wav",
+        "dangling.wav": "moved/song.wav",
+        "loop.wav":     "loop.wav",
+    }
+    for link, target := range links {
+        if err := os.Symlink(target, filepath.Join(dir, link)); err != nil {
+            t.Skipf("symlinks not supported: %v", err)
+        }
+    }
+
+    for _, name := range []string{"song.wav", "good.wav", "dangling.wav", "loop.wav"} {
+        path := filepath.Join(dir, name)
+        info, err := os.Lstat(path)
+        if err != nil {
+            t.Fatal(err)
+        }
+        checkBrokenLink(path, info)
+    }
+
+    got := sortedBrokenLinks()
+    if len(got) != 2 {
+        t.Fatalf("recorded %d broken links, want 2: %+v", len(got), got)
+    }
+    for i, want := range []string{"dangling.wav", "loop.wav"} {
+        if got[i].Path != filepath.Join(dir, want) || got[i].Target != links[want] || got[i].Error == "" {
+            t.Errorf("broken link %d = %+v, want %s -> %s with an error", i, got[i], want, links[want])
+        }
+    }
+}
+
+func TestScanReportsBrokenLinks(t *testing.T) {
+    dir := t.TempDir()
+    for _, name := range []string{"song.wav", "copy.wav"} {
+        if err := os.WriteFile(filepath.Join(dir, name), []byte("take"), 0644); err != nil {
+            t.Fatal(err)
+        }
+    }
+    links := map[string]string{
+        "dangling.wav": "moved/song.wav",
+        "loop.wav":     "loop.wav",
+    }
+    for link, target := range links {
+        if err := os.Symlink(target, filepath.Join(dir, link)); err != nil {
+            t.Skipf("symlinks not supported: %v", err)
+        }
+    }
+
+    // run writes its results to the working directory.
+    wd, err := os.Getwd()
+    if err != nil {
+        t.Fatal(err)
+    }
+    if err := os.Chdir(t.TempDir()); err != nil {
+        t.Fatal(err)
+    }
+    t.Cleanup(func() { os.Chdir(wd) })
+
+    setFlag(t, &sourceDirs, DirList{dir})
+    setFlag(t, &minSize, 0)
+    setFlag(t, &infoOut, io.Writer(io.Discard))
+    setFlag(t, &brokenLinks, nil)
+    if _, err := run(); err != nil {
+        t.Fatal(err)
+    }
+
+    data, err := os.ReadFile("dedupe-music.json")
+    if err != nil {
+        t.Fatal(err)
+    }
+    var results struct {
+        Files  []*FileInfo  `json:"files"`
+        Broken []brokenLink `json:"broken_symlinks"`
+    }
+    if err := json.Unmarshal(data, &results); err != nil {
+        t.Fatal(err)
+    }
+
+    if len(results.Broken) != 2 {
+        t.Fatalf("results list broken links %+v, want dangling.wav and loop.wav", results.Broken)
+    }
+    for i, want := range []string{"dangling.wav", "loop.wav"} {
+        if got := results.Broken[i]; got.Path != filepath.Join(dir, want) || got.Target != links[want] || got.Error == "" {
+            t.Errorf("broken link %d = %+v, want %s -> %s with an error", i, got, want, links[want])
+        }
+    }
+    var scanned []string
+    for _, group := range results.Files {
+        for _, file := range append([]*FileInfo{group}, group.Children...) {
+            scanned = append(scanned, filepath.Base(file.Path))
+        }
+    }
+    slices.Sort(scanned)
+    if want := []string{"copy.wav", "song.wav"}; !slices.Equal(scanned, want) {
+        t.Errorf("results list files %v, want %v", scanned, want)
+    }
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+    "slices"
+    "strings"
+    "sync"
+)
+
+// changedFile is a file that was deleted or modified while the scan was
+// looking at it, so no trustworthy hash could be recorded for it.
+type changedFile struct {
+    Path   string `json:"path"`
+    Reason string `json:"reason"`
+}
+
+var (
+    changedFiles []changedFile
+    changedMutex sync.Mutex
+)
+
+func recordChanged(path, reason string) {
+    log("Leaving out %s: %s", path, reason)
+    changedMutex.Lock()
+    changedFiles = append(changedFiles, changedFile{Path: path, Reason: reason})
+    changedMutex.Unlock()
+}
+
+// sortedChanged returns the changed files ordered by path.
+func sortedChanged() []changedFile {
+    changedMutex.Lock()
+    defer changedMutex.Unlock()
+    sorted := slices.Clone(changedFiles)
+    slices.SortFunc(sorted, func(a, b changedFile) int { return strings.Compare(a.Path, b.Path) })
+    return sorted
+}
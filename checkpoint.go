@@ -0,0 +1,279 @@
+package main
+
+import (
+    "bufio"
+    "compress/gzip"
+    "encoding"
+    "encoding/json"
+    "fmt"
+    "hash"
+    "io"
+    "os"
+    "strings"
+    "sync"
+    "time"
+)
+
+// checkpointRecord is one hashed file in the checkpoint file. A large file
+// still being hashed has no hashes yet; instead it records how far hashing
+// got and the digest states at that offset.
+type checkpointRecord struct {
+    Path    string            `json:"path"`
+    Size    int64             `json:"size"`
+    ModTime time.Time         `json:"mod_time"`
+    Hashes  map[string]string `json:"hashes,omitempty"`
+    Offset  int64             `json:"offset,omitempty"`
+    Skip    int64             `json:"skip,omitempty"`
+    State   map[string][]byte `json:"state,omitempty"`
+}
+
+// checkpoint appends a record for every hashed file so that an interrupted
+// scan can be resumed with -resume. Records are buffered and flushed every
+// few seconds, and when the checkpoint is closed.
+type checkpoint struct {
+    mu     sync.Mutex
+    file   *os.File
+    gz     *gzip.Writer
+    writer *bufio.Writer
+    done   chan struct{}
+
+    // resumed holds the records loaded from a previous run.
+    resumed map[string]checkpointRecord
+}
+
+// scanCheckpoint is the checkpoint for the scan in progress, if any.
+var scanCheckpoint *checkpoint
+
+// removeCheckpoint deletes the -checkpoint file once the scan it records has
+// finished. Without -checkpoint there is nothing to remove.
+func removeCheckpoint() error {
+    if checkpointPath == "" {
+        return nil
+    }
+    if err := os.Remove(checkpointPath); err != nil && !os.IsNotExist(err) {
+        return fmt.Errorf("error removing checkpoint %s: %v", checkpointPath, err)
+    }
+    return nil
+}
+
+// printResumeHint tells the user how to finish a scan that stopped early.
+func printResumeHint() {
+    if checkpointPath != "" {
+        fmt.Fprintf(infoOut, "Run again with -resume to continue the scan from %s\n", checkpointPath)
+    } else {
+        fmt.Fprintf(infoOut, "Run with -checkpoint to be able to resume a scan that stops early\n")
+    }
+}
+
+// openCheckpoint starts a checkpoint at path. With resume set, the records
+// already in the file are loaded and new ones are appended; otherwise the
+// file is started afresh. A path ending in .gz is gzip-compressed, each run
+// appending a new gzip stream.
+func openCheckpoint(path string, resume bool) (*checkpoint, error) {
+    cp := &checkpoint{
+        resumed: make(map[string]checkpointRecord),
+        done:    make(chan struct{}),
+    }
+
+    flags := os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+    if resume {
+        if err := cp.load(path); err != nil {
+            return nil, err
+        }
+        flags = os.O_WRONLY | os.O_CREATE | os.O_APPEND
+    }
+
+    file, err := os.OpenFile(path, flags, 0644)
+    if err != nil {
+        return nil, err
+    }
+    cp.file = file
+    cp.writer = bufio.NewWriter(file)
+    if strings.HasSuffix(path, ".gz") {
+        cp.gz = gzip.NewWriter(file)
+        cp.writer = bufio.NewWriter(cp.gz)
+    }
+
+    go func() {
+        ticker := time.NewTicker(5 * time.Second)
+        defer ticker.Stop()
+        for {
+            select {
+            case <-ticker.C:
+                cp.flush()
+            case <-cp.done:
+                return
+            }
+        }
+    }()
+    return cp, nil
+}
+
+func (cp *checkpoint) load(path string) error {
+    file, err := os.Open(path)
+    if os.IsNotExist(err) {
+        fmt.Fprintf(os.Stderr, "Warning: No checkpoint found at %s, starting from scratch\n", path)
+        return nil
+    }
+    if err != nil {
+        return err
+    }
+    defer file.Close()
+
+    var r io.Reader = file
+    if strings.HasSuffix(path, ".gz") {
+        gz, err := gzip.NewReader(file)
+        if err == io.EOF {
+            return nil
+        }
+        if err != nil {
+            return err
+        }
+        r = gz
+    }
+
+    scanner := bufio.NewScanner(r)
+    scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+    for scanner.Scan() {
+        var record checkpointRecord
+        // A line cut short by a crash is simply hashed again.
+        if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+            continue
+        }
+        cp.resumed[record.Path] = record
+    }
+    // A compressed checkpoint cut short by a crash ends mid-stream.
+    if err := scanner.Err(); err != nil && err != io.ErrUnexpectedEOF {
+        return err
+    }
+
+    log("Loaded %d checkpointed files from %s", len(cp.resumed), path)
+    return nil
+}
+
+// lookup returns the hashes recorded for path by a previous run, provided the
+// file has not changed since, the same -skip-header-bytes was used, and every
+// requested digest is present.
+func (cp *checkpoint) lookup(path string, size int64, modTime time.Time) (map[string]string, bool) {
+    if cp == nil {
+        return nil, false
+    }
+    record, ok := cp.resumed[path]
+    if !ok || record.Size != size || !record.ModTime.Equal(modTime) || record.Skip != skipHeaderBytes {
+        return nil, false
+    }
+    for _, name := range hashNames() {
+        if record.Hashes[name] == "" {
+            return nil, false
+        }
+    }
+    return record.Hashes, true
+}
+
+// restoreDigests loads the digest states saved part way through hashing path
+// by a previous run into digests, and returns the offset to continue from.
+// It returns 0, leaving digests untouched, if there is nothing to resume.
+func (cp *checkpoint) restoreDigests(path string, size int64, modTime time.Time, digests map[string]hash.Hash) int64 {
+    if cp == nil {
+        return 0
+    }
+    record, ok := cp.resumed[path]
+    if !ok || record.Offset == 0 || record.Size != size || !record.ModTime.Equal(modTime) || record.Skip != skipHeaderBytes {
+        return 0
+    }
+    for name := range digests {
+        if _, ok := digests[name].(encoding.BinaryUnmarshaler); !ok || record.State[name] == nil {
+            return 0
+        }
+    }
+    for name, digest := range digests {
+        if err := digest.(encoding.BinaryUnmarshaler).UnmarshalBinary(record.State[name]); err != nil {
+            for _, d := range digests {
+                d.Reset()
+            }
+            return 0
+        }
+    }
+    return record.Offset
+}
+
+// recordPartial saves the state of digests after hashing the first offset
+// bytes of path, so -resume can continue a large file part way through.
+func (cp *checkpoint) recordPartial(path string, size int64, modTime time.Time, offset int64, digests map[string]hash.Hash) {
+    if cp == nil {
+        return
+    }
+    state := make(map[string][]byte, len(digests))
+    for name, digest := range digests {
+        m, ok := digest.(encoding.BinaryMarshaler)
+        if !ok {
+            return
+        }
+        data, err := m.MarshalBinary()
+        if err != nil {
+            return
+        }
+        state[name] = data
+    }
+    cp.write(checkpointRecord{Path: path, Size: size, ModTime: modTime, Offset: offset, Skip: skipHeaderBytes, State: state})
+}
+
+// record adds a hashed file to the checkpoint.
+func (cp *checkpoint) record(path string, size int64, modTime time.Time, hashes map[string]string) {
+    if cp == nil {
+        return
+    }
+    cp.write(checkpointRecord{Path: path, Size: size, ModTime: modTime, Skip: skipHeaderBytes, Hashes: hashes})
+}
+
+func (cp *checkpoint) write(record checkpointRecord) {
+    line, err := json.Marshal(record)
+    if err != nil {
+        return
+    }
+
+    cp.mu.Lock()
+    defer cp.mu.Unlock()
+    if cp.writer == nil {
+        return
+    }
+    cp.writer.Write(line)
+    cp.writer.WriteByte('\n')
+}
+
+func (cp *checkpoint) flush() {
+    cp.mu.Lock()
+    defer cp.mu.Unlock()
+    if cp.writer != nil {
+        cp.writer.Flush()
+        if cp.gz != nil {
+            cp.gz.Flush()
+        }
+    }
+}
+
+// close flushes outstanding records and closes the checkpoint file.
+func (cp *checkpoint) close() error {
+    if cp == nil {
+        return nil
+    }
+
+    cp.mu.Lock()
+    defer cp.mu.Unlock()
+    if cp.writer == nil {
+        return nil
+    }
+    close(cp.done)
+
+    err := cp.writer.Flush()
+    if cp.gz != nil {
+        if gzErr := cp.gz.Close(); err == nil {
+            err = gzErr
+        }
+    }
+    cp.writer = nil
+    if closeErr := cp.file.Close(); err == nil {
+        err = closeErr
+    }
+    return err
+}
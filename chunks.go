@@ -0,0 +1,224 @@
+package main
+
+import (
+    "context"
+    "fmt"
+    "hash/maphash"
+    "io"
+    "sort"
+    "sync"
+)
+
+// Content-defined chunking parameters, in the style of FastCDC. Chunk
+// boundaries depend only on the bytes around them, so content shifted by a
+// prepended header still splits into the same chunks after the first one.
+const (
+    cdcMin = 2 * 1024
+    cdcAvg = 8 * 1024
+    cdcMax = 64 * 1024
+
+    // Before the average size a boundary needs 15 zero bits, after it 11,
+    // which keeps most chunks close to cdcAvg.
+    cdcMaskS = uint64(0x7fff) << 49
+    cdcMaskL = uint64(0x7ff) << 53
+
+    // Only chunks whose hash is a multiple of chunkSampleRate are kept,
+    // which estimates the shared fraction from an eighth of the chunks.
+    chunkSampleRate = 8
+
+    // Chunks found in more files than this, such as digital silence, say
+    // nothing about which files are related and are ignored.
+    maxChunkFiles = 64
+)
+
+// gearTable holds the random values the rolling hash adds for each byte. It
+// is fixed so boundaries are the same on every run.
+var gearTable = func() [256]uint64 {
+    var table [256]uint64
+    x := uint64(0x9e3779b97f4a7c15)
+    for i := range table {
+        // splitmix64
+        x += 0x9e3779b97f4a7c15
+        z := x
+        z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+        z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+        table[i] = z ^ (z >> 31)
+    }
+    return table
+}()
+
+var chunkSeed = maphash.MakeSeed()
+
+// cdcCut returns the length of the chunk at the start of data.
+func cdcCut(data []byte) int {
+    n := len(data)
+    if n <= cdcMin {
+        return n
+    }
+    n = min(n, cdcMax)
+    normal := min(n, cdcAvg)
+
+    var fp uint64
+    i := cdcMin
+    for ; i < normal; i++ {
+        fp = fp<<1 + gearTable[data[i]]
+        if fp&cdcMaskS == 0 {
+            return i + 1
+        }
+    }
+    for ; i < n; i++ {
+        fp = fp<<1 + gearTable[data[i]]
+        if fp&cdcMaskL == 0 {
+            return i + 1
+        }
+    }
+    return n
+}
+
+// chunkSketch is the sampled chunks of one file, by hash, with their sizes.
+type chunkSketch struct {
+    chunks map[uint64]int64
+    bytes  int64
+}
+
+// sketchFile splits the file at path into content-defined chunks and keeps
+// the sampled ones.
+func sketchFile(path string) (chunkSketch, error) {
+    file, err := openSource(path)
+    if err != nil {
+        return chunkSketch{}, err
+    }
+    defer file.Close()
+
+    var src io.Reader = file
+    if readLimiter != nil {
+        src = &throttledReader{ctx: context.Background(), r: src, limiter: readLimiter}
+    }
+
+    sketch := chunkSketch{chunks: make(map[uint64]int64)}
+    buf := make([]byte, 2*cdcMax)
+    n := 0
+    eof := false
+    for {
+        if !eof && n < cdcMax {
+            m, err := io.ReadFull(src, buf[n:])
+            n += m
+            if err == io.EOF || err == io.ErrUnexpectedEOF {
+                eof = true
+            } else if err != nil {
+                return chunkSketch{}, err
+            }
+        }
+        if n == 0 {
+            return sketch, nil
+        }
+
+        size := cdcCut(buf[:n])
+        if sum := maphash.Bytes(chunkSeed, buf[:size]); sum%chunkSampleRate == 0 {
+            if _, ok := sketch.chunks[sum]; !ok {
+                sketch.chunks[sum] = int64(size)
+                sketch.bytes += int64(size)
+            }
+        }
+        n = copy(buf, buf[size:n])
+    }
+}
+
+// nearDuplicate is a pair of files with different content that share at
+// least -chunk-similarity of it.
+type nearDuplicate struct {
+    a, b       *FileInfo
+    similarity float64
+}
+
+// findNearDuplicates chunks one file per distinct hash and pairs up files
+// whose shared chunks make up at least -chunk-similarity of the larger one.
+func findNearDuplicates(output []*FileInfo) []nearDuplicate {
+    var files []*FileInfo
+    seen := make(map[string]bool)
+    for _, group := range output {
+        if !seen[group.Hash] {
+            seen[group.Hash] = true
+            files = append(files, group)
+        }
+    }
+
+    sketches := make([]chunkSketch, len(files))
+    indexes := make(chan int, queueSize)
+    var wg sync.WaitGroup
+    for i := 0; i < numWorkers; i++ {
+        wg.Add(1)
+        go func() {
+            defer wg.Done()
+            for i := range indexes {
+                log("Chunking file: %s", files[i].Path)
+                sketch, err := sketchFile(files[i].Path)
+                if err != nil {
+                    printError("Unable to chunk file %s: %v\n", files[i].Path, err)
+                    continue
+                }
+                sketches[i] = sketch
+            }
+        }()
+    }
+    for i := range files {
+        indexes <- i
+    }
+    close(indexes)
+    wg.Wait()
+
+    holders := make(map[uint64][]int)
+    for i, sketch := range sketches {
+        for sum := range sketch.chunks {
+            holders[sum] = append(holders[sum], i)
+        }
+    }
+
+    shared := make(map[[2]int]int64)
+    for sum, list := range holders {
+        if len(list) < 2 || len(list) > maxChunkFiles {
+            continue
+        }
+        for x, i := range list {
+            for _, j := range list[x+1:] {
+                if scope == "per-source" && files[i].SourceRoot != files[j].SourceRoot {
+                    continue
+                }
+                shared[[2]int{i, j}] += sketches[i].chunks[sum]
+            }
+        }
+    }
+
+    var pairs []nearDuplicate
+    for pair, bytes := range shared {
+        larger := max(sketches[pair[0]].bytes, sketches[pair[1]].bytes)
+        similarity := float64(bytes) / float64(larger)
+        if similarity < chunkSimilarity {
+            continue
+        }
+        a, b := files[pair[0]], files[pair[1]]
+        log("Near-duplicate: %s ~ %s (%.0f%%)", a.Path, b.Path, similarity*100)
+        pairs = append(pairs, nearDuplicate{a: a, b: b, similarity: similarity})
+    }
+    sort.Slice(pairs, func(i, j int) bool {
+        if pairs[i].similarity != pairs[j].similarity {
+            return pairs[i].similarity > pairs[j].similarity
+        }
+        return pairs[i].a.Path < pairs[j].a.Path
+    })
+    return pairs
+}
+
+func printNearDuplicates(pairs []nearDuplicate) {
+    if len(pairs) == 0 {
+        fmt.Fprintln(infoOut, "No near-duplicates found")
+        return
+    }
+
+    fmt.Fprintln(infoOut, "Near-duplicates (different files sharing most of their content):")
+    for _, pair := range pairs {
+        fmt.Fprintf(infoOut, "  %s shared\n", yellow(fmt.Sprintf("%.0f%%", pair.similarity*100)))
+        fmt.Fprintf(infoOut, "    %s (%s)\n", pair.a.Path, formatSize(pair.a.Size))
+        fmt.Fprintf(infoOut, "    %s (%s)\n", pair.b.Path, formatSize(pair.b.Size))
+    }
+}
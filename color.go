@@ -0,0 +1,82 @@
+package main
+
+import (
+    "fmt"
+    "os"
+)
+
+const (
+    ansiReset  = "\033[0m"
+    ansiBold   = "\033[1m"
+    ansiRed    = "\033[31m"
+    ansiGreen  = "\033[32m"
+    ansiYellow = "\033[33m"
+)
+
+// Whether to color informational output and stderr, set from -color.
+var (
+    colorStdout bool
+    colorStderr bool
+)
+
+// setupColor applies -color. In auto mode each stream is colored only when it
+// is a terminal and NO_COLOR is unset, so piped output stays plain.
+func setupColor(mode string) error {
+    switch mode {
+    case "always":
+        colorStdout, colorStderr = true, true
+    case "never":
+        colorStdout, colorStderr = false, false
+    case "auto":
+        enabled := os.Getenv("NO_COLOR") == ""
+        colorStdout = enabled && isTerminal(os.Stdout)
+        if infoOut == os.Stderr {
+            colorStdout = enabled && isTerminal(os.Stderr)
+        }
+        colorStderr = enabled && isTerminal(os.Stderr)
+    default:
+        return fmt.Errorf("-color must be auto, always or never")
+    }
+    return nil
+}
+
+func isTerminal(f *os.File) bool {
+    info, err := f.Stat()
+    return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+func paint(enabled bool, code, s string) string {
+    if !enabled {
+        return s
+    }
+    return code + s + ansiReset
+}
+
+// bold, yellow and green style informational output.
+func bold(s string) string   { return paint(colorStdout, ansiBold, s) }
+func yellow(s string) string { return paint(colorStdout, ansiYellow, s) }
+func green(s string) string  { return paint(colorStdout, ansiGreen, s) }
+
+// printError writes an "Error:" line to stderr, in red when enabled, and
+// counts it for -metrics-file.
+func printError(format string, args ...interface{}) {
+    errorCount.Add(1)
+    fmt.Fprintf(os.Stderr, paint(colorStderr, ansiRed, "Error:")+" "+format, args...)
+}
+
+// printSummary prints the duplicate totals at the end of a run.
+func printSummary(stats runStats) {
+    fmt.Fprintf(infoOut, "Found %s duplicate files in %s groups, %s reclaimable\n",
+        yellow(fmt.Sprint(stats.DuplicateFiles)),
+        yellow(fmt.Sprint(stats.DuplicateGroups)),
+        green(formatSize(stats.BytesReclaimable)))
+    if stats.BytesRead > 0 && stats.DurationSeconds > 0 {
+        fmt.Fprintf(infoOut, "Read %s in %.1fs, %.1f MiB/s\n", formatSize(stats.BytesRead), stats.DurationSeconds, float64(stats.BytesRead)/(1<<20)/stats.DurationSeconds)
+    }
+    if len(stats.SlowestFiles) > 0 {
+        fmt.Fprintln(infoOut, "Slowest files to hash:")
+        for _, file := range stats.SlowestFiles {
+            fmt.Fprintf(infoOut, "  %6.2fs %8.1f MiB/s  %s\n", file.Seconds, file.MiBPerSec, file.Path)
+        }
+    }
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+    "fmt"
+    "slices"
+    "strings"
+)
+
+// comparison is the -compare report: every distinct content found under
+// either root, classified by which of the two holds it.
+type comparison struct {
+    A       string         `json:"a"`
+    B       string         `json:"b"`
+    OnlyInA []compareEntry `json:"only_in_a"`
+    OnlyInB []compareEntry `json:"only_in_b"`
+    InBoth  []compareEntry `json:"in_both"`
+}
+
+// compareEntry is one distinct content and the paths holding it on each
+// side.
+type compareEntry struct {
+    Hash string   `json:"hash"`
+    Size int64    `json:"size"`
+    A    []string `json:"a,omitempty"`
+    B    []string `json:"b,omitempty"`
+}
+
+// comparisonResult is set once a -compare scan has been classified.
+var comparisonResult *comparison
+
+// compareRoots classifies the files in output by content, ignoring names,
+// according to whether they were found under the first or second -compare
+// root.
+func compareRoots(output []*FileInfo) *comparison {
+    result := &comparison{A: compareDirs[0], B: compareDirs[1], OnlyInA: []compareEntry{}, OnlyInB: []compareEntry{}, InBoth: []compareEntry{}}
+    entries := make(map[string]*compareEntry)
+    var order []string
+    for _, group := range output {
+        for _, fileInfo := range append([]*FileInfo{group}, group.Children...) {
+            key := contentKey(fileInfo.Size, fileInfo.Hash)
+            entry, ok := entries[key]
+            if !ok {
+                entry = &compareEntry{Hash: fileInfo.Hash, Size: fileInfo.Size}
+                entries[key] = entry
+                order = append(order, key)
+            }
+            path := relativePath(fileInfo.Path)
+            if fileInfo.SourceRoot == result.A {
+                entry.A = append(entry.A, path)
+            } else {
+                entry.B = append(entry.B, path)
+            }
+        }
+    }
+
+    for _, key := range order {
+        entry := entries[key]
+        slices.Sort(entry.A)
+        slices.Sort(entry.B)
+        switch {
+        case len(entry.B) == 0:
+            result.OnlyInA = append(result.OnlyInA, *entry)
+        case len(entry.A) == 0:
+            result.OnlyInB = append(result.OnlyInB, *entry)
+        default:
+            result.InBoth = append(result.InBoth, *entry)
+        }
+    }
+    firstPath := func(e compareEntry) string { return slices.Concat(e.A, e.B)[0] }
+    for _, list := range [][]compareEntry{result.OnlyInA, result.OnlyInB, result.InBoth} {
+        slices.SortFunc(list, func(x, y compareEntry) int { return strings.Compare(firstPath(x), firstPath(y)) })
+    }
+    result.A = relativePath(result.A)
+    result.B = relativePath(result.B)
+    return result
+}
+
+// printComparison prints how many files, and how much data, fall in each
+// class.
+func printComparison(result *comparison) {
+    line := func(label string, entries []compareEntry) {
+        var size int64
+        for _, entry := range entries {
+            size += entry.Size
+        }
+        fmt.Fprintf(infoOut, "  %-10s %d distinct files, %s\n", label, len(entries), formatSize(size))
+    }
+    fmt.Fprintf(infoOut, "Comparing %s (A) with %s (B), by content:\n", result.A, result.B)
+    line("Only in A:", result.OnlyInA)
+    line("Only in B:", result.OnlyInB)
+    line("In both:", result.InBoth)
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+    "encoding/json"
+    "os"
+    "path/filepath"
+)
+
+// manifestName is the file -copy-mode representative-tagged writes in -t.
+const manifestName = "dedupe-music-manifest.json"
+
+// manifestEntry records a copied file and the duplicates left out in its
+// favor.
+type manifestEntry struct {
+    CopiedTo string   `json:"copied_to"`
+    Source   string   `json:"source"`
+    Dropped  []string `json:"dropped"`
+}
+
+// filesToCopy returns the files of group that -copy-mode sends to -t: every
+// copyable member with "all", otherwise just the canonical file.
+func filesToCopy(group *FileInfo) []*FileInfo {
+    var files []*FileInfo
+    if group.copyable() {
+        files = append(files, group)
+    }
+    if copyMode == "all" {
+        for _, child := range group.Children {
+            if child.copyable() {
+                files = append(files, child)
+            }
+        }
+    }
+    return files
+}
+
+// writeManifest writes the representative-tagged manifest to -t, listing the
+// duplicates that were not copied next to the file that was.
+func writeManifest(entries []manifestEntry) error {
+    if entries == nil {
+        entries = []manifestEntry{}
+    }
+    file, err := os.Create(filepath.Join(targetDir, manifestName))
+    if err != nil {
+        return err
+    }
+
+    encoder := json.NewEncoder(file)
+    encoder.SetIndent("", "    ")
+    if err := encoder.Encode(entries); err != nil {
+        file.Close()
+        return err
+    }
+    return file.Close()
+}
@@ -1,22 +1,42 @@
 package main
 
 import (
+    "archive/zip"
     "bufio"
+    "bytes"
+    "cmp"
+    "compress/gzip"
+    "context"
     "crypto/md5"
+    "crypto/sha1"
+    "crypto/sha256"
+    "crypto/sha512"
     "encoding/hex"
     "encoding/json"
     "errors"
     "flag"
     "fmt"
+    "hash"
     "io"
+    "iter"
+    "maps"
+    "math/bits"
     "os"
+    "os/exec"
+    "os/signal"
     "path/filepath"
+    "regexp"
     "runtime"
+    "slices"
+    "sort"
+    "strconv"
     "strings"
     "sync"
+    "sync/atomic"
+    "syscall"
     "time"
 
-    "golang.org/x/sys/unix"
+    "github.com/BurntSushi/toml"
 )
 
 // DirList is a custom type for a list of directories.
@@ -31,37 +51,523 @@ func (d *DirList) Set(value string) error {
     return nil
 }
 
+// HashList is a custom type for the digest algorithms requested with -hash.
+type HashList []string
+
+func (h *HashList) String() string {
+    return strings.Join(*h, ", ")
+}
+
+func (h *HashList) Set(value string) error {
+    name := strings.ToLower(value)
+    if _, ok := hashAlgorithms[name]; !ok {
+        return fmt.Errorf("unsupported hash algorithm %q", value)
+    }
+    for _, existing := range *h {
+        if existing == name {
+            return nil
+        }
+    }
+    *h = append(*h, name)
+    return nil
+}
+
+// Hasher computes a digest of a file's content. Any implementation added to
+// hashAlgorithms can be selected with -hash, which makes it possible to plug
+// in e.g. a content-defined chunking hash or one that looks up precomputed
+// checksums.
+type Hasher interface {
+    Hash(r io.Reader) (string, error)
+}
+
+// digestHasher is a Hasher backed by a standard library hash.Hash. These are
+// fed directly from the shared read buffer rather than through a pipe.
+type digestHasher func() hash.Hash
+
+func (d digestHasher) Hash(r io.Reader) (string, error) {
+    h := d()
+    if _, err := copyBuffer(h, r); err != nil {
+        return "", err
+    }
+    return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// SizeFlag is a size in bytes given as a number with an optional unit, e.g.
+// "500KB", "1.5GB" or "2GiB". Units are binary, so KB and KiB are both 1024
+// bytes. A bare number is taken as megabytes, as -size always has been.
+type SizeFlag int64
+
+var sizeUnits = map[string]float64{
+    "B":   1,
+    "K":   1 << 10,
+    "KB":  1 << 10,
+    "KIB": 1 << 10,
+    "M":   1 << 20,
+    "MB":  1 << 20,
+    "MIB": 1 << 20,
+    "G":   1 << 30,
+    "GB":  1 << 30,
+    "GIB": 1 << 30,
+    "T":   1 << 40,
+    "TB":  1 << 40,
+    "TIB": 1 << 40,
+}
+
+func (s *SizeFlag) String() string {
+    return formatSize(int64(*s))
+}
+
+func (s *SizeFlag) Set(value string) error {
+    value = strings.TrimSpace(value)
+    i := strings.IndexFunc(value, func(r rune) bool {
+        return !(r >= '0' && r <= '9' || r == '.')
+    })
+    number, unit := value, "MB"
+    if i >= 0 {
+        number, unit = value[:i], strings.ToUpper(strings.TrimSpace(value[i:]))
+    }
+
+    multiplier, ok := sizeUnits[unit]
+    if !ok {
+        return fmt.Errorf("unknown size unit %q", value[i:])
+    }
+    n, err := strconv.ParseFloat(number, 64)
+    if err != nil || n < 0 {
+        return fmt.Errorf("invalid size %q", value)
+    }
+    *s = SizeFlag(n * multiplier)
+    return nil
+}
+
+// ExtSizes holds per-extension minimum sizes, as in ".mp3=3MB,.wav=20MB".
+// Extensions are stored lowercased with their leading dot.
+type ExtSizes map[string]int64
+
+func (e *ExtSizes) String() string {
+    exts := make([]string, 0, len(*e))
+    for ext := range *e {
+        exts = append(exts, ext)
+    }
+    sort.Strings(exts)
+
+    pairs := make([]string, len(exts))
+    for i, ext := range exts {
+        pairs[i] = ext + "=" + formatSize((*e)[ext])
+    }
+    return strings.Join(pairs, ",")
+}
+
+func (e *ExtSizes) Set(value string) error {
+    if *e == nil {
+        *e = make(ExtSizes)
+    }
+    for _, pair := range strings.Split(value, ",") {
+        ext, size, ok := strings.Cut(pair, "=")
+        ext = strings.ToLower(strings.TrimSpace(ext))
+        if !ok || ext == "" || ext == "." {
+            return fmt.Errorf("expected .ext=size, got %q", pair)
+        }
+        if !strings.HasPrefix(ext, ".") {
+            ext = "." + ext
+        }
+        var min SizeFlag
+        if err := min.Set(size); err != nil {
+            return fmt.Errorf("invalid size in %q: %v", pair, err)
+        }
+        (*e)[ext] = int64(min)
+    }
+    return nil
+}
+
+// minSizeFor returns the minimum size for a file called name: its
+// extension's -size-ext entry if there is one, otherwise -size.
+func minSizeFor(name string) int64 {
+    if min, ok := extMinSize[strings.ToLower(filepath.Ext(name))]; ok {
+        return min
+    }
+    return int64(minSize)
+}
+
+// hashAlgorithms are the digests that can be requested with -hash. md5 is
+// used when none is requested.
+var hashAlgorithms = map[string]Hasher{
+    "md5":    digestHasher(md5.New),
+    "sha1":   digestHasher(sha1.New),
+    "sha256": digestHasher(sha256.New),
+    "sha512": digestHasher(sha512.New),
+    "blake3": digestHasher(newBlake3),
+}
+
 // FileInfo holds information about a file, including its path, hash, size, and duplicates.
 type FileInfo struct {
-    Name     string      `json:"name"`
-    Path     string      `json:"path"`
-    Hash     string      `json:"hash"`
-    Size     int64       `json:"size"`
-    Children []*FileInfo `json:"duplicates,omitempty"`
+    Name       string            `json:"name"`
+    Path       string            `json:"path"`
+    Hash       string            `json:"hash"`
+    Hashes     map[string]string `json:"hashes,omitempty"`
+    Size       int64             `json:"size"`
+    SourceRoot string            `json:"source_root"`
+    Namespace  string            `json:"namespace,omitempty"`
+    Reference  bool              `json:"reference,omitempty"`
+    InArchive  bool              `json:"in_archive,omitempty"`
+    Protected  bool              `json:"protected,omitempty"`
+    Aliases    []string          `json:"aliases,omitempty"`
+    Children   []*FileInfo       `json:"duplicates,omitempty"`
+
+    modTime     time.Time
+    fingerprint []uint32
+    copyFailed  bool
+    matchKey    string
+
+    // hashSource is "sidecar" or "checkpoint" when Hash was taken from there
+    // instead of read from the file in this run.
+    hashSource string
+}
+
+// readOnly reports whether the file must never be moved or deleted.
+func (f *FileInfo) readOnly() bool {
+    return f.Reference || f.InArchive || f.Protected
+}
+
+// copyable reports whether the file may be copied to -t. Files under -protect
+// are only shielded from deletion, so they are still copied.
+func (f *FileInfo) copyable() bool {
+    return !f.Reference && !f.InArchive
+}
+
+// runStats is the run-level metadata written by -stats-json.
+type runStats struct {
+    Version          string     `json:"version"`
+    Commit           string     `json:"commit,omitempty"`
+    BuildDate        string     `json:"build_date,omitempty"`
+    StartTime        time.Time  `json:"start_time"`
+    EndTime          time.Time  `json:"end_time"`
+    DurationSeconds  float64    `json:"duration_seconds"`
+    FilesScanned     int64      `json:"files_scanned"`
+    BytesRead        int64      `json:"bytes_read"`
+    DuplicateGroups  int        `json:"duplicate_groups"`
+    DuplicateFiles   int        `json:"duplicate_files"`
+    BytesReclaimable int64      `json:"bytes_reclaimable"`
+    Workers          int        `json:"workers"`
+    HashAlgorithm    string     `json:"hash_algorithm"`
+    SlowestFiles     []slowFile `json:"slowest_files,omitempty"`
+}
+
+// inode identifies a physical file on disk, shared by all of its hard links.
+type inode struct {
+    dev uint64
+    ino uint64
+}
+
+// fileJob is a file found by the walk that is waiting to be hashed.
+type fileJob struct {
+    path      string
+    root      string
+    reference bool
+
+    // archive is set for entries inside a zip file, whose size comes from
+    // the archive's directory rather than from stat.
+    archive bool
+
+    // size and modTime are as seen by the walk, or the archive's directory.
+    // A zero modTime means the file was not walked, as in -watch.
+    size    int64
+    modTime time.Time
 }
 
+// zipSeparator joins a zip file's path to the path of an entry inside it,
+// as in "pack.zip!/drums/kick.wav".
+const zipSeparator = "!/"
+
+// infoOut receives progress and report messages. It is stdout unless
+// -print-duplicates claims stdout for the path list.
+var infoOut io.Writer = os.Stdout
+
+// stdin is shared by every prompt so buffered input is never lost between
+// them.
+var stdin = bufio.NewReader(os.Stdin)
+
+// scanContext ends when -max-duration runs out, after which nothing more is
+// queued or hashed.
+var scanContext = context.Background()
+
+// scanIncomplete is set when -max-duration cut the scan short.
+var scanIncomplete bool
+
+// Counters updated by the workers during a scan.
 var (
-    sourceDirs        DirList
-    targetDir         string
-    minSizeMB         int64
-    logEnabled        bool
-    deleteSourceFiles bool
-    numWorkers        = runtime.NumCPU()
+    filesScanned atomic.Int64
+    bytesRead    atomic.Int64
 )
 
+var (
+    configPath          string
+    sourceDirs          DirList
+    referenceDirs       DirList
+    protectDirs         DirList
+    priorityDirs        DirList
+    targetDir           string
+    preserveTree        bool
+    reflink             bool
+    skipExisting        bool
+    continueOnCopyError bool
+    previewTree         bool
+    genScript           string
+    hashAlgos           HashList
+    renameTemplate      string
+    minSize             = SizeFlag(10 << 20)
+    extMinSize          ExtSizes
+    includeRegex        string
+    excludeRegex        string
+    includePattern      *regexp.Regexp
+    excludePattern      *regexp.Regexp
+    logEnabled          bool
+    quiet               bool
+    deleteSourceFiles   bool
+    dedupeTarget        bool
+    compareDirs         DirList
+    interactive         bool
+    forceOverwrite      bool
+    compressOutput      bool
+    tmpDir              string
+    pruneEmpty          bool
+    fingerprintMode     bool
+    fingerprintCmd      string
+    fingerprintMin      float64
+    fileTimeout         time.Duration
+    maxDuration         time.Duration
+    bufferSizeKB        int
+    maxReadMBps         float64
+    reportContent       bool
+    printDuplicates     bool
+    printKeepers        bool
+    nullDelimited       bool
+    findHash            string
+    failOnEmpty         bool
+    firstMatch          bool
+    groupBy             string
+    minDuplicates       int
+    uniqueOnly          bool
+    uniqueIn            string
+    reportTags          bool
+    detectTruncated     bool
+    duplicateDirs       bool
+    chunkSimilarity     float64
+    checkMagicBytes     bool
+    confirmBytes        bool
+    fuzzyName           bool
+    ignoreNameCase      bool
+    normalizeUnicode    bool
+    skipHidden          bool
+    followSymlinks      bool
+    followWithinRoots   bool
+    skipSilent          bool
+    silenceDB           float64
+    skipHeaderBytes     int64
+    denyHashesPath      string
+    matchMode           string
+    scope               string
+    namespace           string
+    copyMode            string
+    preserveAttrs       string
+    preserveXattr       bool
+    copyModeBits        string
+    copyPerm            os.FileMode
+    copyUID             int
+    copyGID             int
+    preferExt           string
+    extRank             map[string]int
+    scanZip             bool
+    checksumSidecar     bool
+    statsFile           string
+    metricsFile         string
+    checkpointPath      string
+    resumeScan          bool
+    retryErrorsPath     string
+    priorScan           *priorResults
+    watchMode           bool
+    watchDebounce       time.Duration
+    dbPath              string
+    showVersion         bool
+    colorMode           string
+    showProgress        bool
+    threadsPerDisk      DiskWorkers
+    numWorkers          = runtime.NumCPU()
+    queueSize           int
+    retries             int
+    lowMemory           bool
+    indexOnly           bool
+    resultsAppend       string
+    sampleVerify        float64
+    relativeTo          string
+    keepAbsOutside      bool
+    copyWorkers         int
+    execTemplate        string
+    execWorkers         int
+    execContinueOnError bool
+    benchmarkFiles      int
+)
+
+// Exit codes returned by main. Scripts and cron jobs can rely on these.
+const (
+    exitOK         = 0 // finished, no duplicates found
+    exitError      = 1 // runtime error
+    exitUsage      = 2 // bad usage or flags
+    exitDuplicates = 3 // finished, duplicates found
+)
+
+// fileExtensions are the audio file types that are scanned.
+var fileExtensions = map[string]bool{
+    ".wav":  true,
+    ".aif":  true,
+    ".aiff": true,
+    ".mp3":  true,
+}
+
+// isAllowed reports whether a file called name is one of fileExtensions.
+// Only the last extension counts, in any case, so "track.MP3" is scanned but
+// a "track.mp3.bak" backup is not. Files without an extension are skipped,
+// as their type cannot be told from the name.
+func isAllowed(name string) bool {
+    return fileExtensions[strings.ToLower(filepath.Ext(name))]
+}
+
+// flagAliases maps short flag names to the long names used in config files.
+var flagAliases = map[string]string{
+    "s": "source-dir",
+    "t": "target-dir",
+    "l": "logs",
+    "q": "quiet",
+}
+
 func init() {
-    flag.Var(&sourceDirs, "s", "Directory to scan for files to be deduped. Can be used multiple times. (Required)")
-    flag.Var(&sourceDirs, "source-dir", "Directory to scan for files to be deduped. Can be used multiple times. (Required)")
+    flag.StringVar(&configPath, "config", "", "TOML file with default flag values. (Optional)")
+
+    flag.Var(&sourceDirs, "s", "Directory to scan for files to be deduped. Can be used multiple times or as a glob. (Required)")
+    flag.Var(&sourceDirs, "source-dir", "Directory to scan for files to be deduped. Can be used multiple times or as a glob. (Required)")
+
+    flag.Var(&referenceDirs, "reference", "Read-only directory whose files are kept as the originals. Can be used multiple times. (Optional)")
+    flag.Var(&priorityDirs, "priority-dirs", "Directory whose copy of a duplicate is kept, most authoritative first. Can be used multiple times. (Optional)")
+    flag.Var(&protectDirs, "protect", "Directory whose files are never deleted or moved, even when they are duplicates. Can be used multiple times. (Optional)")
 
     flag.StringVar(&targetDir, "t", "", "Directory to copy unique files to. (Optional)")
     flag.StringVar(&targetDir, "target-dir", "", "Directory to copy unique files to. (Optional)")
-
-    flag.Int64Var(&minSizeMB, "size", 10, "Minimum file size in megabytes (MB) to consider. (Optional, default: 10)")
+    flag.StringVar(&copyMode, "copy-mode", "unique", "What -t receives: unique, all or representative-tagged. (Optional, default: unique)")
+    flag.StringVar(&preserveAttrs, "preserve", "all", "What copies to -t keep from the source: mode, times, all or none. (Optional, default: all)")
+    flag.BoolVar(&preserveXattr, "preserve-xattr", false, "Copy extended attributes, such as Finder tags and resource forks, to copies in -t. (Optional, default: false)")
+    flag.StringVar(&copyModeBits, "copy-mode-bits", "", "Octal permissions given to copies in -t, overriding the source's mode. (Optional)")
+    flag.IntVar(&copyUID, "copy-uid", -1, "User ID given to copies in -t. (Optional, default: unchanged)")
+    flag.IntVar(&copyGID, "copy-gid", -1, "Group ID given to copies in -t. (Optional, default: unchanged)")
+    flag.BoolVar(&reflink, "reflink", false, "Create copy-on-write clones instead of full copies where the filesystem supports it. (Optional, default: false)")
+    flag.BoolVar(&skipExisting, "skip-existing", false, "Don't copy files whose content is already somewhere in -t. (Optional, default: false)")
+    flag.BoolVar(&continueOnCopyError, "continue-on-copy-error", false, "Skip files that fail to copy to -t instead of stopping. (Optional, default: false)")
+    flag.BoolVar(&preserveTree, "preserve-tree", false, "Copy files into -t at their path relative to their source directory. (Optional, default: false)")
+    flag.BoolVar(&previewTree, "preview-tree", false, "Print the layout copying to -t would create instead of copying. (Optional, default: false)")
+    flag.StringVar(&genScript, "gen-script", "", "Write the copies and deletions to this shell script instead of performing them. (Optional)")
+
+    flag.BoolVar(&skipSilent, "skip-silent", false, "Leave out WAV and AIFF files whose audio is digital silence. (Optional, default: false)")
+    flag.Float64Var(&silenceDB, "silence-db", -60, "Peak level in dBFS at or below which -skip-silent treats a file as silent. (Optional, default: -60)")
+    flag.BoolVar(&skipHidden, "skip-hidden", false, "Skip hidden files and directories, and macOS metadata such as ._ files. (Optional, default: false)")
+    flag.BoolVar(&followSymlinks, "follow-symlinks", false, "Follow symbolic links to files and directories while scanning. (Optional, default: false)")
+    flag.BoolVar(&followWithinRoots, "follow-only-within-roots", false, "With -follow-symlinks, skip links whose target is outside the -s and -reference directories. (Optional, default: false)")
+    flag.StringVar(&includeRegex, "include-regex", "", "Only scan files whose full path matches this regular expression. (Optional)")
+    flag.StringVar(&excludeRegex, "exclude-regex", "", "Skip files whose full path matches this regular expression. (Optional)")
+
+    flag.StringVar(&renameTemplate, "rename-template", "{name}({n}){ext}", "Name for a copy whose name is already taken in the target directory. (Optional, default: {name}({n}){ext})")
+
+    flag.Var(&minSize, "size", "Minimum file size to consider, e.g. 500KB or 1.5GB; a bare number is MB. (Optional, default: 10MB)")
+    flag.Var(&extMinSize, "size-ext", "Minimum size for files with a given extension, as .ext=size; others use -size. Can be used multiple times. (Optional)")
 
     flag.BoolVar(&deleteSourceFiles, "delete-source-files", false, "Delete source files after processing. (Optional, default: false)")
+    flag.BoolVar(&dedupeTarget, "dedupe-target", false, "Scan -t itself and delete the duplicates found there, keeping one file per group. (Optional, default: false)")
+    flag.BoolVar(&interactive, "interactive", false, "Review each duplicate group and choose what to delete. (Optional, default: false)")
+
+    flag.BoolVar(&pruneEmpty, "prune-empty-dirs", false, "Remove directories left empty after deleting source files. (Optional, default: false)")
+
+    flag.BoolVar(&compressOutput, "compress", false, "Gzip the results and checkpoint files. (Optional, default: false)")
+    flag.BoolVar(&forceOverwrite, "force", false, "Overwrite the output JSON file if it already exists. (Optional, default: false)")
+
+    flag.Var(&hashAlgos, "hash", "Digest to compute: md5, sha1, sha256, sha512, or blake3. Can be used multiple times; the first is used for matching. Large files are hashed on every CPU when blake3 is the only digest. (Optional, default: md5)")
+
+    flag.BoolVar(&scanZip, "scan-zip", false, "Also hash audio files inside .zip archives, without extracting them. (Optional, default: false)")
+
+    flag.StringVar(&scope, "scope", "global", "Where duplicates are looked for: global (across all sources) or per-source (within each -s root). (Optional, default: global)")
+    flag.StringVar(&namespace, "namespace", "", "Tag every file with this namespace and keep it from matching files tagged with another. (Optional)")
+    flag.StringVar(&preferExt, "prefer-ext", "", "Comma-separated extensions, best first, used to choose which duplicate to keep. (Optional)")
+    flag.BoolVar(&checksumSidecar, "checksum-sidecar", false, "Take hashes from .md5/.sha256 sidecar files newer than the media instead of reading it. (Optional, default: false)")
+    flag.StringVar(&matchMode, "match", "name-hash", "How files are matched: name-hash (same name and content), name-hash-size (also the same size) or audio-props (same name, duration, sample rate and channels). (Optional, default: name-hash)")
+    flag.StringVar(&denyHashesPath, "deny-hashes", "", "File of hashes, one per line, whose files are always left out of the results. (Optional)")
+    flag.Int64Var(&skipHeaderBytes, "skip-header-bytes", 0, "Start hashing this many bytes into each file. (Optional, default: 0)")
+    flag.BoolVar(&ignoreNameCase, "case-insensitive-names", false, "Ignore case when comparing filenames, so Song.MP3 and song.mp3 can match. (Optional, default: false)")
+    flag.BoolVar(&normalizeUnicode, "normalize-unicode", false, "Compare filenames in Unicode NFC form, so composed and decomposed accents match. (Optional, default: false)")
+    flag.BoolVar(&fuzzyName, "fuzzy-name", false, "Group files by normalized filename alone, ignoring content. (Optional, default: false)")
+
+    flag.Float64Var(&sampleVerify, "sample-verify", 0, "Read in full this percentage of the duplicate groups matched on sidecar or checkpoint hashes. (Optional, default: 0)")
+    flag.BoolVar(&confirmBytes, "confirm-bytes", false, "Compare files byte for byte before treating matching hashes as duplicates. (Optional, default: false)")
+
+    flag.Var(&threadsPerDisk, "threads-per-disk", "Workers for the device holding each path, as path=count. Can be used multiple times. (Optional)")
+    flag.IntVar(&numWorkers, "workers", runtime.NumCPU(), "Workers hashing files outside the -threads-per-disk devices. (Optional, default: number of CPUs)")
+    flag.IntVar(&copyWorkers, "copy-workers", 1, "Files copied to -t at once. (Optional, default: 1)")
+    flag.IntVar(&queueSize, "queue-size", 100, "Files queued for each worker pool while the directories are walked. (Optional, default: 100)")
+    flag.IntVar(&retries, "retries", 0, "Times to retry reading or copying a file after a transient error such as a timeout. (Optional, default: 0)")
+    flag.StringVar(&resultsAppend, "results-append", "", "Append each duplicate group to this NDJSON file as it is found during the scan. (Optional)")
+    flag.BoolVar(&indexOnly, "index-only", false, "Only hash files and write one record per file to dedupe-music.ndjson, without grouping. (Optional, default: false)")
+    flag.BoolVar(&lowMemory, "low-memory", false, "Keep scanned files on disk instead of in memory and report only duplicates. (Optional, default: false)")
+    flag.IntVar(&benchmarkFiles, "benchmark", 0, "Scan a generated tree of this many files with the other settings given, print files/sec and MiB/sec, then exit. (Optional)")
+    flag.IntVar(&bufferSizeKB, "buffer-size", 256, "Size in KB of the pooled buffers used to read files. (Optional, default: 256)")
+    flag.Float64Var(&maxReadMBps, "max-read-mbps", 0, "Limit the combined read rate of all workers to this many MB per second; 0 means unlimited. (Optional, default: 0)")
+
+    flag.DurationVar(&maxDuration, "max-duration", 0, "Stop scanning after this long and write the partial results, e.g. 30m. (Optional, default: no limit)")
+    flag.DurationVar(&fileTimeout, "file-timeout", 0, "Give up hashing a file after this long, e.g. 30s or 5m. (Optional, default: no limit)")
+
+    flag.BoolVar(&fingerprintMode, "fingerprint", false, "Group files by acoustic fingerprint to find re-encoded duplicates. (Optional, default: false)")
+    flag.StringVar(&fingerprintCmd, "fingerprint-cmd", "fpcalc", "Command used to compute acoustic fingerprints. (Optional, default: fpcalc)")
+    flag.Float64Var(&fingerprintMin, "fingerprint-threshold", 0.85, "Minimum fingerprint similarity (0-1) to treat files as duplicates. (Optional, default: 0.85)")
+
+    flag.BoolVar(&reportTags, "report-tags", false, "List duplicate groups where a duplicate MP3 has richer ID3 tags than the file kept. (Optional, default: false)")
+    flag.BoolVar(&printDuplicates, "print-duplicates", false, "Print the paths of deletable duplicates to stdout, one per line. (Optional, default: false)")
+    flag.BoolVar(&printKeepers, "print-keepers", false, "Print the paths of the files to keep, one per group, to stdout, one per line. (Optional, default: false)")
+    flag.BoolVar(&nullDelimited, "0", false, "With -print-duplicates, -print-keepers or -find-hash, end each path with a NUL byte instead of a newline. (Optional, default: false)")
+    flag.Var(&compareDirs, "compare", "Directory to compare by content; give exactly two to list files only in the first, only in the second, or in both. (Optional)")
+    flag.BoolVar(&failOnEmpty, "fail-on-empty", false, "Exit with an error if no eligible files were found. (Optional, default: false)")
+    flag.StringVar(&findHash, "find-hash", "", "Only print the paths of files with this hash, then exit without grouping, copying or deleting. (Optional)")
+    flag.BoolVar(&firstMatch, "first-match", false, "With -find-hash, stop the scan at the first matching file. (Optional, default: false)")
+    flag.StringVar(&relativeTo, "relative-to", "", "Write paths in the results file relative to this directory. (Optional)")
+    flag.BoolVar(&keepAbsOutside, "keep-absolute-outside", false, "With -relative-to, keep absolute paths for files outside it instead of refusing to run. (Optional, default: false)")
+    flag.StringVar(&groupBy, "group-by", "", "Arrange the results file by \"dir\" instead of by duplicate group. (Optional)")
+    flag.BoolVar(&uniqueOnly, "unique-only", false, "Only report files that have no duplicates. (Optional, default: false)")
+    flag.StringVar(&uniqueIn, "unique-in", "", "With -unique-only, only report files under this directory. (Optional)")
+    flag.IntVar(&minDuplicates, "min-duplicates", 0, "Only report files with at least this many duplicates. (Optional, default: 0)")
+    flag.BoolVar(&checkMagicBytes, "check-magic", false, "List files whose extension does not match the audio format they contain. (Optional, default: false)")
+    flag.Float64Var(&chunkSimilarity, "chunk-similarity", 0, "List different files sharing at least this fraction (0-1) of their content. (Optional, default: off)")
+    flag.BoolVar(&detectTruncated, "detect-truncated", false, "List files that are an exact prefix of a larger file with the same name. (Optional, default: false)")
+    flag.BoolVar(&duplicateDirs, "duplicate-dirs", false, "List directories that hold the same files by content as another directory. (Optional, default: false)")
+    flag.BoolVar(&reportContent, "report-content-dupes", false, "Also list files with identical content but different names. (Optional, default: false)")
+
+    flag.StringVar(&execTemplate, "exec", "", "Command to run for each duplicate once the scan is done. (Optional)")
+    flag.IntVar(&execWorkers, "exec-workers", 1, "Number of -exec commands run at once. (Optional, default: 1)")
+    flag.BoolVar(&execContinueOnError, "exec-continue-on-error", false, "Keep running -exec commands after one fails. (Optional, default: false)")
+    flag.StringVar(&dbPath, "db", "", "Also write the results to this SQLite database. (Optional)")
+
+    flag.BoolVar(&watchMode, "watch", false, "Keep running after the scan and process new or changed files as they appear. (Optional, default: false)")
+    flag.DurationVar(&watchDebounce, "watch-debounce", 2*time.Second, "How long a watched file must stay unchanged before it is processed. (Optional, default: 2s)")
+
+    flag.StringVar(&tmpDir, "tmp-dir", "", "Directory for temporary files. (Optional)")
+    flag.StringVar(&checkpointPath, "checkpoint", "", "File recording hashed files so an interrupted scan can be resumed. (Optional)")
+    flag.BoolVar(&resumeScan, "resume", false, "Resume an interrupted scan, skipping files already in the checkpoint. (Optional, default: false)")
+    flag.StringVar(&retryErrorsPath, "retry-errors", "", "Process only the files listed under \"errors\" in this earlier results file and merge them into its groups. (Optional)")
+
+    flag.StringVar(&statsFile, "stats-json", "", "Write run metadata (timings, counts, reclaimable bytes) to this JSON file. (Optional)")
+
+    flag.BoolVar(&showProgress, "progress", false, "Show a progress line on stderr while hashing. (Optional, default: false)")
+    flag.StringVar(&colorMode, "color", "auto", "Color terminal output: auto, always or never. (Optional, default: auto)")
+    flag.BoolVar(&showVersion, "version", false, "Print the version, commit and build date, then exit.")
+
+    flag.StringVar(&metricsFile, "metrics-file", "", "Write run metrics in Prometheus text format to this file. (Optional)")
 
     flag.BoolVar(&logEnabled, "l", false, "Enable detailed logging to the console. (Optional, default: false)")
     flag.BoolVar(&logEnabled, "logs", false, "Enable detailed logging to the console. (Optional, default: false)")
+    flag.BoolVar(&quiet, "q", false, "Print nothing but errors and warnings. (Optional, default: false)")
+    flag.BoolVar(&quiet, "quiet", false, "Print nothing but errors and warnings. (Optional, default: false)")
 
     flag.Usage = customUsage
 }
@@ -71,22 +577,399 @@ func customUsage() {
     fmt.Fprintf(os.Stderr, "Usage:\n")
     fmt.Fprintf(os.Stderr, "  dedupe-music [options]\n\n")
     fmt.Fprintf(os.Stderr, "Options:\n")
+    fmt.Fprintf(os.Stderr, "  -config string\n")
+    fmt.Fprintf(os.Stderr, "        TOML file with default flag values. (Optional)\n")
+    fmt.Fprintf(os.Stderr, "        Keys are long flag names; flags given on the command line win.\n")
+    fmt.Fprintf(os.Stderr, "        Example: -config \"$HOME/.dedupe-music.toml\"\n\n")
     fmt.Fprintf(os.Stderr, "  -s, -source-dir value\n")
-    fmt.Fprintf(os.Stderr, "        Directory to scan for files to be deduped. Can be used multiple times. (Required)\n")
-    fmt.Fprintf(os.Stderr, "        Example: -s \"$HOME/Music/\" -s \"$HOME/Downloads/\"\n\n")
+    fmt.Fprintf(os.Stderr, "        Directory to scan for files to be deduped. Can be used multiple times or as a glob. (Required)\n")
+    fmt.Fprintf(os.Stderr, "        Example: -s \"$HOME/Music/\" -s \"$HOME/Downloads/\"\n")
+    fmt.Fprintf(os.Stderr, "        Example: -s \"/media/*/Music\"\n\n")
+    fmt.Fprintf(os.Stderr, "  -reference value\n")
+    fmt.Fprintf(os.Stderr, "        Read-only directory whose files are kept as the originals. Can be used multiple times. (Optional)\n")
+    fmt.Fprintf(os.Stderr, "        Reference files are never copied, moved, or deleted; only -s files are acted on.\n")
+    fmt.Fprintf(os.Stderr, "        Example: -reference \"$HOME/Music/Master\" -s \"$HOME/Music/Incoming\"\n\n")
+    fmt.Fprintf(os.Stderr, "  -protect value\n")
+    fmt.Fprintf(os.Stderr, "        Directory whose files are never deleted or moved, even when they are duplicates. Can be used multiple times. (Optional)\n")
+    fmt.Fprintf(os.Stderr, "        A protected file is kept in preference to its copies elsewhere, which are acted on instead.\n")
+    fmt.Fprintf(os.Stderr, "        Example: -s \"$HOME/Music\" -protect \"$HOME/Music/Keep\"\n\n")
+    fmt.Fprintf(os.Stderr, "  -priority-dirs value\n")
+    fmt.Fprintf(os.Stderr, "        Directory whose copy of a duplicate is kept, most authoritative first. Can be used multiple times. (Optional)\n")
+    fmt.Fprintf(os.Stderr, "        A file in an earlier directory wins over one in a later directory or none, whatever its size or\n")
+    fmt.Fprintf(os.Stderr, "        scan order. -reference and -protect still come first; ties fall back to -prefer-ext.\n")
+    fmt.Fprintf(os.Stderr, "        Example: -priority-dirs \"$HOME/Music/Library\" -priority-dirs \"$HOME/Music/Incoming\"\n\n")
     fmt.Fprintf(os.Stderr, "  -t, -target-dir string\n")
     fmt.Fprintf(os.Stderr, "        Directory to copy unique files to. (Optional)\n")
+    fmt.Fprintf(os.Stderr, "        Must not be inside a -s or -reference directory, where copies would be scanned again.\n")
     fmt.Fprintf(os.Stderr, "        Example: -t \"$HOME/deduped-files-dir\"\n\n")
+    fmt.Fprintf(os.Stderr, "  -copy-mode string\n")
+    fmt.Fprintf(os.Stderr, "        What -t receives: unique, all or representative-tagged. (Optional, default: unique)\n")
+    fmt.Fprintf(os.Stderr, "        unique copies one file per duplicate group; all copies every file, renaming clashes with -rename-template;\n")
+    fmt.Fprintf(os.Stderr, "        representative-tagged copies one file per group and lists the others in -t/%s.\n\n", manifestName)
+    fmt.Fprintf(os.Stderr, "  -preserve string\n")
+    fmt.Fprintf(os.Stderr, "        What copies to -t keep from the source: mode, times, all or none. (Optional, default: all)\n")
+    fmt.Fprintf(os.Stderr, "        times keeps the access and modification times; without it copies are dated when they were made.\n\n")
+    fmt.Fprintf(os.Stderr, "  -preserve-xattr\n")
+    fmt.Fprintf(os.Stderr, "        Copy extended attributes, such as Finder tags and resource forks, to copies in -t. (Optional, default: false)\n")
+    fmt.Fprintf(os.Stderr, "        Supported on macOS and Linux. Where the source or -t filesystem has no extended attributes, a warning\n")
+    fmt.Fprintf(os.Stderr, "        is printed once and files are copied without them.\n\n")
+    fmt.Fprintf(os.Stderr, "  -copy-mode-bits string\n")
+    fmt.Fprintf(os.Stderr, "        Octal permissions given to copies in -t, overriding the source's mode. (Optional)\n")
+    fmt.Fprintf(os.Stderr, "        Example: -copy-mode-bits 0664 for a group-writable shared archive\n\n")
+    fmt.Fprintf(os.Stderr, "  -copy-uid int\n")
+    fmt.Fprintf(os.Stderr, "        User ID given to copies in -t. (Optional, default: unchanged)\n")
+    fmt.Fprintf(os.Stderr, "        Changing the owner usually needs root. Not supported on Windows.\n\n")
+    fmt.Fprintf(os.Stderr, "  -copy-gid int\n")
+    fmt.Fprintf(os.Stderr, "        Group ID given to copies in -t. (Optional, default: unchanged)\n")
+    fmt.Fprintf(os.Stderr, "        Any group the user running the copy belongs to can be given without root. Not supported on Windows.\n\n")
+    fmt.Fprintf(os.Stderr, "  -reflink\n")
+    fmt.Fprintf(os.Stderr, "        Create copy-on-write clones instead of full copies where the filesystem supports it. (Optional, default: false)\n")
+    fmt.Fprintf(os.Stderr, "        Works on APFS, Btrfs and XFS when -t is on the same volume; other copies fall back to a full copy.\n\n")
+    fmt.Fprintf(os.Stderr, "  -skip-existing\n")
+    fmt.Fprintf(os.Stderr, "        Don't copy files whose content is already somewhere in -t. (Optional, default: false)\n")
+    fmt.Fprintf(os.Stderr, "        Existing target files are hashed first; matches are reported as already present, whatever their name.\n\n")
+    fmt.Fprintf(os.Stderr, "  -continue-on-copy-error\n")
+    fmt.Fprintf(os.Stderr, "        Skip files that fail to copy to -t instead of stopping. (Optional, default: false)\n")
+    fmt.Fprintf(os.Stderr, "        Files that were not copied, and their duplicates, are never deleted by -delete-source-files.\n\n")
+    fmt.Fprintf(os.Stderr, "  -preserve-tree\n")
+    fmt.Fprintf(os.Stderr, "        Copy files into -t at their path relative to their source directory. (Optional, default: false)\n")
+    fmt.Fprintf(os.Stderr, "        Without it every file is copied directly into -t.\n\n")
+    fmt.Fprintf(os.Stderr, "  -preview-tree\n")
+    fmt.Fprintf(os.Stderr, "        Print the layout copying to -t would create instead of copying. (Optional, default: false)\n")
+    fmt.Fprintf(os.Stderr, "        Shows per-directory file counts and sizes. Nothing is copied or deleted.\n\n")
+    fmt.Fprintf(os.Stderr, "  -gen-script string\n")
+    fmt.Fprintf(os.Stderr, "        Write the copies and deletions to this shell script instead of performing them. (Optional)\n")
+    fmt.Fprintf(os.Stderr, "        Writes a commented sh script of mkdir, cp and rm commands with every path quoted, to review and run\n")
+    fmt.Fprintf(os.Stderr, "        yourself. Requires -t or -delete-source-files; nothing is copied or deleted.\n")
+    fmt.Fprintf(os.Stderr, "        Example: -gen-script dedupe.sh\n\n")
+    fmt.Fprintf(os.Stderr, "  -skip-hidden\n")
+    fmt.Fprintf(os.Stderr, "        Skip hidden files and directories, and macOS metadata such as ._ files. (Optional, default: false)\n")
+    fmt.Fprintf(os.Stderr, "        Hidden means a name starting with a dot, or the hidden or system attribute on Windows.\n")
+    fmt.Fprintf(os.Stderr, "        __MACOSX folders in -scan-zip archives are skipped too.\n\n")
+    fmt.Fprintf(os.Stderr, "  -follow-symlinks\n")
+    fmt.Fprintf(os.Stderr, "        Follow symbolic links to files and directories while scanning. (Optional, default: false)\n")
+    fmt.Fprintf(os.Stderr, "        Files are listed at the link's path. A directory reached twice, such as through a link back up\n")
+    fmt.Fprintf(os.Stderr, "        the tree, is scanned once. Without it links are skipped. -watch does not follow links.\n\n")
+    fmt.Fprintf(os.Stderr, "  -follow-only-within-roots\n")
+    fmt.Fprintf(os.Stderr, "        With -follow-symlinks, skip links whose target is outside the -s and -reference directories. (Optional, default: false)\n")
+    fmt.Fprintf(os.Stderr, "        Targets are compared with every link resolved, so a link to /etc, or to a link to it, is never followed.\n\n")
+    fmt.Fprintf(os.Stderr, "  -skip-silent\n")
+    fmt.Fprintf(os.Stderr, "        Leave out WAV and AIFF files whose audio is digital silence. (Optional, default: false)\n")
+    fmt.Fprintf(os.Stderr, "        %d windows spread across the samples are read; a file whose peak never exceeds -silence-db\n", silenceWindows)
+    fmt.Fprintf(os.Stderr, "        is not hashed and is listed under \"skipped_silent\" in the results instead.\n\n")
+    fmt.Fprintf(os.Stderr, "  -silence-db value\n")
+    fmt.Fprintf(os.Stderr, "        Peak level in dBFS at or below which -skip-silent treats a file as silent. (Optional, default: -60)\n")
+    fmt.Fprintf(os.Stderr, "        Example: -silence-db -90 to skip only files that are silent apart from dither\n\n")
+    fmt.Fprintf(os.Stderr, "  -include-regex string\n")
+    fmt.Fprintf(os.Stderr, "        Only scan files whose full path matches this regular expression. (Optional)\n")
+    fmt.Fprintf(os.Stderr, "        Example: -include-regex \"/Masters/\"\n\n")
+    fmt.Fprintf(os.Stderr, "  -exclude-regex string\n")
+    fmt.Fprintf(os.Stderr, "        Skip files whose full path matches this regular expression. (Optional)\n")
+    fmt.Fprintf(os.Stderr, "        Example: -exclude-regex \"(?i)remix\"\n\n")
+    fmt.Fprintf(os.Stderr, "  -rename-template string\n")
+    fmt.Fprintf(os.Stderr, "        Name for a copy whose name is already taken in the target directory. (Optional, default: {name}({n}){ext})\n")
+    fmt.Fprintf(os.Stderr, "        Placeholders: {name} base name, {n} attempt number, {ext} extension with dot, {hash} file hash.\n")
+    fmt.Fprintf(os.Stderr, "        Example: -rename-template \"{name}-{hash}{ext}\"\n\n")
     fmt.Fprintf(os.Stderr, "  -size value\n")
-    fmt.Fprintf(os.Stderr, "        Minimum file size in megabytes (MB) to consider. (Optional, default: 10)\n")
-    fmt.Fprintf(os.Stderr, "        Example: -size 5 (this will only check files 5 MB or larger)\n\n")
+    fmt.Fprintf(os.Stderr, "        Minimum file size to consider, e.g. 500KB or 1.5GB; a bare number is MB. (Optional, default: 10MB)\n")
+    fmt.Fprintf(os.Stderr, "        Units are B, KB, MB, GB and TB (or KiB, MiB, ...), all in powers of 1024.\n")
+    fmt.Fprintf(os.Stderr, "        Example: -size 5 (this will only check files 5 MB or larger)\n")
+    fmt.Fprintf(os.Stderr, "        Example: -size 500KB\n\n")
+    fmt.Fprintf(os.Stderr, "  -size-ext value\n")
+    fmt.Fprintf(os.Stderr, "        Minimum size for files with a given extension, as .ext=size; others use -size. Can be used multiple times. (Optional)\n")
+    fmt.Fprintf(os.Stderr, "        Sizes take the same units as -size. In the config file use a table: [size-ext] with \".mp3\" = \"3MB\".\n")
+    fmt.Fprintf(os.Stderr, "        Example: -size-ext .mp3=3MB,.wav=20MB\n\n")
     fmt.Fprintf(os.Stderr, "  -delete-source-files\n")
     fmt.Fprintf(os.Stderr, "        Delete source files after processing. (Optional, default: false)\n")
+    fmt.Fprintf(os.Stderr, "        After the scan, shows how many files would be deleted and how much space freed, then asks for confirmation.\n")
     fmt.Fprintf(os.Stderr, "        WARNING: Use with caution! This will delete files!\n\n")
+    fmt.Fprintf(os.Stderr, "  -dedupe-target\n")
+    fmt.Fprintf(os.Stderr, "        Scan -t itself and delete the duplicates found there, keeping one file per group. (Optional, default: false)\n")
+    fmt.Fprintf(os.Stderr, "        Cleans up an archive that has collected duplicates over repeated runs. -t is scanned in place of -s,\n")
+    fmt.Fprintf(os.Stderr, "        nothing is copied, and deletion is confirmed as with -delete-source-files.\n")
+    fmt.Fprintf(os.Stderr, "        Example: -t \"$HOME/deduped-files-dir\" -dedupe-target\n\n")
+    fmt.Fprintf(os.Stderr, "  -interactive\n")
+    fmt.Fprintf(os.Stderr, "        Review each duplicate group and choose what to delete. (Optional, default: false)\n")
+    fmt.Fprintf(os.Stderr, "        Choices are applied immediately; quit at any prompt to leave the remaining groups untouched.\n")
+    fmt.Fprintf(os.Stderr, "        Deleting in all remaining groups at once is not offered with -fuzzy-name (without -confirm-bytes),\n")
+    fmt.Fprintf(os.Stderr, "        -match audio-props or -fingerprint, whose groups can hold different content.\n")
+    fmt.Fprintf(os.Stderr, "        Cannot be combined with -delete-source-files, -watch, -print-duplicates, -print-keepers or -quiet.\n\n")
+    fmt.Fprintf(os.Stderr, "  -prune-empty-dirs\n")
+    fmt.Fprintf(os.Stderr, "        Remove directories left empty after deleting source files. (Optional, default: false)\n")
+    fmt.Fprintf(os.Stderr, "        Source directories themselves are never removed.\n\n")
+    fmt.Fprintf(os.Stderr, "  -force\n")
+    fmt.Fprintf(os.Stderr, "        Overwrite the output JSON file if it already exists. (Optional, default: false)\n")
+    fmt.Fprintf(os.Stderr, "        Without it, an existing dedupe-music.json (or -db database) is never clobbered.\n\n")
+    fmt.Fprintf(os.Stderr, "  -compress\n")
+    fmt.Fprintf(os.Stderr, "        Gzip the results and checkpoint files. (Optional, default: false)\n")
+    fmt.Fprintf(os.Stderr, "        Results go to dedupe-music.json.gz and \".gz\" is added to -checkpoint. A -checkpoint ending\n")
+    fmt.Fprintf(os.Stderr, "        in .gz is compressed even without -compress.\n\n")
+    fmt.Fprintf(os.Stderr, "  -hash value\n")
+    fmt.Fprintf(os.Stderr, "        Digest to compute: md5, sha1, sha256, sha512, or blake3. Can be used multiple times. (Optional, default: md5)\n")
+    fmt.Fprintf(os.Stderr, "        The first is used for matching; all are computed in one read and listed under \"hashes\".\n")
+    fmt.Fprintf(os.Stderr, "        Files over 64MB are hashed on every CPU when blake3 is the only digest.\n")
+    fmt.Fprintf(os.Stderr, "        Example: -hash md5 -hash sha256\n\n")
+    fmt.Fprintf(os.Stderr, "  -scan-zip\n")
+    fmt.Fprintf(os.Stderr, "        Also hash audio files inside .zip archives, without extracting them. (Optional, default: false)\n")
+    fmt.Fprintf(os.Stderr, "        Entries are reported as archive.zip!/inner/file.wav and are never copied or deleted.\n\n")
+    fmt.Fprintf(os.Stderr, "  -checksum-sidecar\n")
+    fmt.Fprintf(os.Stderr, "        Take hashes from .md5/.sha256 sidecar files newer than the media instead of reading it. (Optional, default: false)\n")
+    fmt.Fprintf(os.Stderr, "        Looks for song.wav.md5 or song.md5 (one per -hash algorithm) in GNU or BSD checksum format.\n")
+    fmt.Fprintf(os.Stderr, "        A digest is used only if its line names the file, or if it is the only digest and names none.\n")
+    fmt.Fprintf(os.Stderr, "        WARNING: Sidecars are trusted as-is; a stale or wrong one makes files match incorrectly.\n\n")
+    fmt.Fprintf(os.Stderr, "  -scope string\n")
+    fmt.Fprintf(os.Stderr, "        Where duplicates are looked for: global (across all sources) or per-source (within each -s root). (Optional, default: global)\n")
+    fmt.Fprintf(os.Stderr, "        Use per-source to clean up each drive independently. Cannot be combined with -reference.\n\n")
+    fmt.Fprintf(os.Stderr, "  -namespace string\n")
+    fmt.Fprintf(os.Stderr, "        Tag every file with this namespace and keep it from matching files tagged with another. (Optional)\n")
+    fmt.Fprintf(os.Stderr, "        The namespace is part of the grouping key and written with each file, so results for several\n")
+    fmt.Fprintf(os.Stderr, "        clients or projects can share one store without identical files colliding.\n")
+    fmt.Fprintf(os.Stderr, "        Example: -namespace client-a\n\n")
+    fmt.Fprintf(os.Stderr, "  -prefer-ext string\n")
+    fmt.Fprintf(os.Stderr, "        Comma-separated extensions, best first, used to choose which duplicate to keep. (Optional)\n")
+    fmt.Fprintf(os.Stderr, "        Ties are broken by the larger file, then the older modification time. Requires -match audio-props\n")
+    fmt.Fprintf(os.Stderr, "        or -fingerprint, the only modes that group files with different extensions.\n")
+    fmt.Fprintf(os.Stderr, "        Example: -prefer-ext wav,aiff,flac,mp3 -match audio-props\n\n")
+    fmt.Fprintf(os.Stderr, "  -match string\n")
+    fmt.Fprintf(os.Stderr, "        How files are matched: name-hash (same name and content), name-hash-size (also the same size) or audio-props (same name, duration, sample rate and channels). (Optional, default: name-hash)\n")
+    fmt.Fprintf(os.Stderr, "        name-hash-size guards against hash collisions by also requiring the sizes to match; with -fuzzy-name\n")
+    fmt.Fprintf(os.Stderr, "        it requires the normalized name and the size to match.\n")
+    fmt.Fprintf(os.Stderr, "        audio-props reads WAV, AIFF and MP3 headers and ignores the extension, so \"Song.wav\" and a re-encoded\n")
+    fmt.Fprintf(os.Stderr, "        \"Song.mp3\" match. Much cheaper than -fingerprint.\n")
+    fmt.Fprintf(os.Stderr, "        Cannot be combined with -delete-source-files or -dedupe-target.\n\n")
+    fmt.Fprintf(os.Stderr, "  -deny-hashes string\n")
+    fmt.Fprintf(os.Stderr, "        File of hashes, one per line, whose files are always left out of the results. (Optional)\n")
+    fmt.Fprintf(os.Stderr, "        Any -hash digest may be listed; md5sum/sha256sum output works as is. Matching files are never\n")
+    fmt.Fprintf(os.Stderr, "        copied or deleted and are listed under \"denied\" in the results.\n")
+    fmt.Fprintf(os.Stderr, "        Example: -deny-hashes \"$HOME/junk-hashes.txt\"\n\n")
+    fmt.Fprintf(os.Stderr, "  -skip-header-bytes int\n")
+    fmt.Fprintf(os.Stderr, "        Start hashing this many bytes into each file. (Optional, default: 0)\n")
+    fmt.Fprintf(os.Stderr, "        Ignores a fixed-size header that varies between otherwise identical files, e.g. 44 for a canonical WAV.\n")
+    fmt.Fprintf(os.Stderr, "        Files no longer than this are reported as errors. Cannot be combined with -checksum-sidecar.\n\n")
+    fmt.Fprintf(os.Stderr, "  -case-insensitive-names\n")
+    fmt.Fprintf(os.Stderr, "        Ignore case when comparing filenames, so Song.MP3 and song.mp3 can match. (Optional, default: false)\n")
+    fmt.Fprintf(os.Stderr, "        Contents must still be identical. -fuzzy-name and -match audio-props already ignore case.\n\n")
+    fmt.Fprintf(os.Stderr, "  -normalize-unicode\n")
+    fmt.Fprintf(os.Stderr, "        Compare filenames in Unicode NFC form, so composed and decomposed accents match. (Optional, default: false)\n")
+    fmt.Fprintf(os.Stderr, "        macOS often stores an accented letter as the letter plus a combining accent (NFD), where Linux and\n")
+    fmt.Fprintf(os.Stderr, "        Windows store a single character (NFC); the names look the same but would not otherwise match.\n")
+    fmt.Fprintf(os.Stderr, "        Contents must still be identical. Applies to -fuzzy-name and -match audio-props too.\n\n")
+    fmt.Fprintf(os.Stderr, "  -fuzzy-name\n")
+    fmt.Fprintf(os.Stderr, "        Group files by normalized filename alone, ignoring content. (Optional, default: false)\n")
+    fmt.Fprintf(os.Stderr, "        \"Track 01.mp3\", \"Track 01 (1).mp3\" and \"track_01.mp3\" group together even if their bytes differ.\n")
+    fmt.Fprintf(os.Stderr, "        Cannot be combined with -delete-source-files or -dedupe-target unless -confirm-bytes is also given.\n\n")
+    fmt.Fprintf(os.Stderr, "  -sample-verify value\n")
+    fmt.Fprintf(os.Stderr, "        Read in full this percentage of the duplicate groups matched on sidecar or checkpoint hashes. (Optional, default: 0)\n")
+    fmt.Fprintf(os.Stderr, "        Checks that -checksum-sidecar and -resume can be trusted for your files. If any sampled file no\n")
+    fmt.Fprintf(os.Stderr, "        longer matches its hash, the run stops before anything is copied or deleted.\n")
+    fmt.Fprintf(os.Stderr, "        Example: -sample-verify 5\n\n")
+    fmt.Fprintf(os.Stderr, "  -confirm-bytes\n")
+    fmt.Fprintf(os.Stderr, "        Compare files byte for byte before treating matching hashes as duplicates. (Optional, default: false)\n")
+    fmt.Fprintf(os.Stderr, "        Rules out hash collisions at the cost of reading each duplicate again.\n\n")
+    fmt.Fprintf(os.Stderr, "  -threads-per-disk value\n")
+    fmt.Fprintf(os.Stderr, "        Workers for the device holding each path, as path=count. Can be used multiple times. (Optional)\n")
+    fmt.Fprintf(os.Stderr, "        Files on other devices share the default pool of one worker per CPU. Not supported on Windows.\n")
+    fmt.Fprintf(os.Stderr, "        Example: -threads-per-disk /Volumes/SSD=8,/Volumes/HDD1=1\n\n")
+    fmt.Fprintf(os.Stderr, "  -workers int\n")
+    fmt.Fprintf(os.Stderr, "        Workers hashing files outside the -threads-per-disk devices. (Optional, default: number of CPUs)\n")
+    fmt.Fprintf(os.Stderr, "        Also the number of files fingerprinted at once.\n\n")
+    fmt.Fprintf(os.Stderr, "  -copy-workers int\n")
+    fmt.Fprintf(os.Stderr, "        Files copied to -t at once. (Optional, default: 1)\n")
+    fmt.Fprintf(os.Stderr, "        Raise it for an SSD target; a single spinning disk is usually fastest with 1.\n\n")
+    fmt.Fprintf(os.Stderr, "  -queue-size int\n")
+    fmt.Fprintf(os.Stderr, "        Files queued for each worker pool while the directories are walked. (Optional, default: 100)\n\n")
+    fmt.Fprintf(os.Stderr, "  -retries int\n")
+    fmt.Fprintf(os.Stderr, "        Times to retry reading or copying a file after a transient error such as a timeout. (Optional, default: 0)\n")
+    fmt.Fprintf(os.Stderr, "        The wait doubles from 100ms between attempts. Errors such as a missing file or a disk I/O error\n")
+    fmt.Fprintf(os.Stderr, "        (EIO) are never retried, as trying again only delays the run.\n")
+    fmt.Fprintf(os.Stderr, "        Example: -retries 3 (for a flaky network mount)\n\n")
+    fmt.Fprintf(os.Stderr, "  -index-only\n")
+    fmt.Fprintf(os.Stderr, "        Only hash files and write one record per file to dedupe-music.ndjson, without grouping. (Optional, default: false)\n")
+    fmt.Fprintf(os.Stderr, "        Each line is a JSON object with path, size, hash and mtime, for feeding into other tools.\n")
+    fmt.Fprintf(os.Stderr, "        Nothing is copied, deleted or reported; -compress, -force, -hash and -resume apply as usual.\n\n")
+    fmt.Fprintf(os.Stderr, "  -low-memory\n")
+    fmt.Fprintf(os.Stderr, "        Keep scanned files on disk instead of in memory and report only duplicates. (Optional, default: false)\n")
+    fmt.Fprintf(os.Stderr, "        For libraries with millions of files. Files are written to -tmp-dir while scanning and grouped a\n")
+    fmt.Fprintf(os.Stderr, "        slice at a time afterwards, so memory grows with the number of duplicates rather than of files.\n")
+    fmt.Fprintf(os.Stderr, "        Files with more than one hard link are also remembered, so each inode is hashed once.\n")
+    fmt.Fprintf(os.Stderr, "        Cannot be combined with options that need every file: -t, -delete-source-files, -watch, -db,\n")
+    fmt.Fprintf(os.Stderr, "        -unique-only, -group-by, -print-keepers, -results-append, -confirm-bytes, -fingerprint,\n")
+    fmt.Fprintf(os.Stderr, "        -report-content-dupes, -detect-truncated, -duplicate-dirs or -chunk-similarity.\n\n")
+    fmt.Fprintf(os.Stderr, "  -benchmark int\n")
+    fmt.Fprintf(os.Stderr, "        Scan a generated tree of this many files with the other settings given, print files/sec and MiB/sec, then exit. (Optional)\n")
+    fmt.Fprintf(os.Stderr, "        The tree is the same on every run and is removed afterwards; -s is not needed. Files are freshly\n")
+    fmt.Fprintf(os.Stderr, "        written, so they are mostly read from the page cache: this measures hashing rather than the disk.\n")
+    fmt.Fprintf(os.Stderr, "        Example: -benchmark 500 -workers 4 -buffer-size 1024\n\n")
+    fmt.Fprintf(os.Stderr, "  -buffer-size value\n")
+    fmt.Fprintf(os.Stderr, "        Size in KB of the pooled buffers used to read files. (Optional, default: 256)\n")
+    fmt.Fprintf(os.Stderr, "        Buffers are reused across workers for hashing and copying.\n\n")
+    fmt.Fprintf(os.Stderr, "  -max-read-mbps value\n")
+    fmt.Fprintf(os.Stderr, "        Limit the combined read rate of all workers to this many MB per second; 0 means unlimited. (Optional, default: 0)\n")
+    fmt.Fprintf(os.Stderr, "        Useful for running in the background against a shared drive.\n\n")
+    fmt.Fprintf(os.Stderr, "  -file-timeout duration\n")
+    fmt.Fprintf(os.Stderr, "        Give up hashing a file after this long. (Optional, default: no limit)\n")
+    fmt.Fprintf(os.Stderr, "        Example: -file-timeout 5m (a stalled drive can no longer hang a worker)\n\n")
+    fmt.Fprintf(os.Stderr, "  -max-duration duration\n")
+    fmt.Fprintf(os.Stderr, "        Stop scanning after this long and write the partial results. (Optional, default: no limit)\n")
+    fmt.Fprintf(os.Stderr, "        Files not hashed in time are left out; the results say \"scan_complete\": false and the\n")
+    fmt.Fprintf(os.Stderr, "        -checkpoint is kept, so -resume picks up where the scan stopped. Cannot be combined with -watch.\n")
+    fmt.Fprintf(os.Stderr, "        Example: -max-duration 30m\n\n")
+    fmt.Fprintf(os.Stderr, "  -fingerprint\n")
+    fmt.Fprintf(os.Stderr, "        Group files by acoustic fingerprint to find re-encoded duplicates. (Optional, default: false)\n")
+    fmt.Fprintf(os.Stderr, "        Requires Chromaprint's fpcalc (or a compatible -fingerprint-cmd) and decodes every file, so it is slow.\n")
+    fmt.Fprintf(os.Stderr, "        Cannot be combined with -delete-source-files or -dedupe-target.\n\n")
+    fmt.Fprintf(os.Stderr, "  -fingerprint-cmd string\n")
+    fmt.Fprintf(os.Stderr, "        Command used to compute acoustic fingerprints. (Optional, default: fpcalc)\n")
+    fmt.Fprintf(os.Stderr, "        It is run as \"<cmd> -raw -json <file>\" and must print fpcalc-compatible JSON.\n\n")
+    fmt.Fprintf(os.Stderr, "  -fingerprint-threshold value\n")
+    fmt.Fprintf(os.Stderr, "        Minimum fingerprint similarity (0-1) to treat files as duplicates. (Optional, default: 0.85)\n\n")
+    fmt.Fprintf(os.Stderr, "  -print-duplicates\n")
+    fmt.Fprintf(os.Stderr, "        Print the paths of deletable duplicates to stdout, one per line. (Optional, default: false)\n")
+    fmt.Fprintf(os.Stderr, "        All other messages go to stderr. Files kept and -reference files are never listed.\n")
+    fmt.Fprintf(os.Stderr, "        Example: dedupe-music -s ~/Music -print-duplicates -0 | xargs -0 rm\n\n")
+    fmt.Fprintf(os.Stderr, "  -print-keepers\n")
+    fmt.Fprintf(os.Stderr, "        Print the paths of the files to keep, one per group, to stdout, one per line. (Optional, default: false)\n")
+    fmt.Fprintf(os.Stderr, "        The complement of -print-duplicates: the file kept from each duplicate group and every file without\n")
+    fmt.Fprintf(os.Stderr, "        duplicates. Files inside -scan-zip archives are not listed. Cannot be combined with -print-duplicates.\n")
+    fmt.Fprintf(os.Stderr, "        Example: dedupe-music -s ~/Music -print-keepers > keep.txt; rsync -a --files-from=keep.txt / /Volumes/Backup\n\n")
+    fmt.Fprintf(os.Stderr, "  -fail-on-empty\n")
+    fmt.Fprintf(os.Stderr, "        Exit with an error if no eligible files were found. (Optional, default: false)\n")
+    fmt.Fprintf(os.Stderr, "        An empty scan is always warned about, with the filters that may explain it; this also fails\n")
+    fmt.Fprintf(os.Stderr, "        the run, so a scheduled job with the wrong -s, -size or regex does not pass unnoticed.\n\n")
+    fmt.Fprintf(os.Stderr, "  -compare value\n")
+    fmt.Fprintf(os.Stderr, "        Directory to compare by content; give exactly two to list files only in the first, only in the second, or in both. (Optional)\n")
+    fmt.Fprintf(os.Stderr, "        Names are ignored. The results file holds a \"comparison\" object instead of \"files\", and nothing\n")
+    fmt.Fprintf(os.Stderr, "        is copied or deleted. Cannot be combined with -s or -reference.\n")
+    fmt.Fprintf(os.Stderr, "        Example: -compare /Volumes/Music -compare /Volumes/Backup/Music\n\n")
+    fmt.Fprintf(os.Stderr, "  -find-hash string\n")
+    fmt.Fprintf(os.Stderr, "        Only print the paths of files with this hash, then exit without grouping, copying or deleting. (Optional)\n")
+    fmt.Fprintf(os.Stderr, "        Give a digest from any -hash algorithm in use. Exits with 3 if a file matched and 0 if none did.\n")
+    fmt.Fprintf(os.Stderr, "        Example: dedupe-music -s ~/Music -find-hash \"$(md5sum < kick.wav | cut -d' ' -f1)\"\n\n")
+    fmt.Fprintf(os.Stderr, "  -first-match\n")
+    fmt.Fprintf(os.Stderr, "        With -find-hash, stop the scan at the first matching file. (Optional, default: false)\n")
+    fmt.Fprintf(os.Stderr, "        With several workers the file printed is the first one hashed, not necessarily the first in scan order.\n\n")
+    fmt.Fprintf(os.Stderr, "  -0\n")
+    fmt.Fprintf(os.Stderr, "        With -print-duplicates, -print-keepers or -find-hash, end each path with a NUL byte instead of a newline. (Optional, default: false)\n")
+    fmt.Fprintf(os.Stderr, "        Keeps paths containing spaces or newlines intact for xargs -0.\n\n")
+    fmt.Fprintf(os.Stderr, "  -relative-to string\n")
+    fmt.Fprintf(os.Stderr, "        Write paths in the results file relative to this directory. (Optional)\n")
+    fmt.Fprintf(os.Stderr, "        Makes results portable between hosts that mount the library in different places. Paths use forward\n")
+    fmt.Fprintf(os.Stderr, "        slashes. Every -s and -reference directory must be inside it unless -keep-absolute-outside is given.\n")
+    fmt.Fprintf(os.Stderr, "        Example: -s /Volumes/Music/Incoming -relative-to /Volumes/Music\n\n")
+    fmt.Fprintf(os.Stderr, "  -keep-absolute-outside\n")
+    fmt.Fprintf(os.Stderr, "        With -relative-to, keep absolute paths for files outside it instead of refusing to run. (Optional, default: false)\n\n")
+    fmt.Fprintf(os.Stderr, "  -group-by string\n")
+    fmt.Fprintf(os.Stderr, "        Arrange the results file by \"dir\" instead of by duplicate group. (Optional)\n")
+    fmt.Fprintf(os.Stderr, "        Each directory lists its files and their copies elsewhere, and is marked\n")
+    fmt.Fprintf(os.Stderr, "        all_duplicated when every file in it exists in another directory.\n\n")
+    fmt.Fprintf(os.Stderr, "  -min-duplicates value\n")
+    fmt.Fprintf(os.Stderr, "        Only report files with at least this many duplicates. (Optional, default: 0)\n")
+    fmt.Fprintf(os.Stderr, "        Filters the JSON output and -db; -t and -delete-source-files still act on every file.\n\n")
+    fmt.Fprintf(os.Stderr, "  -unique-only\n")
+    fmt.Fprintf(os.Stderr, "        Only report files that have no duplicates. (Optional, default: false)\n")
+    fmt.Fprintf(os.Stderr, "        Files from -reference directories are never listed. Filters the JSON output and -db like -min-duplicates.\n\n")
+    fmt.Fprintf(os.Stderr, "  -unique-in string\n")
+    fmt.Fprintf(os.Stderr, "        With -unique-only, only report files under this directory. (Optional)\n")
+    fmt.Fprintf(os.Stderr, "        Example: -s ~/Music -s ~/Incoming -unique-only -unique-in ~/Incoming (what in Incoming is new)\n\n")
+    fmt.Fprintf(os.Stderr, "  -report-tags\n")
+    fmt.Fprintf(os.Stderr, "        List duplicate groups where a duplicate MP3 has richer ID3 tags than the file kept. (Optional, default: false)\n")
+    fmt.Fprintf(os.Stderr, "        Title, artist, album, track, year, genre and album art are compared.\n")
+    fmt.Fprintf(os.Stderr, "        The report is printed before anything is copied or deleted.\n\n")
+    fmt.Fprintf(os.Stderr, "  -check-magic\n")
+    fmt.Fprintf(os.Stderr, "        List files whose extension does not match the audio format they contain. (Optional, default: false)\n")
+    fmt.Fprintf(os.Stderr, "        Recognizes WAV, AIFF, MP3, FLAC and Ogg from the bytes read while hashing, e.g. a WAV renamed to .mp3.\n\n")
+    fmt.Fprintf(os.Stderr, "  -detect-truncated\n")
+    fmt.Fprintf(os.Stderr, "        List files that are an exact prefix of a larger file with the same name. (Optional, default: false)\n")
+    fmt.Fprintf(os.Stderr, "        Catches interrupted downloads. Names are compared as with -fuzzy-name.\n")
+    fmt.Fprintf(os.Stderr, "        The report is printed before anything is copied or deleted.\n\n")
+    fmt.Fprintf(os.Stderr, "  -duplicate-dirs\n")
+    fmt.Fprintf(os.Stderr, "        List directories that hold the same files by content as another directory. (Optional, default: false)\n")
+    fmt.Fprintf(os.Stderr, "        Subdirectories are compared too; file names are not. Only scanned files count, so files skipped by\n")
+    fmt.Fprintf(os.Stderr, "        -size or the extension and regex filters are not compared. The report is printed before anything is copied or deleted.\n\n")
+    fmt.Fprintf(os.Stderr, "  -chunk-similarity value\n")
+    fmt.Fprintf(os.Stderr, "        List different files sharing at least this fraction (0-1) of their content. (Optional, default: off)\n")
+    fmt.Fprintf(os.Stderr, "        Files are split into content-defined chunks, so a WAV with an added BWF chunk still matches the\n")
+    fmt.Fprintf(os.Stderr, "        original. Reads every file again. The report is printed before anything is copied or deleted.\n")
+    fmt.Fprintf(os.Stderr, "        Example: -chunk-similarity 0.9\n\n")
+    fmt.Fprintf(os.Stderr, "  -report-content-dupes\n")
+    fmt.Fprintf(os.Stderr, "        Also list files with identical content but different names. (Optional, default: false)\n")
+    fmt.Fprintf(os.Stderr, "        This is reporting only and does not change how duplicates are grouped.\n\n")
+    fmt.Fprintf(os.Stderr, "  -exec string\n")
+    fmt.Fprintf(os.Stderr, "        Command to run for each duplicate once the scan is done. (Optional)\n")
+    fmt.Fprintf(os.Stderr, "        Placeholders: {canonical} file kept, {duplicate} its duplicate, {hash} their hash. The command is run\n")
+    fmt.Fprintf(os.Stderr, "        directly, not by a shell; quote words to keep them together. Groups are filtered by -min-duplicates.\n")
+    fmt.Fprintf(os.Stderr, "        Example: -exec \"mediadb link --keep {canonical} --dupe {duplicate}\"\n\n")
+    fmt.Fprintf(os.Stderr, "  -exec-workers int\n")
+    fmt.Fprintf(os.Stderr, "        Number of -exec commands run at once. (Optional, default: 1)\n\n")
+    fmt.Fprintf(os.Stderr, "  -exec-continue-on-error\n")
+    fmt.Fprintf(os.Stderr, "        Keep running -exec commands after one fails. (Optional, default: false)\n")
+    fmt.Fprintf(os.Stderr, "        Without it, the first failure stops any further commands and the run exits with an error.\n\n")
+    fmt.Fprintf(os.Stderr, "  -db string\n")
+    fmt.Fprintf(os.Stderr, "        Also write the results to this SQLite database. (Optional)\n")
+    fmt.Fprintf(os.Stderr, "        Creates \"files\", \"duplicates\" and \"hashes\" tables for ad-hoc queries.\n")
+    fmt.Fprintf(os.Stderr, "        Example: -db \"$HOME/dedupe-music.db\"\n\n")
+    fmt.Fprintf(os.Stderr, "  -watch\n")
+    fmt.Fprintf(os.Stderr, "        Keep running after the scan and process new or changed files as they appear. (Optional, default: false)\n")
+    fmt.Fprintf(os.Stderr, "        New files get the same copy/delete actions and the results file is kept up to date.\n")
+    fmt.Fprintf(os.Stderr, "        Stop with Ctrl-C. Cannot be combined with -fingerprint.\n\n")
+    fmt.Fprintf(os.Stderr, "  -watch-debounce duration\n")
+    fmt.Fprintf(os.Stderr, "        How long a watched file must stay unchanged before it is processed. (Optional, default: 2s)\n\n")
+    fmt.Fprintf(os.Stderr, "  -tmp-dir string\n")
+    fmt.Fprintf(os.Stderr, "        Directory for temporary files. (Optional)\n")
+    fmt.Fprintf(os.Stderr, "        Holds the partly written results and -metrics-file, the -low-memory spill files and the\n")
+    fmt.Fprintf(os.Stderr, "        -benchmark files. By default the first are written next to the final file and renamed into\n")
+    fmt.Fprintf(os.Stderr, "        place, and the others go to the system temp directory. Hashes are not cached between runs.\n")
+    fmt.Fprintf(os.Stderr, "        Must be writable; if it is on another filesystem the final files are copied into place instead.\n")
+    fmt.Fprintf(os.Stderr, "        Example: -tmp-dir /var/tmp/dedupe\n\n")
+    fmt.Fprintf(os.Stderr, "  -checkpoint string\n")
+    fmt.Fprintf(os.Stderr, "        File recording hashed files so an interrupted scan can be resumed. (Optional)\n")
+    fmt.Fprintf(os.Stderr, "        It is updated every few seconds during the scan and removed once the run finishes. Without it\n")
+    fmt.Fprintf(os.Stderr, "        no checkpoint is written. A checkpoint left by an interrupted scan is only replaced with -force.\n")
+    fmt.Fprintf(os.Stderr, "        Example: -checkpoint \"$HOME/.dedupe-music.checkpoint\"\n\n")
+    fmt.Fprintf(os.Stderr, "  -resume\n")
+    fmt.Fprintf(os.Stderr, "        Resume an interrupted scan, skipping files already in the -checkpoint. (Optional, default: false)\n")
+    fmt.Fprintf(os.Stderr, "        Files that changed size or modification time since are hashed again; large files continue part way through.\n\n")
+    fmt.Fprintf(os.Stderr, "  -retry-errors string\n")
+    fmt.Fprintf(os.Stderr, "        Process only the files listed under \"errors\" in this earlier results file and merge them into its groups. (Optional)\n")
+    fmt.Fprintf(os.Stderr, "        The roots come from the file, so -s and -reference are not given. Files that fail again stay under\n")
+    fmt.Fprintf(os.Stderr, "        \"errors\" in the updated file, which is replaced in place without needing -force. Run it from the\n")
+    fmt.Fprintf(os.Stderr, "        same directory if the file has relative paths.\n")
+    fmt.Fprintf(os.Stderr, "        Example: -retry-errors dedupe-music.json\n\n")
+    fmt.Fprintf(os.Stderr, "  -results-append string\n")
+    fmt.Fprintf(os.Stderr, "        Append each duplicate group to this NDJSON file as it is found during the scan. (Optional)\n")
+    fmt.Fprintf(os.Stderr, "        A line with the group's hash, size and files is written whenever a file joins a group, so findings\n")
+    fmt.Fprintf(os.Stderr, "        survive a crash. A group's first file never changes, and its last line is the most complete.\n")
+    fmt.Fprintf(os.Stderr, "        Cannot be combined with -low-memory.\n")
+    fmt.Fprintf(os.Stderr, "        Example: -results-append \"$HOME/dedupe-found.ndjson\"\n\n")
+    fmt.Fprintf(os.Stderr, "  -stats-json string\n")
+    fmt.Fprintf(os.Stderr, "        Write run metadata (timings, counts, reclaimable bytes) to this JSON file. (Optional)\n")
+    fmt.Fprintf(os.Stderr, "        The file is replaced on every run.\n")
+    fmt.Fprintf(os.Stderr, "        Example: -stats-json \"$HOME/dedupe-stats.json\"\n\n")
+    fmt.Fprintf(os.Stderr, "  -metrics-file string\n")
+    fmt.Fprintf(os.Stderr, "        Write run metrics in Prometheus text format to this file. (Optional)\n")
+    fmt.Fprintf(os.Stderr, "        Point it into the node exporter's textfile collector directory to scrape scheduled runs.\n")
+    fmt.Fprintf(os.Stderr, "        Example: -metrics-file /var/lib/node_exporter/textfile/dedupe.prom\n\n")
     fmt.Fprintf(os.Stderr, "  -l, -logs\n")
     fmt.Fprintf(os.Stderr, "        Enable detailed logging to the console. (Optional, default: false)\n\n")
+    fmt.Fprintf(os.Stderr, "  -q, -quiet\n")
+    fmt.Fprintf(os.Stderr, "        Print nothing but errors and warnings. (Optional, default: false)\n")
+    fmt.Fprintf(os.Stderr, "        Meant for cron jobs: check the exit code instead. Overrides -l and -progress.\n")
+    fmt.Fprintf(os.Stderr, "        -print-duplicates and -print-keepers still print their paths. Cannot be combined with -interactive.\n\n")
+    fmt.Fprintf(os.Stderr, "  -progress\n")
+    fmt.Fprintf(os.Stderr, "        Show a progress line on stderr while hashing. (Optional, default: false)\n")
+    fmt.Fprintf(os.Stderr, "        Files over 64 MiB are hashed in chunks, so the line also shows how far each one has got.\n\n")
+    fmt.Fprintf(os.Stderr, "  -color string\n")
+    fmt.Fprintf(os.Stderr, "        Color terminal output: auto, always or never. (Optional, default: auto)\n")
+    fmt.Fprintf(os.Stderr, "        auto colors only when writing to a terminal and NO_COLOR is unset.\n\n")
+    fmt.Fprintf(os.Stderr, "  -version\n")
+    fmt.Fprintf(os.Stderr, "        Print the version, commit and build date, then exit.\n\n")
     fmt.Fprintf(os.Stderr, "  -h, -help\n")
     fmt.Fprintf(os.Stderr, "        Show this help message\n\n")
+    fmt.Fprintf(os.Stderr, "Exit codes:\n")
+    fmt.Fprintf(os.Stderr, "  0  Success, no duplicates found\n")
+    fmt.Fprintf(os.Stderr, "  1  Runtime error\n")
+    fmt.Fprintf(os.Stderr, "  2  Bad usage or flags\n")
+    fmt.Fprintf(os.Stderr, "  3  Success, duplicates found\n\n")
 }
 
 func main() {
@@ -94,285 +977,2775 @@ func main() {
 
     if len(os.Args) == 1 || containsHelpFlag() {
         flag.Usage()
-        os.Exit(0)
+        os.Exit(exitOK)
     }
 
-    if len(sourceDirs) == 0 {
-        fmt.Fprintf(os.Stderr, "Error: Source (-s or -source-dir) directories are required.\n")
-        flag.Usage()
-        os.Exit(1)
+    if showVersion {
+        fmt.Println(versionString())
+        os.Exit(exitOK)
     }
 
-    if deleteSourceFiles {
-        fmt.Print("Enter the word 'permanent' and hit enter to confirm: ")
-        reader := bufio.NewReader(os.Stdin)
-        input, _ := reader.ReadString('\n')
-        input = strings.TrimSpace(input)
-        if input != "permanent" {
-            fmt.Fprintf(os.Stderr, "Error: Deletion not confirmed. Exiting.\n")
-            os.Exit(1)
+    if configPath != "" {
+        if err := loadConfig(configPath); err != nil {
+            printError("%v\n", err)
+            os.Exit(exitUsage)
         }
     }
 
-    if err := run(); err != nil {
-        fmt.Fprintf(os.Stderr, "Error: %v\n", err)
-        os.Exit(1)
+    if printDuplicates || printKeepers {
+        infoOut = os.Stderr
+    }
+    if quiet {
+        infoOut = io.Discard
+        logEnabled = false
+        showProgress = false
     }
 
-    os.Exit(0)
-}
+    if err := setupColor(colorMode); err != nil {
+        printError("%v\n", err)
+        os.Exit(exitUsage)
+    }
 
-func run() error {
-    log("Starting dedupe-music program")
+    var err error
+    if sourceDirs, err = expandGlobs(sourceDirs); err != nil {
+        printError("%v\n", err)
+        os.Exit(exitUsage)
+    }
+    if referenceDirs, err = expandGlobs(referenceDirs); err != nil {
+        printError("%v\n", err)
+        os.Exit(exitUsage)
+    }
 
-    outputFile := "dedupe-music.json"
+    // -dedupe-target scans -t as the only source and deletes the duplicates
+    // it finds there, keeping each group's first file.
+    if dedupeTarget {
+        if targetDir == "" {
+            printError("-dedupe-target requires -t.\n")
+            os.Exit(exitUsage)
+        }
+        if len(sourceDirs) > 0 || deleteSourceFiles || previewTree || watchMode || interactive || isFlagSet("copy-mode") {
+            printError("-dedupe-target cannot be combined with -s, -delete-source-files, -preview-tree, -watch, -interactive or -copy-mode.\n")
+            os.Exit(exitUsage)
+        }
+        sourceDirs = []string{targetDir}
+        targetDir = ""
+        deleteSourceFiles = true
+    }
 
-    if targetDir != "" {
-        err := os.MkdirAll(targetDir, os.ModePerm)
-        if err != nil {
-            return fmt.Errorf("error creating output directory %s: %v", targetDir, err)
+    // Only one file of each group is copied before the rest are deleted, so
+    // groups that may hold different content must never be deleted.
+    if deleteSourceFiles {
+        if inexact := inexactMatch(); inexact != "" {
+            action := "-delete-source-files"
+            if dedupeTarget {
+                action = "-dedupe-target"
+            }
+            printError("%s cannot be combined with %s, which can group files whose content differs.\n", action, inexact)
+            os.Exit(exitUsage)
         }
-        log("Output directory created or exists: %s", targetDir)
     }
 
-    minSizeBytes := minSizeMB * 1024 * 1024
+    // -compare scans its two directories as the sources and classifies their
+    // files instead of grouping duplicates.
+    if len(compareDirs) > 0 {
+        if len(compareDirs) != 2 {
+            printError("-compare needs exactly two directories.\n")
+            os.Exit(exitUsage)
+        }
+        var conflicts []string
+        for _, name := range []string{
+            "source-dir", "reference", "target-dir", "delete-source-files", "dedupe-target", "interactive", "watch", "db", "exec",
+            "print-duplicates", "print-keepers", "index-only", "low-memory", "results-append", "gen-script", "retry-errors",
+            "find-hash", "fingerprint", "group-by", "unique-only", "min-duplicates", "match", "fuzzy-name", "scope",
+        } {
+            if isFlagSet(name) {
+                conflicts = append(conflicts, "-"+name)
+            }
+        }
+        if len(conflicts) > 0 {
+            printError("-compare cannot be combined with %s.\n", strings.Join(conflicts, ", "))
+            os.Exit(exitUsage)
+        }
+        if _, ok := overlappingRoot(compareDirs[0], compareDirs[1:]); ok {
+            printError("-compare directory %s is inside %s.\n", compareDirs[0], compareDirs[1])
+            os.Exit(exitUsage)
+        }
+        if _, ok := overlappingRoot(compareDirs[1], compareDirs[:1]); ok {
+            printError("-compare directory %s is inside %s.\n", compareDirs[1], compareDirs[0])
+            os.Exit(exitUsage)
+        }
+        sourceDirs = slices.Clone(compareDirs)
+    }
 
-    fileExtensions := map[string]bool{
-        ".wav":  true,
-        ".aif":  true,
-        ".aiff": true,
-        ".mp3":  true,
+    if retryErrorsPath != "" {
+        if len(sourceDirs) > 0 || len(referenceDirs) > 0 || dedupeTarget || lowMemory || indexOnly || watchMode || resumeScan {
+            printError("-retry-errors cannot be combined with -s, -reference, -dedupe-target, -low-memory, -index-only, -watch or -resume.\n")
+            os.Exit(exitUsage)
+        }
+        if priorScan, err = loadPriorResults(retryErrorsPath); err != nil {
+            printError("Unable to read -retry-errors file %s: %v\n", retryErrorsPath, err)
+            os.Exit(exitUsage)
+        }
+        referenceDirs, sourceDirs = priorRoots(priorScan)
+        if len(sourceDirs) == 0 && len(referenceDirs) == 0 {
+            printError("-retry-errors file %s lists no files.\n", retryErrorsPath)
+            os.Exit(exitUsage)
+        }
     }
 
-    fileMap := make(map[string]*FileInfo)
-    var fileMapMutex sync.Mutex
+    if len(sourceDirs) == 0 && benchmarkFiles == 0 {
+        printError("Source (-s or -source-dir) directories are required.\n")
+        flag.Usage()
+        os.Exit(exitUsage)
+    }
 
-    fileChan := make(chan string, 100)
-    var wg sync.WaitGroup
+    // Copies written inside a scanned directory would be found again by the
+    // next run, and each run would add another layer of them.
+    if targetDir != "" {
+        if root, ok := overlappingRoot(targetDir, sourceDirs); ok {
+            printError("Target directory %s is inside source directory %s; copies would be scanned again on the next run.\n", targetDir, root)
+            os.Exit(exitUsage)
+        }
+        if root, ok := overlappingRoot(targetDir, referenceDirs); ok {
+            printError("Target directory %s is inside reference directory %s; copies would be scanned again on the next run.\n", targetDir, root)
+            os.Exit(exitUsage)
+        }
+    }
 
-    for i := 0; i < numWorkers; i++ {
-        wg.Add(1)
-        go worker(fileChan, fileExtensions, fileMap, &fileMapMutex, &wg)
+    if includeRegex != "" {
+        if includePattern, err = regexp.Compile(includeRegex); err != nil {
+            printError("Invalid -include-regex: %v\n", err)
+            os.Exit(exitUsage)
+        }
+    }
+    if excludeRegex != "" {
+        if excludePattern, err = regexp.Compile(excludeRegex); err != nil {
+            printError("Invalid -exclude-regex: %v\n", err)
+            os.Exit(exitUsage)
+        }
     }
 
-    for _, dir := range sourceDirs {
-        log("Scanning directory: %s", dir)
-        err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
-            if err != nil {
-                if errors.Is(err, os.ErrPermission) {
-                    return nil
-                }
-                return fmt.Errorf("error accessing %s: %v", path, err)
-            }
+    if printDuplicates && printKeepers {
+        printError("-print-duplicates cannot be combined with -print-keepers.\n")
+        os.Exit(exitUsage)
+    }
+    if nullDelimited && !printDuplicates && !printKeepers && findHash == "" {
+        printError("-0 requires -print-duplicates, -print-keepers or -find-hash.\n")
+        os.Exit(exitUsage)
+    }
 
-            if !info.Mode().IsRegular() || info.Size() < minSizeBytes {
-                return nil
-            }
+    if retries < 0 {
+        printError("-retries must not be negative.\n")
+        os.Exit(exitUsage)
+    }
 
-            ext := strings.ToLower(filepath.Ext(info.Name()))
-            if fileExtensions[ext] {
-                fileChan <- path
-            }
-            return nil
-        })
-        if err != nil {
-            return fmt.Errorf("error walking directory %s: %v", dir, err)
-        }
+    if silenceDB > 0 {
+        printError("-silence-db must not be above 0.\n")
+        os.Exit(exitUsage)
     }
 
-    close(fileChan)
-    wg.Wait()
+    if sampleVerify < 0 || sampleVerify > 100 {
+        printError("-sample-verify must be between 0 and 100.\n")
+        os.Exit(exitUsage)
+    }
 
-    var output []*FileInfo
+    if keepAbsOutside && relativeTo == "" {
+        printError("-keep-absolute-outside requires -relative-to.\n")
+        os.Exit(exitUsage)
+    }
+    if relativeTo != "" && !keepAbsOutside {
+        if outside := rootsOutside(append(slices.Clone(sourceDirs), referenceDirs...)); len(outside) > 0 {
+            printError("%s is not inside -relative-to %s (use -keep-absolute-outside to keep absolute paths for it).\n", strings.Join(outside, ", "), relativeTo)
+            os.Exit(exitUsage)
+        }
+    }
 
-    for _, fileInfo := range fileMap {
-        output = append(output, fileInfo)
-        if targetDir != "" {
-            log("Copying file: %s", fileInfo.Path)
-            err := copyFile(fileInfo.Path, targetDir, fileInfo)
-            if err != nil {
-                return fmt.Errorf("error copying file %s: %v", fileInfo.Path, err)
+    if groupBy != "" && groupBy != "dir" {
+        printError("-group-by must be dir.\n")
+        os.Exit(exitUsage)
+    }
+
+    if uniqueOnly && minDuplicates > 0 {
+        printError("-unique-only cannot be combined with -min-duplicates.\n")
+        os.Exit(exitUsage)
+    }
+    if firstMatch && findHash == "" {
+        printError("-first-match requires -find-hash.\n")
+        os.Exit(exitUsage)
+    }
+    if findHash != "" {
+        var conflicts []string
+        for _, name := range []string{
+            "target-dir", "delete-source-files", "dedupe-target", "interactive", "watch", "db", "exec", "print-duplicates", "print-keepers",
+            "index-only", "low-memory", "results-append", "gen-script", "retry-errors", "fingerprint", "group-by", "unique-only",
+            "min-duplicates", "confirm-bytes", "report-tags", "detect-truncated", "duplicate-dirs", "chunk-similarity",
+            "report-content-dupes", "sample-verify", "stats-json", "metrics-file",
+        } {
+            if isFlagSet(name) {
+                conflicts = append(conflicts, "-"+name)
             }
-            log("Successfully copied file: %s", fileInfo.Path)
+        }
+        if len(conflicts) > 0 {
+            printError("-find-hash cannot be combined with %s.\n", strings.Join(conflicts, ", "))
+            os.Exit(exitUsage)
         }
     }
-
-    if deleteSourceFiles {
-        if err := deleteFiles(output); err != nil {
-            return fmt.Errorf("error deleting files: %v", err)
+    if indexOnly {
+        var conflicts []string
+        for _, name := range []string{
+            "target-dir", "delete-source-files", "interactive", "watch", "db", "exec", "print-duplicates", "print-keepers", "low-memory", "results-append",
+            "fingerprint", "group-by", "unique-only", "min-duplicates", "confirm-bytes", "report-tags", "check-magic",
+            "detect-truncated", "duplicate-dirs", "chunk-similarity", "report-content-dupes", "stats-json", "metrics-file",
+        } {
+            if isFlagSet(name) {
+                conflicts = append(conflicts, "-"+name)
+            }
+        }
+        if len(conflicts) > 0 {
+            printError("-index-only cannot be combined with %s.\n", strings.Join(conflicts, ", "))
+            os.Exit(exitUsage)
         }
     }
-
-    if err := writeJSONToFile(outputFile, output); err != nil {
-        return fmt.Errorf("error writing JSON to file: %v", err)
+    if lowMemory && (targetDir != "" || deleteSourceFiles || watchMode || dbPath != "" || uniqueOnly || groupBy != "" ||
+        printKeepers || resultsAppend != "" || confirmBytes || fingerprintMode || reportContent || detectTruncated || duplicateDirs || chunkSimilarity > 0) {
+        printError("-low-memory cannot be combined with -t, -delete-source-files, -watch, -db, -unique-only, -group-by, -print-keepers, -results-append, -confirm-bytes, -fingerprint, -report-content-dupes, -detect-truncated, -duplicate-dirs or -chunk-similarity.\n")
+        os.Exit(exitUsage)
+    }
+    if uniqueIn != "" && !uniqueOnly {
+        printError("-unique-in requires -unique-only.\n")
+        os.Exit(exitUsage)
     }
 
-    fmt.Printf("Results written to %s\n", outputFile)
-    if targetDir != "" {
-        fmt.Printf("Files copied to %s\n", targetDir)
+    if minDuplicates < 0 {
+        printError("-min-duplicates must not be negative.\n")
+        os.Exit(exitUsage)
     }
 
-    return nil
-}
+    if interactive && (deleteSourceFiles || watchMode || printDuplicates || printKeepers || quiet) {
+        printError("-interactive cannot be combined with -delete-source-files, -watch, -print-duplicates, -print-keepers or -quiet.\n")
+        os.Exit(exitUsage)
+    }
 
-func containsHelpFlag() bool {
-    for _, arg := range os.Args[1:] {
-        if arg == "-h" || arg == "-help" {
-            return true
-        }
+    if previewTree && targetDir == "" {
+        printError("-preview-tree requires -t.\n")
+        os.Exit(exitUsage)
+    }
+    if previewTree && (deleteSourceFiles || watchMode) {
+        printError("-preview-tree cannot be combined with -delete-source-files or -watch.\n")
+        os.Exit(exitUsage)
     }
-    return false
-}
 
-func worker(fileChan <-chan string, fileExtensions map[string]bool, fileMap map[string]*FileInfo, fileMapMutex *sync.Mutex, wg *sync.WaitGroup) {
-    defer wg.Done()
+    if genScript != "" && targetDir == "" && !deleteSourceFiles {
+        printError("-gen-script requires -t or -delete-source-files.\n")
+        os.Exit(exitUsage)
+    }
+    if genScript != "" && (previewTree || watchMode || interactive || pruneEmpty || copyMode == "representative-tagged") {
+        printError("-gen-script cannot be combined with -preview-tree, -watch, -interactive, -prune-empty-dirs or -copy-mode representative-tagged.\n")
+        os.Exit(exitUsage)
+    }
 
-    for path := range fileChan {
-        log("Processing file: %s", path)
+    if matchMode != "name-hash" && matchMode != "name-hash-size" && matchMode != "audio-props" {
+        printError("-match must be name-hash, name-hash-size or audio-props.\n")
+        os.Exit(exitUsage)
+    }
+    if matchMode == "audio-props" && (fuzzyName || confirmBytes) {
+        printError("-match audio-props cannot be combined with -fuzzy-name or -confirm-bytes.\n")
+        os.Exit(exitUsage)
+    }
 
-        info, err := os.Stat(path)
-        if err != nil {
-            fmt.Fprintf(os.Stderr, "Error: Unable to stat file %s: %v\n", path, err)
-            continue
+    switch copyMode {
+    case "unique", "all", "representative-tagged":
+    default:
+        printError("-copy-mode must be unique, all or representative-tagged.\n")
+        os.Exit(exitUsage)
+    }
+    switch preserveAttrs {
+    case "mode", "times", "all", "none":
+    default:
+        printError("-preserve must be mode, times, all or none.\n")
+        os.Exit(exitUsage)
+    }
+    if copyModeBits != "" {
+        bits, err := strconv.ParseUint(copyModeBits, 8, 32)
+        if err != nil || bits > 0o777 {
+            printError("-copy-mode-bits must be octal permissions such as 0664.\n")
+            os.Exit(exitUsage)
         }
+        copyPerm = os.FileMode(bits)
+    }
+    if copyUID < -1 || copyGID < -1 {
+        printError("-copy-uid and -copy-gid must not be negative.\n")
+        os.Exit(exitUsage)
+    }
+    if runtime.GOOS == "windows" && (copyUID >= 0 || copyGID >= 0) {
+        printError("-copy-uid and -copy-gid are not supported on Windows.\n")
+        os.Exit(exitUsage)
+    }
+    if copyMode == "representative-tagged" && watchMode {
+        printError("-copy-mode representative-tagged cannot be combined with -watch.\n")
+        os.Exit(exitUsage)
+    }
 
-        size := info.Size()
-        hash, err := fileHash(path)
-        if err != nil {
-            fmt.Fprintf(os.Stderr, "Error: Unable to hash file %s: %v\n", path, err)
-            continue
-        }
+    if scope != "global" && scope != "per-source" {
+        printError("-scope must be global or per-source.\n")
+        os.Exit(exitUsage)
+    }
+    if scope == "per-source" && len(referenceDirs) > 0 {
+        printError("-scope per-source cannot be combined with -reference.\n")
+        os.Exit(exitUsage)
+    }
 
-        filename := filepath.Base(path)
-        fileInfo := &FileInfo{
-            Name: filename,
-            Path: path,
-            Hash: hash,
-            Size: size,
+    if denyHashesPath != "" {
+        if deniedHashes, err = loadDenyList(denyHashesPath); err != nil {
+            printError("Unable to read -deny-hashes: %v\n", err)
+            os.Exit(exitUsage)
         }
+    }
 
-        key := filename + "|" + hash
+    // Every other mode matches names with their extension, so a group never
+    // holds two extensions to choose between.
+    if preferExt != "" && matchMode != "audio-props" && !fingerprintMode {
+        printError("-prefer-ext requires -match audio-props or -fingerprint; other modes only group files with the same extension.\n")
+        os.Exit(exitUsage)
+    }
 
-        fileMapMutex.Lock()
-        if existingFile, exists := fileMap[key]; exists {
-            existingFile.Children = append(existingFile.Children, fileInfo)
-        } else {
-            fileMap[key] = fileInfo
+    if preferExt != "" {
+        extRank = make(map[string]int)
+        for i, ext := range strings.Split(preferExt, ",") {
+            ext = strings.ToLower(strings.TrimPrefix(strings.TrimSpace(ext), "."))
+            if ext == "" {
+                printError("-prefer-ext contains an empty extension.\n")
+                os.Exit(exitUsage)
+            }
+            if _, ok := extRank["."+ext]; !ok {
+                extRank["."+ext] = i
+            }
         }
-        fileMapMutex.Unlock()
     }
-}
 
-func fileHash(path string) (string, error) {
-    file, err := os.Open(path)
-    if err != nil {
-        return "", err
+    if bufferSizeKB <= 0 {
+        printError("-buffer-size must be greater than 0.\n")
+        os.Exit(exitUsage)
     }
-    defer file.Close()
-
-    hasher := md5.New()
-    _, err = io.Copy(hasher, file)
-    if err != nil {
-        return "", err
+    if numWorkers <= 0 {
+        printError("-workers must be greater than 0.\n")
+        os.Exit(exitUsage)
+    }
+    if execTemplate != "" {
+        if _, err := splitCommand(execTemplate); err != nil {
+            printError("Invalid -exec: %v\n", err)
+            os.Exit(exitUsage)
+        }
+    }
+    if execWorkers <= 0 {
+        printError("-exec-workers must be greater than 0.\n")
+        os.Exit(exitUsage)
+    }
+    if copyWorkers <= 0 {
+        printError("-copy-workers must be greater than 0.\n")
+        os.Exit(exitUsage)
+    }
+    if queueSize < 0 {
+        printError("-queue-size must not be negative.\n")
+        os.Exit(exitUsage)
+    }
+    if benchmarkFiles < 0 {
+        printError("-benchmark must not be negative.\n")
+        os.Exit(exitUsage)
+    }
+    if benchmarkFiles > 0 && (targetDir != "" || deleteSourceFiles || interactive || watchMode || dbPath != "" || resumeScan) {
+        printError("-benchmark cannot be combined with -t, -delete-source-files, -interactive, -watch, -db or -resume.\n")
+        os.Exit(exitUsage)
     }
 
-    return hex.EncodeToString(hasher.Sum(nil)), nil
-}
+    if maxReadMBps < 0 {
+        printError("-max-read-mbps must not be negative.\n")
+        os.Exit(exitUsage)
+    }
+    if maxReadMBps > 0 {
+        readLimiter = newRateLimiter(maxReadMBps*1024*1024, bufferSizeKB*1024)
+    }
 
-func writeJSONToFile(filename string, data []*FileInfo) error {
-    file, err := os.Create(filename)
-    if err != nil {
-        return err
+    if strings.ContainsAny(renameTemplate, `/\`) {
+        printError("-rename-template must not contain path separators.\n")
+        os.Exit(exitUsage)
     }
-    defer file.Close()
 
-    encoder := json.NewEncoder(file)
-    encoder.SetIndent("", "    ")
-    return encoder.Encode(data)
-}
+    if watchMode && watchDebounce <= 0 {
+        printError("-watch-debounce must be greater than 0.\n")
+        os.Exit(exitUsage)
+    }
 
-func copyFile(srcPath, destDir string, fileInfo *FileInfo) error {
-    if destDir == "" {
-        return nil
+    if followWithinRoots && !followSymlinks {
+        printError("-follow-only-within-roots requires -follow-symlinks.\n")
+        os.Exit(exitUsage)
     }
 
-    srcFile, err := os.Open(srcPath)
-    if err != nil {
-        return err
+    if watchMode && fingerprintMode {
+        printError("-watch cannot be combined with -fingerprint.\n")
+        os.Exit(exitUsage)
     }
-    defer srcFile.Close()
 
-    filename := filepath.Base(srcPath)
-    destPath := filepath.Join(destDir, filename)
+    if skipHeaderBytes < 0 {
+        printError("-skip-header-bytes must not be negative.\n")
+        os.Exit(exitUsage)
+    }
+    if skipHeaderBytes > 0 && checksumSidecar {
+        printError("-skip-header-bytes cannot be combined with -checksum-sidecar.\n")
+        os.Exit(exitUsage)
+    }
 
-    i := 1
-    for {
-        if _, err := os.Stat(destPath); os.IsNotExist(err) {
-            break
+    if chunkSimilarity < 0 || chunkSimilarity > 1 {
+        printError("-chunk-similarity must be between 0 and 1.\n")
+        os.Exit(exitUsage)
+    }
+    if tmpDir != "" {
+        if abs, err := filepath.Abs(tmpDir); err == nil {
+            tmpDir = abs
+        }
+        if err := checkWritable(tmpDir); err != nil {
+            printError("-tmp-dir is not writable: %v\n", err)
+            os.Exit(exitUsage)
+        }
+    }
+    if compressOutput && checkpointPath != "" && !strings.HasSuffix(checkpointPath, ".gz") {
+        checkpointPath += ".gz"
+    }
+    if resumeScan && checkpointPath == "" {
+        printError("-resume requires -checkpoint.\n")
+        os.Exit(exitUsage)
+    }
+    // A checkpoint that is not resumed is started afresh, so don't throw
+    // away one left by an interrupted scan without being told to.
+    if checkpointPath != "" && !resumeScan && !forceOverwrite {
+        if info, err := os.Stat(checkpointPath); err == nil && info.Size() > 0 {
+            printError("%s holds an interrupted scan; use -resume to continue it or -force to start over.\n", checkpointPath)
+            os.Exit(exitUsage)
         }
-        destPath = filepath.Join(destDir, fmt.Sprintf("%s(%d)%s", strings.TrimSuffix(filename, filepath.Ext(filename)), i, filepath.Ext(filename)))
-        i++
     }
 
-    destFile, err := os.Create(destPath)
-    if err != nil {
-        return err
+    if maxDuration < 0 {
+        printError("-max-duration must not be negative.\n")
+        os.Exit(exitUsage)
+    }
+    if maxDuration > 0 && watchMode {
+        printError("-max-duration cannot be combined with -watch.\n")
+        os.Exit(exitUsage)
     }
-    defer destFile.Close()
 
-    _, err = io.Copy(destFile, srcFile)
-    if err != nil {
-        return err
+    if fingerprintMin <= 0 || fingerprintMin > 1 {
+        printError("-fingerprint-threshold must be greater than 0 and at most 1.\n")
+        os.Exit(exitUsage)
     }
 
-    info, err := srcFile.Stat()
-    if err != nil {
-        return err
+    if benchmarkFiles > 0 {
+        if err := runBenchmark(benchmarkFiles); err != nil {
+            printError("%v\n", err)
+            os.Exit(exitError)
+        }
+        os.Exit(exitOK)
     }
 
-    err = os.Chmod(destPath, info.Mode())
+    foundDuplicates, err := run()
     if err != nil {
-        return err
+        printError("%v\n", err)
+        os.Exit(exitError)
     }
 
-    atime, mtime, err := getFileTimes(srcPath)
-    if err != nil {
-        return err
+    if foundDuplicates {
+        os.Exit(exitDuplicates)
     }
-    return os.Chtimes(destPath, atime, mtime)
+    os.Exit(exitOK)
 }
 
-func getFileTimes(path string) (accessTime, modTime time.Time, err error) {
-    var stat unix.Stat_t
-    err = unix.Stat(path, &stat)
-    if err != nil {
-        return
+// dedupeRoots drops directories that are the same as, or inside, another
+// entry of dirs, so no file is walked twice. Directories are compared after
+// resolving symlinks but are kept as given.
+func dedupeRoots(dirs DirList) DirList {
+    resolved := make([]string, len(dirs))
+    for i, dir := range dirs {
+        resolved[i] = resolvePath(dir)
     }
 
-    accessTime = time.Unix(int64(stat.Atim.Sec), int64(stat.Atim.Nsec))
-    modTime = time.Unix(int64(stat.Mtim.Sec), int64(stat.Mtim.Nsec))
-    return
+    var kept DirList
+    for i, dir := range dirs {
+        covered := false
+        for j := range dirs {
+            if i == j {
+                continue
+            }
+            // Of two identical entries the first is kept.
+            if resolved[i] == resolved[j] && j < i || insideRoot(resolved[i], []string{resolved[j]}) {
+                fmt.Fprintf(os.Stderr, "Warning: %s is already covered by %s, scanning it once\n", dir, dirs[j])
+                covered = true
+                break
+            }
+        }
+        if !covered {
+            kept = append(kept, dir)
+        }
+    }
+    return kept
 }
 
-func deleteFiles(output []*FileInfo) error {
+// resolvePath returns path made absolute with symlinks resolved, as far as
+// possible. A path that does not exist yet, such as a -t that is still to be
+// created, is resolved through its parent.
+func resolvePath(path string) string {
+    abs, err := filepath.Abs(path)
+    if err != nil {
+        return path
+    }
+    if real, err := filepath.EvalSymlinks(abs); err == nil {
+        return real
+    }
+    if parent, err := filepath.EvalSymlinks(filepath.Dir(abs)); err == nil {
+        return filepath.Join(parent, filepath.Base(abs))
+    }
+    return abs
+}
+
+// overlappingRoot returns the first of roots that target is the same as or
+// inside, compared as resolvePath sees them.
+func overlappingRoot(target string, roots []string) (string, bool) {
+    resolved := resolvePath(target)
+    for _, root := range roots {
+        real := resolvePath(root)
+        if resolved == real || insideRoot(resolved, []string{real}) {
+            return root, true
+        }
+    }
+    return "", false
+}
+
+// expandGlobs replaces every directory containing glob metacharacters with
+// the directories it matches. Other entries are kept as given.
+func expandGlobs(dirs DirList) (DirList, error) {
+    var expanded DirList
+    for _, dir := range dirs {
+        if !strings.ContainsAny(dir, "*?[") {
+            expanded = append(expanded, dir)
+            continue
+        }
+
+        matches, err := filepath.Glob(dir)
+        if err != nil {
+            return nil, fmt.Errorf("invalid pattern %q: %v", dir, err)
+        }
+        count := 0
+        for _, match := range matches {
+            if info, err := os.Stat(match); err == nil && info.IsDir() {
+                expanded = append(expanded, match)
+                count++
+            }
+        }
+        if count == 0 {
+            fmt.Fprintf(os.Stderr, "Warning: Pattern %s matched no directories\n", dir)
+        } else {
+            log("Pattern %s matched %d directories", dir, count)
+        }
+    }
+    return expanded, nil
+}
+
+// run scans the source directories and reports whether any duplicates were found.
+func run() (bool, error) {
+    log("Starting dedupe-music program")
+    startTime := time.Now()
+
+    outputFile := "dedupe-music.json"
+    if indexOnly {
+        outputFile = "dedupe-music.ndjson"
+    }
+    if compressOutput {
+        outputFile += ".gz"
+    }
+    // -retry-errors merges the retried files into the file it read them from,
+    // replacing it only once the new results are complete.
+    writeOutput := writeJSONToFile
+    if retryErrorsPath != "" {
+        outputFile, writeOutput = retryErrorsPath, rewriteJSONFile
+    }
+
+    if !forceOverwrite && findHash == "" {
+        if _, err := os.Stat(outputFile); err == nil && retryErrorsPath == "" {
+            return false, fmt.Errorf("output file %s already exists (use -force to overwrite)", outputFile)
+        }
+        if dbPath != "" {
+            if _, err := os.Stat(dbPath); err == nil {
+                return false, fmt.Errorf("database %s already exists (use -force to overwrite)", dbPath)
+            }
+        }
+    }
+
+    if targetDir != "" && !previewTree && genScript == "" {
+        err := os.MkdirAll(targetDir, os.ModePerm)
+        if err != nil {
+            return false, fmt.Errorf("error creating output directory %s: %v", targetDir, err)
+        }
+        log("Output directory created or exists: %s", targetDir)
+    }
+
+    var cp *checkpoint
+    if checkpointPath != "" {
+        var err error
+        if cp, err = openCheckpoint(checkpointPath, resumeScan); err != nil {
+            return false, fmt.Errorf("error opening checkpoint %s: %v", checkpointPath, err)
+        }
+    }
+    scanCheckpoint = cp
+
+    // On Ctrl-C, save what has been hashed so far before exiting.
+    interrupted := make(chan os.Signal, 1)
+    signal.Notify(interrupted, os.Interrupt, syscall.SIGTERM)
+    go func() {
+        if _, ok := <-interrupted; !ok {
+            return
+        }
+        cp.close()
+        if cp != nil {
+            fmt.Fprintf(os.Stderr, "\nInterrupted. Run again with -resume to continue from %s.\n", checkpointPath)
+        } else {
+            fmt.Fprintf(os.Stderr, "\nInterrupted.\n")
+        }
+        os.Exit(exitError)
+    }()
+
+    referenceDirs = dedupeRoots(referenceDirs)
+    sourceDirs = dedupeRoots(sourceDirs)
+
+    if maxDuration > 0 {
+        var cancel context.CancelFunc
+        scanContext, cancel = context.WithTimeout(context.Background(), maxDuration)
+        defer cancel()
+    }
+    deadline := scanContext
+    if findHash != "" {
+        scanContext, stopFind = context.WithCancel(scanContext)
+        defer stopFind()
+    }
+
+    fileMap := make(map[string]*FileInfo)
+    var fileMapMutex sync.Mutex
+    if priorScan != nil {
+        for _, group := range priorScan.Files {
+            fileMap[generateKey(group)] = group
+        }
+    }
+    if indexOnly {
+        index, err := createIndex(outputFile)
+        if err != nil {
+            return false, fmt.Errorf("error creating index: %v", err)
+        }
+        fileIndex = index
+    }
+    if resultsAppend != "" {
+        results, err := openResultsLog(resultsAppend)
+        if err != nil {
+            return false, fmt.Errorf("error opening -results-append file: %v", err)
+        }
+        groupLog = results
+        defer results.close()
+    }
+    if lowMemory {
+        spill, err := newSpillMap()
+        if err != nil {
+            return false, fmt.Errorf("error creating -low-memory files: %v", err)
+        }
+        fileSpill = spill
+        defer spill.remove()
+    }
+
+    stopProgress := startProgress()
+    defer stopProgress()
+
+    var wg sync.WaitGroup
+    pools, err := startDiskPools(fileMap, &fileMapMutex, &wg)
+    if err != nil {
+        return false, fmt.Errorf("error setting up -threads-per-disk: %v", err)
+    }
+
+    // Hard links to an inode that was already queued are recorded as aliases
+    // of the first path instead of being hashed and reported as duplicates.
+    // fileInode ignores files with a single link, so with -low-memory this
+    // grows with the hard links in the library rather than with its size.
+    seenInodes := make(map[inode]string)
+    seenPaths := make(map[string]bool)
+    aliases := make(map[string][]string)
+
+    scan := func(dir string, reference bool) error {
+        return walkRoot(dir, func(path string, info os.FileInfo, err error) error {
+            if scanContext.Err() != nil {
+                return filepath.SkipAll
+            }
+            if err != nil {
+                if errors.Is(err, os.ErrPermission) {
+                    return nil
+                }
+                return fmt.Errorf("error accessing %s: %v", path, err)
+            }
+
+            if skipHidden && path != dir && hiddenFile(info) {
+                if info.IsDir() {
+                    return filepath.SkipDir
+                }
+                return nil
+            }
+            // walkRoot reports symbolic links it does not follow as they
+            // are, so they fail IsRegular and nothing is read through them.
+            // Dangling ones are reported.
+            checkBrokenLink(path, info)
+            if !info.Mode().IsRegular() || info.Size() < minSizeFor(info.Name()) {
+                return nil
+            }
+
+            // A file below two overlapping roots is only queued once. With
+            // -low-memory paths are not kept; dedupeRoots has already dropped
+            // overlapping roots, and a repeated path is skipped when grouping.
+            if !lowMemory {
+                if seenPaths[path] {
+                    return nil
+                }
+                seenPaths[path] = true
+            }
+
+            ext := strings.ToLower(filepath.Ext(info.Name()))
+            if ext == ".zip" && scanZip {
+                if err := scanArchive(path, dir, reference, pools.route(info)); err != nil {
+                    printError("Unable to read archive %s: %v\n", path, err)
+                }
+                return nil
+            }
+            if !isAllowed(info.Name()) || !pathAllowed(path) {
+                return nil
+            }
+
+            if id, ok := fileInode(info); ok {
+                if first, seen := seenInodes[id]; seen {
+                    log("Skipping hard link %s to %s", path, first)
+                    aliases[first] = append(aliases[first], path)
+                    return nil
+                }
+                seenInodes[id] = path
+            }
+
+            pools.route(info) <- fileJob{path: path, root: dir, reference: reference, size: info.Size(), modTime: info.ModTime()}
+            return nil
+        })
+    }
+
+    if priorScan != nil {
+        fmt.Fprintf(infoOut, "Retrying %d files that failed in %s\n", len(priorScan.Errors), retryErrorsPath)
+        for _, e := range priorScan.Errors {
+            job := fileJob{path: e.Path, root: e.Root, reference: e.Reference}
+            info, err := os.Stat(e.Path)
+            if err != nil {
+                printError("Unable to stat file %s: %v\n", e.Path, err)
+                recordFileError(job, err)
+                continue
+            }
+            job.size, job.modTime = info.Size(), info.ModTime()
+            pools.route(info) <- job
+        }
+    } else {
+        for _, dir := range referenceDirs {
+            log("Scanning reference directory: %s", dir)
+            if err := scan(dir, true); err != nil {
+                return false, fmt.Errorf("error walking directory %s: %v", dir, err)
+            }
+        }
+
+        for _, dir := range sourceDirs {
+            log("Scanning directory: %s", dir)
+            if err := scan(dir, false); err != nil {
+                return false, fmt.Errorf("error walking directory %s: %v", dir, err)
+            }
+        }
+    }
+
+    pools.close()
+    wg.Wait()
+    stopProgress()
+    if deadline.Err() != nil {
+        scanIncomplete = true
+        fmt.Fprintf(os.Stderr, "Warning: -max-duration of %v reached after hashing %d files; the results are partial\n", maxDuration, filesScanned.Load())
+    }
+
+    signal.Stop(interrupted)
+    close(interrupted)
+    scanCheckpoint = nil
+    if err := cp.close(); err != nil {
+        return false, fmt.Errorf("error writing checkpoint %s: %v", checkpointPath, err)
+    }
+
+    if filesFound() == 0 && !scanIncomplete && priorScan == nil {
+        warnNoFiles()
+        if failOnEmpty {
+            if err := removeCheckpoint(); err != nil {
+                return false, err
+            }
+            return false, errors.New("no eligible files were found (-fail-on-empty)")
+        }
+    }
+
+    if findHash != "" {
+        // A lookup is not a scan worth resuming.
+        if err := removeCheckpoint(); err != nil {
+            return false, err
+        }
+        if findMatches == 0 {
+            fmt.Fprintf(infoOut, "No file matches %s\n", findHash)
+        }
+        return findMatches > 0, nil
+    }
+
+    if fileIndex != nil {
+        count := fileIndex.count
+        err := fileIndex.close()
+        fileIndex = nil
+        if err != nil {
+            return false, fmt.Errorf("error writing index %s: %v", outputFile, err)
+        }
+        fmt.Fprintf(infoOut, "Index of %d files written to %s\n", count, outputFile)
+        if len(changedFiles) > 0 {
+            fmt.Fprintf(os.Stderr, "Warning: %d files changed during the scan and were left out of the index\n", len(changedFiles))
+        }
+        if scanIncomplete {
+            printResumeHint()
+        } else if err := removeCheckpoint(); err != nil {
+            return false, err
+        }
+        return false, nil
+    }
+
+    var output []*FileInfo
+    if fileSpill != nil {
+        if output, err = fileSpill.duplicateGroups(); err != nil {
+            return false, fmt.Errorf("error reading -low-memory files: %v", err)
+        }
+        fileSpill = nil
+    }
+    for _, fileInfo := range fileMap {
+        output = append(output, fileInfo)
+    }
+    for _, fileInfo := range output {
+        fileInfo.Aliases = aliases[fileInfo.Path]
+        for _, child := range fileInfo.Children {
+            child.Aliases = aliases[child.Path]
+        }
+    }
+
+    var contentDupes map[string][]string
+    if reportContent {
+        contentDupes = findContentDuplicates(output)
+    }
+
+    if fingerprintMode {
+        output = groupByFingerprint(output)
+    }
+
+    for i, fileInfo := range output {
+        output[i] = selectCanonical(fileInfo)
+    }
+    sortGroups(output)
+    if len(compareDirs) > 0 {
+        comparisonResult = compareRoots(output)
+        printComparison(comparisonResult)
+    }
+
+    if sampleVerify > 0 {
+        if n := verifySample(output); n > 0 {
+            return false, fmt.Errorf("-sample-verify found %d files whose sidecar or checkpoint hash is wrong; nothing was copied or deleted", n)
+        }
+    }
+
+    if reportTags {
+        printTagReport(output)
+    }
+    if detectTruncated {
+        printTruncated(findTruncated(output))
+    }
+    if duplicateDirs {
+        printDuplicateDirs(findDuplicateDirs(output))
+    }
+    if chunkSimilarity > 0 {
+        printNearDuplicates(findNearDuplicates(output))
+    }
+    if checkMagicBytes {
+        printMagicMismatches()
+    }
+
+    if skipExisting && targetDir != "" {
+        log("Indexing existing files in %s", targetDir)
+        if err := indexTarget(output); err != nil {
+            return false, fmt.Errorf("error reading target directory %s: %v", targetDir, err)
+        }
+    }
+
+    if previewTree {
+        printPreviewTree(output)
+    } else if genScript != "" {
+        if err := writeScript(genScript, output); err != nil {
+            return false, fmt.Errorf("error writing script: %v", err)
+        }
+        fmt.Fprintf(infoOut, "Script written to %s\n", genScript)
+    } else if targetDir != "" {
+        if err := copyFiles(output); err != nil {
+            // Keep the scan's results even though the copy stopped short.
+            if werr := writeOutput(outputFile, output); werr == nil {
+                fmt.Fprintf(infoOut, "Results written to %s\n", outputFile)
+            }
+            return false, err
+        }
+    }
+
+    if interactive {
+        reviewGroups(output)
+    }
+
+    if deleteSourceFiles && genScript == "" {
+        if !confirmDeletion(output) {
+            if err := writeOutput(outputFile, output); err == nil {
+                fmt.Fprintf(infoOut, "Results written to %s\n", outputFile)
+            }
+            return false, errors.New("deletion not confirmed, nothing was deleted")
+        }
+        if err := deleteFiles(output); err != nil {
+            return false, fmt.Errorf("error deleting files: %v", err)
+        }
+        if pruneEmpty {
+            if err := pruneEmptyDirs(output, sourceDirs); err != nil {
+                return false, fmt.Errorf("error pruning empty directories: %v", err)
+            }
+        }
+    }
+
+    if err := writeOutput(outputFile, output); err != nil {
+        return false, fmt.Errorf("error writing JSON to file: %v", err)
+    }
+
+    fmt.Fprintf(infoOut, "Results written to %s\n", outputFile)
+    if uniqueOnly {
+        n := 0
+        for range reportedGroups(output) {
+            n++
+        }
+        fmt.Fprintf(infoOut, "Listed %d files with no duplicates\n", n)
+    }
+    if len(changedFiles) > 0 {
+        fmt.Fprintf(os.Stderr, "Warning: %d files changed during the scan and were left out; see \"changed_during_scan\" in %s\n", len(changedFiles), outputFile)
+    }
+    if len(brokenLinks) > 0 {
+        fmt.Fprintf(os.Stderr, "Warning: skipped %d broken symlinks; see \"broken_symlinks\" in %s\n", len(brokenLinks), outputFile)
+    }
+    if len(deniedFiles) > 0 {
+        fmt.Fprintf(infoOut, "Left out %d files on the -deny-hashes list\n", len(deniedFiles))
+    }
+    if len(silentFiles) > 0 {
+        fmt.Fprintf(infoOut, "Skipped %d silent files\n", len(silentFiles))
+    }
+
+    if dbPath != "" {
+        if err := writeSQLite(dbPath, slices.Collect(reportedGroups(output))); err != nil {
+            return false, fmt.Errorf("error writing database: %v", err)
+        }
+        fmt.Fprintf(infoOut, "Database written to %s\n", dbPath)
+    }
+
+    if execTemplate != "" {
+        if err := runExecHooks(output); err != nil {
+            return false, err
+        }
+    }
+    if reportContent {
+        printContentDuplicates(contentDupes)
+    }
+    if printDuplicates {
+        if err := printDuplicatePaths(os.Stdout, output); err != nil {
+            return false, fmt.Errorf("error printing duplicates: %v", err)
+        }
+    }
+    if printKeepers {
+        if err := printKeeperPaths(os.Stdout, output); err != nil {
+            return false, fmt.Errorf("error printing keepers: %v", err)
+        }
+    }
+    if targetDir != "" && !previewTree && genScript == "" {
+        fmt.Fprintf(infoOut, "Files copied to %s\n", targetDir)
+    }
+
+    if scanIncomplete {
+        printResumeHint()
+    } else if err := removeCheckpoint(); err != nil {
+        return false, err
+    }
+
+    stats := collectStats(output, startTime)
+    printSummary(stats)
+    if statsFile != "" {
+        if err := writeStatsToFile(statsFile, stats); err != nil {
+            return false, fmt.Errorf("error writing stats to file: %v", err)
+        }
+        fmt.Fprintf(infoOut, "Stats written to %s\n", statsFile)
+    }
+    if metricsFile != "" {
+        if err := writeMetricsFile(metricsFile, stats); err != nil {
+            return false, fmt.Errorf("error writing metrics to file: %v", err)
+        }
+        fmt.Fprintf(infoOut, "Metrics written to %s\n", metricsFile)
+    }
+
+    if watchMode {
+        ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+        defer stop()
+        if err := watchSources(ctx, output, outputFile); err != nil {
+            return false, fmt.Errorf("error watching source directories: %v", err)
+        }
+    }
+
+    return stats.DuplicateGroups > 0, nil
+}
+
+// collectStats summarizes a finished run.
+func collectStats(output []*FileInfo, startTime time.Time) runStats {
+    endTime := time.Now()
+    stats := runStats{
+        Version:         version,
+        Commit:          commit,
+        BuildDate:       buildDate,
+        StartTime:       startTime,
+        EndTime:         endTime,
+        DurationSeconds: endTime.Sub(startTime).Seconds(),
+        FilesScanned:    filesScanned.Load(),
+        BytesRead:       bytesRead.Load(),
+        Workers:         numWorkers,
+        HashAlgorithm:   hashNames()[0],
+        SlowestFiles:    slowest(),
+    }
+
+    for _, fileInfo := range output {
+        if len(fileInfo.Children) == 0 {
+            continue
+        }
+        stats.DuplicateGroups++
+        for _, child := range fileInfo.Children {
+            stats.DuplicateFiles++
+            if !child.readOnly() {
+                stats.BytesReclaimable += child.Size
+            }
+        }
+    }
+    return stats
+}
+
+// isFlagSet reports whether the flag was given on the command line or in the
+// config file.
+func isFlagSet(name string) bool {
+    set := false
+    flag.Visit(func(f *flag.Flag) {
+        if canonicalFlagName(f.Name) == name {
+            set = true
+        }
+    })
+    return set
+}
+
+// loadConfig applies the values in the TOML file at path to every flag that
+// was not set on the command line. Arrays set a repeatable flag once per
+// element and tables are passed as a comma-separated key=value list.
+func loadConfig(path string) error {
+    var values map[string]interface{}
+    if _, err := toml.DecodeFile(path, &values); err != nil {
+        return fmt.Errorf("error reading config %s: %v", path, err)
+    }
+
+    explicit := make(map[string]bool)
+    flag.Visit(func(f *flag.Flag) {
+        explicit[canonicalFlagName(f.Name)] = true
+    })
+
+    keys := make([]string, 0, len(values))
+    for key := range values {
+        keys = append(keys, key)
+    }
+    sort.Strings(keys)
+
+    for _, key := range keys {
+        name := canonicalFlagName(key)
+        if name == "config" || flag.Lookup(name) == nil {
+            return fmt.Errorf("unknown setting %q in config %s", key, path)
+        }
+        if explicit[name] {
+            continue
+        }
+
+        var settings []string
+        switch v := values[key].(type) {
+        case []interface{}:
+            for _, item := range v {
+                settings = append(settings, configValue(item))
+            }
+        default:
+            settings = append(settings, configValue(v))
+        }
+
+        for _, setting := range settings {
+            if err := flag.Set(name, setting); err != nil {
+                return fmt.Errorf("invalid value for %q in config %s: %v", key, path, err)
+            }
+        }
+    }
+    return nil
+}
+
+// configValue converts a decoded TOML value to the string form flag.Set expects.
+func configValue(v interface{}) string {
+    switch v := v.(type) {
+    case string:
+        return v
+    case bool:
+        return strconv.FormatBool(v)
+    case int64:
+        return strconv.FormatInt(v, 10)
+    case float64:
+        return strconv.FormatFloat(v, 'f', -1, 64)
+    case map[string]interface{}:
+        keys := make([]string, 0, len(v))
+        for key := range v {
+            keys = append(keys, key)
+        }
+        sort.Strings(keys)
+
+        pairs := make([]string, 0, len(keys))
+        for _, key := range keys {
+            pairs = append(pairs, key+"="+configValue(v[key]))
+        }
+        return strings.Join(pairs, ",")
+    default:
+        return fmt.Sprint(v)
+    }
+}
+
+func canonicalFlagName(name string) string {
+    if long, ok := flagAliases[name]; ok {
+        return long
+    }
+    return name
+}
+
+func containsHelpFlag() bool {
+    for _, arg := range os.Args[1:] {
+        if arg == "-h" || arg == "-help" {
+            return true
+        }
+    }
+    return false
+}
+
+// pathAllowed applies -include-regex and -exclude-regex to a file's full path.
+func pathAllowed(path string) bool {
+    if includePattern != nil && !includePattern.MatchString(path) {
+        return false
+    }
+    return excludePattern == nil || !excludePattern.MatchString(path)
+}
+
+// scanArchive queues the eligible entries of the zip file at path.
+func scanArchive(path, root string, reference bool, fileChan chan<- fileJob) error {
+    log("Scanning archive: %s", path)
+
+    archive, err := zip.OpenReader(path)
+    if err != nil {
+        return err
+    }
+    defer archive.Close()
+
+    for _, entry := range archive.File {
+        size := int64(entry.UncompressedSize64)
+        if !entry.Mode().IsRegular() || size < minSizeFor(entry.Name) {
+            continue
+        }
+        entryPath := path + zipSeparator + entry.Name
+        if !isAllowed(entry.Name) || !pathAllowed(entryPath) {
+            continue
+        }
+        if skipHidden && hiddenPath(entry.Name, "/") {
+            continue
+        }
+        fileChan <- fileJob{
+            path:      entryPath,
+            root:      root,
+            reference: reference,
+            archive:   true,
+            size:      size,
+        }
+    }
+    return nil
+}
+
+// archivePath returns the path of the archive holding an archive entry, or
+// path itself for a regular file.
+func archivePath(path string) string {
+    if scanZip {
+        if archive, _, ok := strings.Cut(path, zipSeparator); ok {
+            return archive
+        }
+    }
+    return path
+}
+
+// openSource opens a scanned file for reading, including files inside zip
+// archives when -scan-zip is set.
+func openSource(path string) (io.ReadCloser, error) {
+    if scanZip {
+        if i := strings.Index(strings.ToLower(path), ".zip"+zipSeparator); i >= 0 {
+            return openArchiveEntry(path[:i+len(".zip")], path[i+len(".zip"+zipSeparator):])
+        }
+    }
+    return os.Open(path)
+}
+
+// archiveEntry is an open zip entry that also closes its archive.
+type archiveEntry struct {
+    io.ReadCloser
+    archive *zip.ReadCloser
+}
+
+func (e *archiveEntry) Close() error {
+    e.ReadCloser.Close()
+    return e.archive.Close()
+}
+
+func openArchiveEntry(archivePath, name string) (io.ReadCloser, error) {
+    archive, err := zip.OpenReader(archivePath)
+    if err != nil {
+        return nil, err
+    }
+
+    for _, entry := range archive.File {
+        if entry.Name != name {
+            continue
+        }
+        r, err := entry.Open()
+        if err != nil {
+            archive.Close()
+            return nil, err
+        }
+        return &archiveEntry{ReadCloser: r, archive: archive}, nil
+    }
+
+    archive.Close()
+    return nil, fmt.Errorf("%s not found in %s", name, archivePath)
+}
+
+func worker(fileChan <-chan fileJob, fileMap map[string]*FileInfo, fileMapMutex *sync.Mutex, wg *sync.WaitGroup) {
+    defer wg.Done()
+
+    for job := range fileChan {
+        processFile(job, fileMap, fileMapMutex)
+    }
+}
+
+// processFile hashes the file behind job and adds it to fileMap, either as a
+// new group or as a duplicate of an existing one. It returns nil if the file
+// could not be processed.
+func processFile(job fileJob, fileMap map[string]*FileInfo, fileMapMutex *sync.Mutex) *FileInfo {
+    path := job.path
+    if scanContext.Err() != nil {
+        return nil
+    }
+    log("Processing file: %s", path)
+
+    size := job.size
+    var modTime time.Time
+    if !job.archive {
+        info, err := os.Stat(path)
+        if os.IsNotExist(err) && !job.modTime.IsZero() {
+            recordChanged(path, "deleted after it was found")
+            return nil
+        }
+        if err != nil {
+            printError("Unable to stat file %s: %v\n", path, err)
+            recordFileError(job, err)
+            return nil
+        }
+        size = info.Size()
+        modTime = info.ModTime()
+        if !job.modTime.IsZero() && (size != job.size || !modTime.Equal(job.modTime)) {
+            log("%s changed after it was found, hashing it as it is now", path)
+        }
+        if skipSilent && checkSilent(path, size) {
+            return nil
+        }
+    }
+
+    hashes, known := scanCheckpoint.lookup(path, size, modTime)
+    hashSource := ""
+    if known {
+        log("Using checkpointed hash for %s", path)
+        hashSource = "checkpoint"
+    } else if checksumSidecar && !job.archive {
+        if hashes, known = sidecarHashes(path, modTime); known {
+            log("Using sidecar checksum for %s", path)
+            hashSource = "sidecar"
+        }
+    }
+    if known && checkMagicBytes {
+        head, err := readHead(path)
+        if err != nil {
+            printError("Unable to read file %s: %v\n", path, err)
+        } else {
+            checkMagic(path, head)
+        }
+    }
+    if !known {
+        // A file written to while it is hashed is hashed once more; if it is
+        // still changing it is left out and listed as changed.
+        var head *headWriter
+        hashStart := time.Now()
+        for attempt := 1; ; attempt++ {
+            err := withRetry(scanContext, path, func() error {
+                ctx, cancel := scanContext, context.CancelFunc(func() {})
+                if fileTimeout > 0 {
+                    ctx, cancel = context.WithTimeout(ctx, fileTimeout)
+                }
+                defer cancel()
+                if checkMagicBytes {
+                    head = newHeadWriter()
+                }
+                var err error
+                hashes, err = fileHash(ctx, path, head)
+                return err
+            })
+            if err != nil && scanContext.Err() != nil {
+                log("Stopped hashing %s at -max-duration", path)
+                return nil
+            }
+            if err != nil {
+                printError("Unable to hash file %s: %v\n", path, err)
+                recordFileError(job, err)
+                return nil
+            }
+            if job.archive {
+                break
+            }
+
+            info, err := os.Stat(path)
+            if err != nil {
+                recordChanged(path, "deleted while it was hashed")
+                return nil
+            }
+            if info.Size() == size && info.ModTime().Equal(modTime) {
+                break
+            }
+            if attempt == 2 {
+                recordChanged(path, "changed while it was hashed")
+                return nil
+            }
+            log("%s changed while it was hashed, hashing it again", path)
+            size, modTime = info.Size(), info.ModTime()
+        }
+        recordHashTime(path, size, time.Since(hashStart))
+        if head != nil {
+            checkMagic(path, head.buf)
+        }
+        if !job.archive {
+            scanCheckpoint.record(path, size, modTime, hashes)
+        }
+    }
+    filesScanned.Add(1)
+
+    if findHash != "" {
+        checkFindHash(path, hashes)
+        return nil
+    }
+    if deniedHashes != nil && checkDenied(path, size, hashes) {
+        return nil
+    }
+
+    fileInfo := &FileInfo{
+        Name:       filepath.Base(path),
+        Path:       path,
+        Hash:       hashes[hashNames()[0]],
+        Size:       size,
+        SourceRoot: job.root,
+        Namespace:  namespace,
+        Reference:  job.reference,
+        Protected:  len(protectDirs) > 0 && insideRoot(archivePath(path), protectDirs),
+        InArchive:  job.archive,
+        modTime:    modTime,
+        hashSource: hashSource,
+    }
+    if len(hashes) > 1 {
+        fileInfo.Hashes = hashes
+    }
+    if fileIndex != nil {
+        if err := fileIndex.add(fileInfo); err != nil {
+            printError("Unable to add %s to the index: %v\n", path, err)
+        }
+        return fileInfo
+    }
+
+    if matchMode == "audio-props" {
+        props, err := readAudioProps(path, size)
+        if err != nil {
+            log("No audio properties for %s, matching by content: %v", path, err)
+        } else {
+            fileInfo.matchKey = audioPropsKey(fileInfo, props)
+        }
+    }
+
+    key := generateKey(fileInfo)
+    if fileSpill != nil {
+        if err := fileSpill.add(key, fileInfo); err != nil {
+            printError("Unable to record file %s: %v\n", path, err)
+            return nil
+        }
+        return fileInfo
+    }
+
+    fileMapMutex.Lock()
+    existingFile, exists := fileMap[key]
+    if !exists {
+        fileMap[key] = fileInfo
+    }
+    fileMapMutex.Unlock()
+
+    if !exists {
+        return fileInfo
+    }
+
+    if confirmBytes {
+        same, err := sameContent(existingFile.Path, path)
+        if err != nil {
+            printError("Unable to compare %s with %s: %v\n", path, existingFile.Path, err)
+            recordFileError(job, err)
+            return nil
+        }
+        if !same {
+            fmt.Fprintf(os.Stderr, "Warning: %s and %s share hash %s but their contents differ\n", path, existingFile.Path, fileInfo.Hash)
+            key += "|" + keyField(path)
+            fileMapMutex.Lock()
+            fileMap[key] = fileInfo
+            fileMapMutex.Unlock()
+            return fileInfo
+        }
+    }
+
+    fileMapMutex.Lock()
+    defer fileMapMutex.Unlock()
+    if existingFile.Path == path || slices.ContainsFunc(existingFile.Children, func(child *FileInfo) bool { return child.Path == path }) {
+        log("Skipping %s, which was already processed", path)
+        return nil
+    }
+    existingFile.Children = append(existingFile.Children, fileInfo)
+    if groupLog != nil {
+        if err := groupLog.append(existingFile); err != nil {
+            printError("Unable to append to %s: %v\n", resultsAppend, err)
+        }
+    }
+    return fileInfo
+}
+
+// generateKey returns the key that groups fileInfo with its duplicates.
+// Names and source roots may contain any character, including the "|"
+// between fields, so they are length-prefixed with keyField to keep two
+// different files from ever producing the same key.
+func generateKey(fileInfo *FileInfo) string {
+    name := matchName(fileInfo.Name)
+    if ignoreNameCase {
+        name = strings.ToLower(name)
+    }
+    key := keyField(name) + "|" + fileInfo.Hash
+    if fuzzyName {
+        key = normalizeName(matchName(fileInfo.Name))
+    }
+    if matchMode == "name-hash-size" {
+        key += "|" + strconv.FormatInt(fileInfo.Size, 10)
+    }
+    if fileInfo.matchKey != "" {
+        key = fileInfo.matchKey
+    }
+    if scope == "per-source" {
+        key = keyField(fileInfo.SourceRoot) + "|" + key
+    }
+    if fileInfo.Namespace != "" {
+        key = keyField(fileInfo.Namespace) + "|" + key
+    }
+    return key
+}
+
+// keyField encodes s as its length followed by s, so "a|b" becomes "3:a|b"
+// and where it ends is never ambiguous.
+func keyField(s string) string {
+    return strconv.Itoa(len(s)) + ":" + s
+}
+
+var (
+    copySuffixPattern = regexp.MustCompile(`\s*\(\d+\)$`)
+    separatorPattern  = regexp.MustCompile(`[\s_.-]+`)
+)
+
+// normalizeName reduces trivially renamed copies of a filename to one form:
+// "Track_01 (1).MP3" becomes "track 01.mp3".
+func normalizeName(name string) string {
+    ext := filepath.Ext(name)
+    base := copySuffixPattern.ReplaceAllString(strings.TrimSuffix(name, ext), "")
+    base = separatorPattern.ReplaceAllString(strings.ToLower(base), " ")
+    return strings.TrimSpace(base) + strings.ToLower(ext)
+}
+
+// sameContent streams both files and reports whether they are byte-for-byte
+// identical, stopping at the first difference. Bytes skipped by
+// -skip-header-bytes are not compared, and files with a registered Handler
+// are compared as normalized.
+func sameContent(pathA, pathB string) (bool, error) {
+    fileA, err := openSource(pathA)
+    if err != nil {
+        return false, err
+    }
+    defer fileA.Close()
+
+    fileB, err := openSource(pathB)
+    if err != nil {
+        return false, err
+    }
+    defer fileB.Close()
+
+    if skipHeaderBytes > 0 {
+        if err := skipTo(fileA, skipHeaderBytes, -1); err != nil {
+            return false, err
+        }
+        if err := skipTo(fileB, skipHeaderBytes, -1); err != nil {
+            return false, err
+        }
+    }
+
+    var readerA, readerB io.Reader = fileA, fileB
+    if h := handlerFor(pathA); h != nil {
+        if readerA, err = h.Normalize(readerA); err != nil {
+            return false, err
+        }
+    }
+    if h := handlerFor(pathB); h != nil {
+        if readerB, err = h.Normalize(readerB); err != nil {
+            return false, err
+        }
+    }
+
+    pooledA := bufferPool.Get().(*[]byte)
+    defer bufferPool.Put(pooledA)
+    pooledB := bufferPool.Get().(*[]byte)
+    defer bufferPool.Put(pooledB)
+
+    bufA, bufB := *pooledA, *pooledB
+    for {
+        nA, errA := io.ReadFull(readerA, bufA)
+        nB, errB := io.ReadFull(readerB, bufB)
+        if !bytes.Equal(bufA[:nA], bufB[:nB]) {
+            return false, nil
+        }
+
+        endA := errA == io.EOF || errA == io.ErrUnexpectedEOF
+        endB := errB == io.EOF || errB == io.ErrUnexpectedEOF
+        if errA != nil && !endA {
+            return false, errA
+        }
+        if errB != nil && !endB {
+            return false, errB
+        }
+        if endA || endB {
+            return endA && endB, nil
+        }
+    }
+}
+
+// hashNames returns the digests to compute for each file, the one used for
+// matching first.
+func hashNames() []string {
+    if len(hashAlgos) == 0 {
+        return []string{"md5"}
+    }
+    return hashAlgos
+}
+
+// fileHash returns the digests of the file at path, keyed by algorithm. If ctx
+// is done before the read finishes, fileHash returns immediately with an
+// error; the abandoned read is left to fail or finish in the background so a
+// stalled device cannot wedge the caller.
+func fileHash(ctx context.Context, path string, head *headWriter) (map[string]string, error) {
+    if ctx.Done() == nil {
+        return hashFile(ctx, path, head)
+    }
+
+    type result struct {
+        hashes map[string]string
+        err    error
+    }
+    done := make(chan result, 1)
+    go func() {
+        hashes, err := hashFile(ctx, path, head)
+        done <- result{hashes, err}
+    }()
+
+    select {
+    case r := <-done:
+        return r.hashes, r.err
+    case <-ctx.Done():
+        if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+            return nil, fmt.Errorf("timed out after %v", fileTimeout)
+        }
+        return nil, ctx.Err()
+    }
+}
+
+// hashFile computes every requested digest of the file at path in a single
+// read. Standard library digests are written to directly; any other Hasher
+// reads its own copy of the stream through a pipe. If head is not nil it
+// receives the file's leading bytes from the same read.
+func hashFile(ctx context.Context, path string, head *headWriter) (map[string]string, error) {
+    file, err := openSource(path)
+    if err != nil {
+        return nil, err
+    }
+    defer file.Close()
+
+    names := hashNames()
+    digests := make(map[string]hash.Hash, len(names))
+    writers := make([]io.Writer, 0, len(names))
+    pipes := make([]*io.PipeWriter, 0)
+    sums := make(map[string]string, len(names))
+    var sumsMutex sync.Mutex
+    var sumErr error
+    var wg sync.WaitGroup
+
+    for _, name := range names {
+        hasher := hashAlgorithms[name]
+        if d, ok := hasher.(digestHasher); ok {
+            digests[name] = d()
+            writers = append(writers, digests[name])
+            continue
+        }
+
+        pr, pw := io.Pipe()
+        pipes = append(pipes, pw)
+        writers = append(writers, pw)
+        wg.Add(1)
+        go func(name string) {
+            defer wg.Done()
+            sum, err := hasher.Hash(pr)
+            // Drain whatever the hasher did not read so the copy never blocks.
+            io.Copy(io.Discard, pr)
+            sumsMutex.Lock()
+            defer sumsMutex.Unlock()
+            if err != nil && sumErr == nil {
+                sumErr = fmt.Errorf("%s: %v", name, err)
+            }
+            sums[name] = sum
+        }(name)
+    }
+
+    // A file with a registered Handler is hashed as normalized, so its head
+    // is read separately rather than from the hashed stream.
+    handler := handlerFor(path)
+
+    // Plain files are hashed in chunks. Between chunks the progress line is
+    // updated and, when only standard digests are in use, their state is
+    // checkpointed so an interrupted scan can pick up part way through.
+    var size int64 = -1
+    var modTime time.Time
+    offset := skipHeaderBytes
+    resumable := false
+    if f, ok := file.(*os.File); ok {
+        if info, err := f.Stat(); err == nil {
+            size, modTime = info.Size(), info.ModTime()
+            resumable = len(pipes) == 0 && handler == nil && scanCheckpoint != nil
+        }
+    }
+    if resumable {
+        if resumed := scanCheckpoint.restoreDigests(path, size, modTime, digests); resumed > 0 {
+            offset = resumed
+            log("Resuming hash of %s at %s", path, formatSize(offset))
+        }
+    }
+
+    // A large file hashed with blake3 alone is hashed on every CPU, leaving
+    // only the last segment to the loop below.
+    parallel, _ := digests["blake3"].(*blake3Digest)
+    if parallel == nil || len(names) != 1 || handler != nil || size-offset <= hashChunkSize || !parallel.canHashParallel() {
+        parallel = nil
+    }
+    if head != nil && handler == nil && parallel == nil {
+        writers = append(writers, head)
+    }
+
+    if offset > 0 {
+        if err := skipTo(file, offset, size); err != nil {
+            return nil, err
+        }
+    }
+    if head != nil && (offset > 0 || handler != nil || parallel != nil) {
+        data, err := readHead(path)
+        if err != nil {
+            return nil, err
+        }
+        head.Write(data)
+    }
+    progress := trackFile(path, size, offset)
+    defer progress.finish()
+
+    if parallel != nil {
+        read := func(n int64) {
+            bytesRead.Add(n)
+            progress.add(n)
+        }
+        checkpoint := func(offset int64) {
+            if resumable {
+                scanCheckpoint.recordPartial(path, size, modTime, offset, digests)
+            }
+        }
+        if offset, err = parallel.hashParallel(ctx, file.(*os.File), offset, size, read, checkpoint); err != nil {
+            return nil, err
+        }
+        if _, err := file.(*os.File).Seek(offset, io.SeekStart); err != nil {
+            return nil, err
+        }
+    }
+
+    var src io.Reader = &contextReader{ctx: ctx, r: file}
+    if readLimiter != nil {
+        src = &throttledReader{ctx: ctx, r: src, limiter: readLimiter}
+    }
+    if handler != nil {
+        if src, err = handler.Normalize(src); err != nil {
+            return nil, err
+        }
+    }
+    dst := io.MultiWriter(writers...)
+    for {
+        var n int64
+        n, err = copyBuffer(dst, io.LimitReader(src, hashChunkSize))
+        offset += n
+        bytesRead.Add(n)
+        progress.add(n)
+        if err != nil || n < hashChunkSize {
+            break
+        }
+        if resumable && offset < size {
+            scanCheckpoint.recordPartial(path, size, modTime, offset, digests)
+        }
+    }
+    for _, pw := range pipes {
+        pw.CloseWithError(err)
+    }
+    wg.Wait()
+    if err != nil {
+        return nil, err
+    }
+    if sumErr != nil {
+        return nil, sumErr
+    }
+
+    for name, digest := range digests {
+        sums[name] = hex.EncodeToString(digest.Sum(nil))
+    }
+    return sums, nil
+}
+
+// skipTo moves r, a file of the given size (or -1 if unknown), offset bytes
+// in. Files that end before there are an error rather than hashing as empty.
+func skipTo(r io.Reader, offset, size int64) error {
+    if f, ok := r.(*os.File); ok && size >= 0 {
+        if size <= offset {
+            return fmt.Errorf("file is not longer than the %d bytes skipped", offset)
+        }
+        _, err := f.Seek(offset, io.SeekStart)
+        return err
+    }
+    if _, err := io.CopyN(io.Discard, r, offset); err != nil {
+        if err == io.EOF {
+            return fmt.Errorf("file is not longer than the %d bytes skipped", offset)
+        }
+        return err
+    }
+    return nil
+}
+
+// bufferPool holds the read buffers shared by all workers, sized by -buffer-size.
+var bufferPool = sync.Pool{
+    New: func() interface{} {
+        buf := make([]byte, bufferSizeKB*1024)
+        return &buf
+    },
+}
+
+// copyBuffer copies src to dst through a pooled buffer. Both sides are
+// wrapped so io.CopyBuffer cannot bypass the buffer via WriterTo or
+// ReaderFrom, which would allocate a fresh one on every call.
+func copyBuffer(dst io.Writer, src io.Reader) (int64, error) {
+    buf := bufferPool.Get().(*[]byte)
+    defer bufferPool.Put(buf)
+    return io.CopyBuffer(struct{ io.Writer }{dst}, struct{ io.Reader }{src}, *buf)
+}
+
+// contextReader stops reading from r once ctx is done.
+type contextReader struct {
+    ctx context.Context
+    r   io.Reader
+}
+
+func (c *contextReader) Read(p []byte) (int, error) {
+    if err := c.ctx.Err(); err != nil {
+        return 0, err
+    }
+    return c.r.Read(p)
+}
+
+// selectCanonical returns the member of a duplicate group that should be kept,
+// with every other member of the group as its children. Files from -reference
+// directories win over source files, then -protect files over unprotected
+// ones, and loose files win over archive entries. A file in an earlier
+// -priority-dirs entry wins next. With -prefer-ext, the best-ranked format
+// wins next, then the larger file, then the older one; otherwise the file
+// first in scan order is kept, whichever worker happened to hash it first.
+func selectCanonical(group *FileInfo) *FileInfo {
+    members := append([]*FileInfo{group}, group.Children...)
+
+    best := 0
+    for i, member := range members {
+        if preferCanonical(member, members[best]) {
+            best = i
+        }
+    }
+    if best == 0 {
+        return group
+    }
+
+    parent := members[best]
+    children := make([]*FileInfo, 0, len(members)-1)
+    for i, member := range members {
+        member.Children = nil
+        if i != best {
+            children = append(children, member)
+        }
+    }
+    parent.Children = children
+    return parent
+}
+
+// preferCanonical reports whether a should be kept in preference to b.
+func preferCanonical(a, b *FileInfo) bool {
+    if a.Reference != b.Reference {
+        return a.Reference
+    }
+    if a.Protected != b.Protected {
+        return a.Protected
+    }
+    if a.InArchive != b.InArchive {
+        return !a.InArchive
+    }
+    if len(priorityDirs) > 0 {
+        if ra, rb := dirPriority(a.Path), dirPriority(b.Path); ra != rb {
+            return ra < rb
+        }
+    }
+    if extRank != nil {
+        if ra, rb := extPreference(a.Name), extPreference(b.Name); ra != rb {
+            return ra < rb
+        }
+        if a.Size != b.Size {
+            return a.Size > b.Size
+        }
+        if !a.modTime.IsZero() && !b.modTime.IsZero() && !a.modTime.Equal(b.modTime) {
+            return a.modTime.Before(b.modTime)
+        }
+    }
+    return compareScanOrder(a, b) < 0
+}
+
+// compareScanOrder orders files the way the walk finds them: by the position
+// of their root among -reference and then -s, then by path.
+func compareScanOrder(a, b *FileInfo) int {
+    rootIndex := func(f *FileInfo) int {
+        if i := slices.Index(referenceDirs, f.SourceRoot); i >= 0 {
+            return i
+        }
+        return len(referenceDirs) + slices.Index(sourceDirs, f.SourceRoot)
+    }
+    return cmp.Or(cmp.Compare(rootIndex(a), rootIndex(b)), strings.Compare(a.Path, b.Path))
+}
+
+// sortGroups puts output, and the duplicates within each group, in path
+// order, so the same library always gives the same results file.
+func sortGroups(output []*FileInfo) {
+    byPath := func(a, b *FileInfo) int { return strings.Compare(a.Path, b.Path) }
+    for _, group := range output {
+        slices.SortFunc(group.Children, byPath)
+    }
+    slices.SortFunc(output, byPath)
+}
+
+// dirPriority returns the index of the first -priority-dirs entry containing
+// path, lower being better. Paths outside all of them rank after every one.
+func dirPriority(path string) int {
+    path = archivePath(path)
+    for i, dir := range priorityDirs {
+        if insideRoot(path, []string{dir}) {
+            return i
+        }
+    }
+    return len(priorityDirs)
+}
+
+// extPreference returns the -prefer-ext rank of name's extension, lower being
+// better. Unlisted extensions rank after every listed one.
+func extPreference(name string) int {
+    if rank, ok := extRank[strings.ToLower(filepath.Ext(name))]; ok {
+        return rank
+    }
+    return len(extRank)
+}
+
+// printDuplicatePaths writes the path of every duplicate that could be
+// deleted to w, newline- or, with -0, NUL-terminated.
+func printDuplicatePaths(w io.Writer, output []*FileInfo) error {
+    terminator := "\n"
+    if nullDelimited {
+        terminator = "\x00"
+    }
+
+    buf := bufio.NewWriter(w)
+    for fileInfo := range reportedGroups(output) {
+        for _, child := range fileInfo.Children {
+            if !child.readOnly() {
+                buf.WriteString(child.Path + terminator)
+            }
+        }
+    }
+    return buf.Flush()
+}
+
+// printKeeperPaths writes the path of the file kept from every group in
+// output, whether or not it has duplicates, terminated like
+// printDuplicatePaths. Archive entries have no path of their own to list.
+func printKeeperPaths(w io.Writer, output []*FileInfo) error {
+    terminator := "\n"
+    if nullDelimited {
+        terminator = "\x00"
+    }
+
+    buf := bufio.NewWriter(w)
     for _, fileInfo := range output {
-        err := os.RemoveAll(fileInfo.Path)
+        if !fileInfo.InArchive {
+            buf.WriteString(fileInfo.Path + terminator)
+        }
+    }
+    return buf.Flush()
+}
+
+// findContentDuplicates maps each hash that appears under more than one
+// filename to every path carrying it, whatever the grouping key was.
+func findContentDuplicates(groups []*FileInfo) map[string][]string {
+    paths := make(map[string][]string)
+    names := make(map[string]map[string]bool)
+
+    add := func(fileInfo *FileInfo) {
+        paths[fileInfo.Hash] = append(paths[fileInfo.Hash], fileInfo.Path)
+        if names[fileInfo.Hash] == nil {
+            names[fileInfo.Hash] = make(map[string]bool)
+        }
+        names[fileInfo.Hash][fileInfo.Name] = true
+    }
+
+    for _, fileInfo := range groups {
+        add(fileInfo)
+        for _, child := range fileInfo.Children {
+            add(child)
+        }
+    }
+
+    for hash := range paths {
+        if len(names[hash]) < 2 {
+            delete(paths, hash)
+            continue
+        }
+        sort.Strings(paths[hash])
+    }
+    return paths
+}
+
+func printContentDuplicates(contentDupes map[string][]string) {
+    if len(contentDupes) == 0 {
+        fmt.Fprintln(infoOut, "No content-identical files found across names")
+        return
+    }
+
+    hashes := make([]string, 0, len(contentDupes))
+    for hash := range contentDupes {
+        hashes = append(hashes, hash)
+    }
+    sort.Strings(hashes)
+
+    fmt.Fprintln(infoOut, "Content-identical files across names:")
+    for _, hash := range hashes {
+        fmt.Fprintf(infoOut, "  %s\n", hash)
+        for _, path := range contentDupes[hash] {
+            fmt.Fprintf(infoOut, "    %s\n", path)
+        }
+    }
+}
+
+// groupByFingerprint merges groups whose acoustic fingerprints are at least
+// fingerprintMin similar. Groups are taken in path order, so the same input
+// always gives the same clusters: the first group in each cluster stays the
+// parent and the others, along with their byte-identical duplicates, become
+// children. Groups that could not be fingerprinted are left as they are.
+func groupByFingerprint(groups []*FileInfo) []*FileInfo {
+    sortGroups(groups)
+    groupChan := make(chan *FileInfo, queueSize)
+    var wg sync.WaitGroup
+
+    for i := 0; i < numWorkers; i++ {
+        wg.Add(1)
+        go func() {
+            defer wg.Done()
+            for fileInfo := range groupChan {
+                log("Fingerprinting file: %s", fileInfo.Path)
+                fp, err := fingerprint(fileInfo.Path)
+                if err != nil {
+                    printError("Unable to fingerprint file %s: %v\n", fileInfo.Path, err)
+                    continue
+                }
+                fileInfo.fingerprint = fp
+            }
+        }()
+    }
+
+    for _, fileInfo := range groups {
+        if fileInfo.InArchive {
+            continue
+        }
+        groupChan <- fileInfo
+    }
+    close(groupChan)
+    wg.Wait()
+
+    var clusters []*FileInfo
+    for _, fileInfo := range groups {
+        var match *FileInfo
+        if len(fileInfo.fingerprint) > 0 {
+            for _, head := range clusters {
+                if scope == "per-source" && head.SourceRoot != fileInfo.SourceRoot {
+                    continue
+                }
+                if fingerprintSimilarity(head.fingerprint, fileInfo.fingerprint) >= fingerprintMin {
+                    match = head
+                    break
+                }
+            }
+        }
+
+        if match == nil {
+            clusters = append(clusters, fileInfo)
+            continue
+        }
+
+        log("Fingerprint match: %s ~ %s", fileInfo.Path, match.Path)
+        match.Children = append(match.Children, fileInfo)
+        match.Children = append(match.Children, fileInfo.Children...)
+        fileInfo.Children = nil
+    }
+
+    return clusters
+}
+
+// fingerprint runs fingerprintCmd on path and returns the raw Chromaprint
+// fingerprint it reports.
+func fingerprint(path string) ([]uint32, error) {
+    out, err := exec.Command(fingerprintCmd, "-raw", "-json", path).Output()
+    if err != nil {
+        return nil, err
+    }
+
+    var result struct {
+        Fingerprint []uint32 `json:"fingerprint"`
+    }
+    if err := json.Unmarshal(out, &result); err != nil {
+        return nil, fmt.Errorf("invalid output from %s: %v", fingerprintCmd, err)
+    }
+    if len(result.Fingerprint) == 0 {
+        return nil, fmt.Errorf("empty fingerprint from %s", fingerprintCmd)
+    }
+    return result.Fingerprint, nil
+}
+
+// fingerprintSimilarity returns the fraction of matching bits between two
+// fingerprints over their common length.
+func fingerprintSimilarity(a, b []uint32) float64 {
+    n := len(a)
+    if len(b) < n {
+        n = len(b)
+    }
+    if n == 0 {
+        return 0
+    }
+
+    diff := 0
+    for i := 0; i < n; i++ {
+        diff += bits.OnesCount32(a[i] ^ b[i])
+    }
+    return 1 - float64(diff)/float64(n*32)
+}
+
+// reportedGroups yields the groups with at least -min-duplicates duplicates,
+// or with -unique-only the files with none. Only what is reported is
+// filtered; copying and deleting still act on every group.
+func reportedGroups(output []*FileInfo) iter.Seq[*FileInfo] {
+    return func(yield func(*FileInfo) bool) {
+        for _, fileInfo := range output {
+            if uniqueOnly {
+                if !isNewFile(fileInfo) {
+                    continue
+                }
+            } else if len(fileInfo.Children) < minDuplicates {
+                continue
+            }
+            if !yield(fileInfo) {
+                return
+            }
+        }
+    }
+}
+
+// isNewFile reports whether fileInfo has no duplicates and, with -unique-in,
+// lies under that directory. Reference files are never new.
+func isNewFile(fileInfo *FileInfo) bool {
+    if len(fileInfo.Children) > 0 || fileInfo.Reference {
+        return false
+    }
+    return uniqueIn == "" || insideRoot(archivePath(fileInfo.Path), []string{uniqueIn})
+}
+
+// schemaVersion identifies the shape of the results file. Bump it whenever
+// a field is added, removed or changes meaning.
+const schemaVersion = 8
+
+// writeResults writes the results file to w: an object holding the schema
+// version, when it was generated, whether the scan completed, the duplicate
+// groups under "files", or with -group-by dir the same files arranged under
+// "directories", and the files left out because they changed during the scan.
+// Only the encoding is streamed: output, and with -group-by dir every
+// directory, is already in memory, so the groups themselves are not bounded;
+// -low-memory is what keeps memory flat for very large libraries.
+func writeResults(w io.Writer, output []*FileInfo) error {
+    buf := bufio.NewWriter(w)
+    generatedAt, _ := json.Marshal(generationTime().Format(time.RFC3339))
+    fmt.Fprintf(buf, "{\n    \"schema_version\": %d,\n    \"generated_at\": %s,\n    \"scan_complete\": %t,\n", schemaVersion, generatedAt, !scanIncomplete)
+
+    var err error
+    if comparisonResult != nil {
+        buf.WriteString(`    "comparison": `)
+        var data []byte
+        if data, err = json.MarshalIndent(comparisonResult, "    ", "    "); err == nil {
+            _, err = buf.Write(data)
+        }
+    } else if groupBy == "dir" {
+        buf.WriteString(`    "directories": `)
+        err = encodeJSONArray(buf, slices.Values(groupByDir(slices.Collect(relativeGroups(reportedGroups(output))))), "    ")
+    } else {
+        buf.WriteString(`    "files": `)
+        err = encodeJSONArray(buf, relativeGroups(reportedGroups(output)), "    ")
+    }
+    if err != nil {
+        return err
+    }
+    changed := sortedChanged()
+    for i := range changed {
+        changed[i].Path = relativePath(changed[i].Path)
+    }
+    buf.WriteString(",\n    \"changed_during_scan\": ")
+    if err := encodeJSONArray(buf, slices.Values(changed), "    "); err != nil {
+        return err
+    }
+    broken := sortedBrokenLinks()
+    for i := range broken {
+        broken[i].Path = relativePath(broken[i].Path)
+    }
+    buf.WriteString(",\n    \"broken_symlinks\": ")
+    if err := encodeJSONArray(buf, slices.Values(broken), "    "); err != nil {
+        return err
+    }
+    failed := sortedFileErrors()
+    for i := range failed {
+        failed[i].Path = relativePath(failed[i].Path)
+        failed[i].Root = relativePath(failed[i].Root)
+    }
+    buf.WriteString(",\n    \"errors\": ")
+    if err := encodeJSONArray(buf, slices.Values(failed), "    "); err != nil {
+        return err
+    }
+    if deniedHashes != nil {
+        denied := sortedDenied()
+        for i := range denied {
+            denied[i].Path = relativePath(denied[i].Path)
+        }
+        buf.WriteString(",\n    \"denied\": ")
+        if err := encodeJSONArray(buf, slices.Values(denied), "    "); err != nil {
+            return err
+        }
+    }
+    if skipSilent {
+        silent := sortedSilent()
+        for i := range silent {
+            silent[i].Path = relativePath(silent[i].Path)
+        }
+        buf.WriteString(",\n    \"skipped_silent\": ")
+        if err := encodeJSONArray(buf, slices.Values(silent), "    "); err != nil {
+            return err
+        }
+    }
+
+    buf.WriteString("\n}\n")
+    return buf.Flush()
+}
+
+// generationTime returns the time recorded as generated_at: now, unless
+// SOURCE_DATE_EPOCH is set, so a results file kept under version control only
+// changes when the library does.
+func generationTime() time.Time {
+    if epoch := os.Getenv("SOURCE_DATE_EPOCH"); epoch != "" {
+        if secs, err := strconv.ParseInt(epoch, 10, 64); err == nil {
+            return time.Unix(secs, 0).UTC()
+        }
+        fmt.Fprintf(os.Stderr, "Warning: ignoring SOURCE_DATE_EPOCH=%q, which is not a number of seconds\n", epoch)
+    }
+    return time.Now().UTC()
+}
+
+// encodeJSONArray writes the elements of seq to buf as a JSON array indented
+// to sit at prefix, encoding one element at a time so the encoded JSON is
+// never held in memory as a whole. The elements themselves may be.
+func encodeJSONArray[T any](buf *bufio.Writer, seq iter.Seq[T], prefix string) error {
+    first := true
+    for item := range seq {
+        data, err := json.MarshalIndent(item, prefix+"    ", "    ")
         if err != nil {
-            return fmt.Errorf("error deleting file %s: %v", fileInfo.Path, err)
+            return err
         }
-        for _, child := range fileInfo.Children {
-            err = os.RemoveAll(child.Path)
-            if err != nil {
-                return fmt.Errorf("error deleting file %s: %v", child.Path, err)
+        if first {
+            buf.WriteString("[\n" + prefix + "    ")
+            first = false
+        } else {
+            buf.WriteString(",\n" + prefix + "    ")
+        }
+        buf.Write(data)
+    }
+    if first {
+        buf.WriteString("[]")
+    } else {
+        buf.WriteString("\n" + prefix + "]")
+    }
+    return nil
+}
+
+// rewriteJSONFile replaces filename with the results by writing a temporary file
+// next to it, or in -tmp-dir, and renaming it into place, so readers never see
+// a partial file.
+func rewriteJSONFile(filename string, output []*FileInfo) error {
+    file, err := createTemp(filepath.Dir(filename), ".dedupe-music-*.json")
+    if err != nil {
+        return err
+    }
+    defer os.Remove(file.Name())
+
+    // CreateTemp makes the file private; results are as readable as before.
+    if err := file.Chmod(0644); err != nil {
+        file.Close()
+        return err
+    }
+    if err := writeResultsFile(file, filename, output); err != nil {
+        file.Close()
+        return err
+    }
+    if err := file.Close(); err != nil {
+        return err
+    }
+    return renameInto(file.Name(), filename)
+}
+
+// writeJSONToFile writes the results to filename through rewriteJSONFile, so
+// a run that fails part way leaves no partial file to block the next one.
+// Without -force an existing file is left alone.
+func writeJSONToFile(filename string, output []*FileInfo) error {
+    if !forceOverwrite {
+        if _, err := os.Lstat(filename); err == nil {
+            return fmt.Errorf("%s already exists (use -force to overwrite)", filename)
+        }
+    }
+    return rewriteJSONFile(filename, output)
+}
+
+// writeResultsFile writes the results to w, gzip-compressed if filename ends
+// in .gz.
+func writeResultsFile(w io.Writer, filename string, output []*FileInfo) error {
+    if !strings.HasSuffix(filename, ".gz") {
+        return writeResults(w, output)
+    }
+    gz := gzip.NewWriter(w)
+    if err := writeResults(gz, output); err != nil {
+        return err
+    }
+    return gz.Close()
+}
+
+func writeStatsToFile(filename string, stats runStats) error {
+    file, err := os.Create(filename)
+    if err != nil {
+        return err
+    }
+    defer file.Close()
+
+    encoder := json.NewEncoder(file)
+    encoder.SetIndent("", "    ")
+    return encoder.Encode(stats)
+}
+
+// reservedDests holds the destination paths copyFile has handed out.
+var (
+    reservedDests = make(map[string]bool)
+    destMutex     sync.Mutex
+)
+
+// xattrWarning warns about -preserve-xattr being unsupported only once.
+var xattrWarning sync.Once
+
+func copyFile(srcPath, destDir string, fileInfo *FileInfo) (string, error) {
+    if destDir == "" {
+        return "", nil
+    }
+
+    var srcFile *os.File
+    err := withRetry(context.Background(), srcPath, func() error {
+        var err error
+        srcFile, err = os.Open(srcPath)
+        return err
+    })
+    if err != nil {
+        return "", err
+    }
+    defer srcFile.Close()
+
+    if err := os.MkdirAll(destDir, os.ModePerm); err != nil {
+        return "", err
+    }
+
+    // Copies run concurrently, so a name is reserved as soon as it is chosen
+    // rather than when the file appears.
+    destMutex.Lock()
+    destPath, err := destinationPath(destDir, fileInfo, func(path string) bool {
+        if reservedDests[path] {
+            return true
+        }
+        _, err := os.Stat(path)
+        return !os.IsNotExist(err)
+    })
+    if err == nil {
+        reservedDests[destPath] = true
+    }
+    destMutex.Unlock()
+    if err != nil {
+        return "", err
+    }
+
+    cloned := false
+    if reflink {
+        if err := reflinkFile(srcPath, destPath); err != nil {
+            log("Unable to reflink %s, copying instead: %v", srcPath, err)
+        } else {
+            cloned = true
+        }
+    }
+    if !cloned {
+        // copyContents removes a partial copy, so a retry starts afresh.
+        err := withRetry(context.Background(), srcPath, func() error {
+            if _, err := srcFile.Seek(0, io.SeekStart); err != nil {
+                return err
+            }
+            return copyContents(destPath, srcFile)
+        })
+        if err != nil {
+            return "", err
+        }
+    }
+
+    // Extended attributes are copied before the mode is changed, since
+    // Linux only lets them be set on a writable file.
+    if preserveXattr {
+        if err := copyXattrs(srcPath, destPath); errors.Is(err, errors.ErrUnsupported) {
+            xattrWarning.Do(func() {
+                fmt.Fprintf(os.Stderr, "Warning: extended attributes of %s could not be copied: %v; copies are made without them\n", srcPath, err)
+            })
+        } else if err != nil {
+            return "", err
+        }
+    }
+
+    if preserveAttrs == "mode" || preserveAttrs == "all" {
+        info, err := srcFile.Stat()
+        if err != nil {
+            return "", err
+        }
+        if err := os.Chmod(destPath, info.Mode()); err != nil {
+            return "", err
+        }
+    }
+
+    if copyUID >= 0 || copyGID >= 0 {
+        if err := os.Chown(destPath, copyUID, copyGID); err != nil {
+            return "", err
+        }
+    }
+    if copyModeBits != "" {
+        if err := os.Chmod(destPath, copyPerm); err != nil {
+            return "", err
+        }
+    }
+
+    if preserveAttrs == "times" || preserveAttrs == "all" {
+        atime, mtime, err := getFileTimes(srcPath)
+        if err != nil {
+            return "", err
+        }
+        if err := os.Chtimes(destPath, atime, mtime); err != nil {
+            return "", err
+        }
+    }
+    return destPath, nil
+}
+
+// copyContents writes everything read from src to a new file at destPath. A
+// partially written file is removed rather than left behind as a truncated
+// copy.
+func copyContents(destPath string, src io.Reader) error {
+    destFile, err := os.Create(destPath)
+    if err != nil {
+        return err
+    }
+
+    _, err = copyBuffer(destFile, src)
+    if closeErr := destFile.Close(); err == nil {
+        err = closeErr
+    }
+    if err != nil {
+        os.Remove(destPath)
+    }
+    return err
+}
+
+// copyFiles copies output to -t as -copy-mode says: the canonical file of
+// each group, every file, or the canonical file plus a manifest of the
+// duplicates left behind. -copy-workers files are copied at once. Without
+// -continue-on-copy-error the first failure stops any further copies from
+// starting, and every error from the copies already under way is returned;
+// with it a failed copy is reported and skipped. Either way the group is then
+// left out of -delete-source-files.
+func copyFiles(output []*FileInfo) error {
+    type copyJob struct {
+        index    int
+        group    *FileInfo
+        fileInfo *FileInfo
+    }
+
+    var (
+        mu          sync.Mutex
+        copied      int
+        copiedBytes int64
+        failed      int
+        errs        []error
+        manifest    = make([]*manifestEntry, len(output))
+    )
+
+    jobs := make(chan copyJob)
+    var wg sync.WaitGroup
+    for i := 0; i < copyWorkers; i++ {
+        wg.Add(1)
+        go func() {
+            defer wg.Done()
+            for job := range jobs {
+                fileInfo := job.fileInfo
+                log("Copying file: %s", fileInfo.Path)
+                destPath, err := copyFile(fileInfo.Path, copyDestDir(fileInfo), fileInfo)
+
+                mu.Lock()
+                if err != nil {
+                    job.group.copyFailed = true
+                    if isDiskFull(err) {
+                        err = fmt.Errorf("%s is full after copying %d files (%s): %v", targetDir, copied, formatSize(copiedBytes), err)
+                    }
+                    if continueOnCopyError {
+                        printError("Unable to copy file %s: %v\n", fileInfo.Path, err)
+                        failed++
+                    } else {
+                        errs = append(errs, fmt.Errorf("error copying file %s: %v", fileInfo.Path, err))
+                    }
+                    mu.Unlock()
+                    continue
+                }
+                log("Successfully copied file: %s", fileInfo.Path)
+                copied++
+                copiedBytes += fileInfo.Size
+
+                if copyMode == "representative-tagged" && len(job.group.Children) > 0 {
+                    entry := &manifestEntry{CopiedTo: destPath, Source: fileInfo.Path}
+                    if rel, err := filepath.Rel(targetDir, destPath); err == nil {
+                        entry.CopiedTo = filepath.ToSlash(rel)
+                    }
+                    for _, child := range job.group.Children {
+                        entry.Dropped = append(entry.Dropped, child.Path)
+                    }
+                    manifest[job.index] = entry
+                }
+                mu.Unlock()
+            }
+        }()
+    }
+
+    stopped := func() bool {
+        mu.Lock()
+        defer mu.Unlock()
+        return len(errs) > 0
+    }
+feed:
+    for i, group := range output {
+        for _, fileInfo := range filesToCopy(group) {
+            if stopped() {
+                break feed
+            }
+            if existing, ok := presentInTarget(fileInfo); ok {
+                fmt.Fprintf(infoOut, "Already present: %s (as %s)\n", fileInfo.Path, existing)
+                continue
+            }
+            jobs <- copyJob{index: i, group: group, fileInfo: fileInfo}
+        }
+    }
+    close(jobs)
+    wg.Wait()
+
+    if len(errs) > 0 {
+        return errors.Join(errs...)
+    }
+    if failed > 0 {
+        fmt.Fprintf(os.Stderr, "Warning: %d file(s) could not be copied; they and their duplicates are kept\n", failed)
+    }
+    if copyMode == "representative-tagged" {
+        var entries []manifestEntry
+        for _, entry := range manifest {
+            if entry != nil {
+                entries = append(entries, *entry)
+            }
+        }
+        if err := writeManifest(entries); err != nil {
+            return fmt.Errorf("error writing manifest: %v", err)
+        }
+        fmt.Fprintf(infoOut, "Dropped duplicates listed in %s\n", filepath.Join(targetDir, manifestName))
+    }
+    return nil
+}
+
+// destinationPath returns where fileInfo should be copied in destDir,
+// applying -rename-template until taken reports a free name. Names are
+// sanitized and shortened to fit filesystem limits, keeping the extension.
+func destinationPath(destDir string, fileInfo *FileInfo, taken func(string) bool) (string, error) {
+    filename := sanitizeName(fileInfo.Name)
+    destPath := filepath.Join(destDir, fitName(filename, func(name string) string { return name }))
+
+    i := 1
+    for taken(destPath) {
+        renamed := fitName(filename, func(name string) string { return renameCopy(name, i, fileInfo.Hash) })
+        next := filepath.Join(destDir, sanitizeName(renamed))
+        if next == destPath {
+            return "", fmt.Errorf("rename template %q does not produce a free name for %s", renameTemplate, filename)
+        }
+        destPath = next
+        i++
+    }
+    return destPath, checkPathLength(destPath)
+}
+
+// copyDestDir returns the directory fileInfo is copied into. With
+// -preserve-tree that is its directory relative to its source root, placed
+// under -t; otherwise it is -t itself.
+func copyDestDir(fileInfo *FileInfo) string {
+    if !preserveTree {
+        return targetDir
+    }
+
+    path := fileInfo.Path
+    if fileInfo.InArchive {
+        path, _, _ = strings.Cut(path, zipSeparator)
+    }
+    rel, err := filepath.Rel(fileInfo.SourceRoot, filepath.Dir(path))
+    if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+        return targetDir
+    }
+    return filepath.Join(targetDir, rel)
+}
+
+// renameCopy fills in renameTemplate for the n-th attempt at naming a copy of filename.
+func renameCopy(filename string, n int, hash string) string {
+    ext := filepath.Ext(filename)
+    return strings.NewReplacer(
+        "{name}", strings.TrimSuffix(filename, ext),
+        "{n}", strconv.Itoa(n),
+        "{ext}", ext,
+        "{hash}", hash,
+    ).Replace(renameTemplate)
+}
+
+// inexactMatch names the matching option in use that can group files whose
+// content differs, or returns "" if every group holds identical files.
+func inexactMatch() string {
+    if fuzzyName && !confirmBytes {
+        return "-fuzzy-name without -confirm-bytes"
+    }
+    if matchMode == "audio-props" {
+        return "-match audio-props"
+    }
+    if fingerprintMode {
+        return "-fingerprint"
+    }
+    return ""
+}
+
+// filesToDelete returns the files in group that -delete-source-files removes:
+// all of them once they have been copied to -t, or with -dedupe-target only
+// the duplicates, since the first file is the one kept. Read-only files are
+// never included.
+func filesToDelete(group *FileInfo) []*FileInfo {
+    var files []*FileInfo
+    if !dedupeTarget && !group.readOnly() {
+        files = append(files, group)
+    }
+    for _, child := range group.Children {
+        if !child.readOnly() {
+            files = append(files, child)
+        }
+    }
+    return files
+}
+
+// confirmDeletion shows how many files deleteFiles would remove, how much
+// space that frees and which source roots it touches, then asks for the word
+// 'permanent'. It reports whether deletion was confirmed.
+func confirmDeletion(output []*FileInfo) bool {
+    var count int
+    var size int64
+    roots := make(map[string]bool)
+    for _, group := range output {
+        if group.copyFailed {
+            continue
+        }
+        for _, fileInfo := range filesToDelete(group) {
+            count++
+            size += fileInfo.Size
+            roots[fileInfo.SourceRoot] = true
+        }
+    }
+
+    fmt.Fprintf(infoOut, "About to permanently delete %d files, freeing %s, from %d source roots:\n", count, formatSize(size), len(roots))
+    for _, root := range slices.Sorted(maps.Keys(roots)) {
+        fmt.Fprintf(infoOut, "  %s\n", root)
+    }
+    if watchMode {
+        fmt.Fprintln(infoOut, "Files found while watching will be deleted as they are processed.")
+    }
+
+    fmt.Fprint(infoOut, "Enter the word 'permanent' and hit enter to confirm: ")
+    input, _ := stdin.ReadString('\n')
+    return strings.TrimSpace(input) == "permanent"
+}
+
+// deleteFiles attempts to delete every file in output, even after a failure,
+// and returns the combined errors for the paths it could not delete.
+func deleteFiles(output []*FileInfo) error {
+    var errs []error
+    for _, group := range output {
+        if group.copyFailed {
+            // The only copy of this content is still the source.
+            continue
+        }
+        for _, fileInfo := range filesToDelete(group) {
+            if err := os.RemoveAll(fileInfo.Path); err != nil {
+                errs = append(errs, fmt.Errorf("error deleting file %s: %v", fileInfo.Path, err))
             }
         }
     }
+
+    if len(errs) > 0 {
+        return fmt.Errorf("%d file(s) could not be deleted:\n%v", len(errs), errors.Join(errs...))
+    }
     log("Source files deleted")
     return nil
 }
 
+// pruneEmptyDirs removes the directories that deleting output's files left
+// empty, working up from each file's directory. A source root is never
+// removed, nor is anything outside one.
+func pruneEmptyDirs(output []*FileInfo, roots []string) error {
+    dirs := make(map[string]bool)
+    for _, group := range output {
+        for _, fileInfo := range filesToDelete(group) {
+            dirs[filepath.Dir(fileInfo.Path)] = true
+        }
+    }
+
+    // Deepest first, so a parent is only checked once its children are gone.
+    sorted := make([]string, 0, len(dirs))
+    for dir := range dirs {
+        sorted = append(sorted, dir)
+    }
+    sort.Slice(sorted, func(i, j int) bool {
+        return strings.Count(sorted[i], string(filepath.Separator)) > strings.Count(sorted[j], string(filepath.Separator))
+    })
+
+    for _, dir := range sorted {
+        for insideRoot(dir, roots) {
+            entries, err := os.ReadDir(dir)
+            if os.IsNotExist(err) {
+                break
+            }
+            if err != nil {
+                return err
+            }
+            if len(entries) > 0 {
+                break
+            }
+
+            if err := os.Remove(dir); err != nil {
+                return err
+            }
+            log("Removed empty directory: %s", dir)
+            dir = filepath.Dir(dir)
+        }
+    }
+    return nil
+}
+
+// insideRoot reports whether path lies strictly below one of roots.
+func insideRoot(path string, roots []string) bool {
+    path = filepath.Clean(path)
+    for _, root := range roots {
+        root = filepath.Clean(root)
+        rel, err := filepath.Rel(root, path)
+        if err == nil && rel != "." && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+            return true
+        }
+    }
+    return false
+}
+
 func log(msg string, args ...interface{}) {
     if logEnabled {
-        fmt.Printf(msg+"\n", args...)
+        fmt.Fprintf(infoOut, msg+"\n", args...)
     }
 }
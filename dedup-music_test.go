@@ -0,0 +1,421 @@
+package main
+
+import (
+    "crypto/md5"
+    "fmt"
+    "io"
+    "os"
+    "os/exec"
+    "path/filepath"
+    "runtime"
+    "slices"
+    "strings"
+    "sync"
+    "testing"
+    "time"
+)
+
+// setFlag sets a flag variable for the duration of the test.
+func setFlag[T any](t *testing.T, p *T, v T) {
+    t.Helper()
+    old := *p
+    *p = v
+    t.Cleanup(func() { *p = old })
+}
+
+// scanFiles writes files, a map of name to content, into a new directory and
+// processes them in name order as the walk would, returning the groups.
+func scanFiles(t *testing.T, files map[string]string, names ...string) (string, map[string]*FileInfo) {
+    t.Helper()
+    dir := t.TempDir()
+    fileMap := make(map[string]*FileInfo)
+    var fileMapMutex sync.Mutex
+    for _, name := range names {
+        path := filepath.Join(dir, name)
+        if err := os.WriteFile(path, []byte(files[name]), 0644); err != nil {
+            t.Fatal(err)
+        }
+        processFile(fileJob{path: path, root: dir}, fileMap, &fileMapMutex)
+    }
+    return dir, fileMap
+}
+
+func TestInexactMatch(t *testing.T) {
+    tests := []struct {
+        name         string
+        matchMode    string
+        fuzzyName    bool
+        confirmBytes bool
+        fingerprint  bool
+        inexact      bool
+    }{
+        {"content", "name-hash", false, false, false, false},
+        {"content confirmed", "name-hash", false, true, false, false},
+        {"content and size", "name-hash-size", false, false, false, false},
+        {"fuzzy name", "name-hash", true, false, false, true},
+        {"fuzzy name confirmed", "name-hash", true, true, false, false},
+        {"audio props", "audio-props", false, false, false, true},
+        {"fingerprint", "name-hash", false, false, true, true},
+        {"fingerprint confirmed", "name-hash", false, true, true, true},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            setFlag(t, &matchMode, tt.matchMode)
+            setFlag(t, &fuzzyName, tt.fuzzyName)
+            setFlag(t, &confirmBytes, tt.confirmBytes)
+            setFlag(t, &fingerprintMode, tt.fingerprint)
+            if got := inexactMatch(); (got != "") != tt.inexact {
+                t.Errorf("inexactMatch() = %q, want inexact %v", got, tt.inexact)
+            }
+        })
+    }
+}
+
+func TestFuzzyNameNeverDeletesDifferentContent(t *testing.T) {
+    setFlag(t, &fuzzyName, true)
+    setFlag(t, &confirmBytes, true)
+
+    files := map[string]string{
+        "Track 01.wav":     "first take",
+        "Track_01 (1).wav": "second take",
+        "track-01.wav":     "first take",
+    }
+    _, fileMap := scanFiles(t, files, "Track 01.wav", "Track_01 (1).wav", "track-01.wav")
+
+    if len(fileMap) != 2 {
+        t.Fatalf("got %d groups, want 2", len(fileMap))
+    }
+    for _, group := range fileMap {
+        for _, file := range filesToDelete(group) {
+            if files[file.Name] != files[group.Name] {
+                t.Errorf("%s would be deleted along with %s, but their content differs", file.Name, group.Name)
+            }
+        }
+    }
+}
+
+func TestWriteJSONRefusesToOverwrite(t *testing.T) {
+    path := filepath.Join(t.TempDir(), "dedupe-music.json")
+    if err := os.WriteFile(path, []byte("earlier results"), 0644); err != nil {
+        t.Fatal(err)
+    }
+
+    setFlag(t, &forceOverwrite, false)
+    err := writeJSONToFile(path, nil)
+    if err == nil || !strings.Contains(err.Error(), "-force") {
+        t.Fatalf("writeJSONToFile over an existing file = %v, want an error suggesting -force", err)
+    }
+    if data, _ := os.ReadFile(path); string(data) != "earlier results" {
+        t.Fatalf("existing file was changed to %q", data)
+    }
+
+    setFlag(t, &forceOverwrite, true)
+    if err := writeJSONToFile(path, nil); err != nil {
+        t.Fatalf("writeJSONToFile with -force: %v", err)
+    }
+    if data, _ := os.ReadFile(path); !strings.Contains(string(data), `"schema_version"`) {
+        t.Fatalf("file was not overwritten with results: %q", data)
+    }
+}
+
+func TestWriteJSONLeavesNoTempFiles(t *testing.T) {
+    setFlag(t, &forceOverwrite, false)
+    setFlag(t, &tmpDir, "")
+    dir := t.TempDir()
+    path := filepath.Join(dir, "dedupe-music.json")
+    if err := writeJSONToFile(path, nil); err != nil {
+        t.Fatal(err)
+    }
+
+    entries, err := os.ReadDir(dir)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if len(entries) != 1 || entries[0].Name() != "dedupe-music.json" {
+        t.Errorf("directory holds %v, want only the results file", entries)
+    }
+    info, err := os.Stat(path)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if runtime.GOOS != "windows" && info.Mode().Perm() != 0644 {
+        t.Errorf("results file mode = %v, want 0644", info.Mode().Perm())
+    }
+}
+
+func TestGenerateKeyFieldsDoNotCollide(t *testing.T) {
+    setFlag(t, &matchMode, "name-hash")
+    setFlag(t, &fuzzyName, false)
+    setFlag(t, &ignoreNameCase, false)
+    setFlag(t, &scope, "per-source")
+
+    tests := []struct {
+        name string
+        a, b FileInfo
+    }{
+        {"separator in name", FileInfo{Name: "a|b", Hash: "c"}, FileInfo{Name: "a", Hash: "b|c"}},
+        {"separator in source root", FileInfo{SourceRoot: "/m|x", Name: "a", Hash: "h"}, FileInfo{SourceRoot: "/m", Name: "x|a", Hash: "h"}},
+        {"separator in namespace", FileInfo{Namespace: "n|1", Name: "a", Hash: "h"}, FileInfo{Namespace: "n", Name: "1|a", Hash: "h"}},
+        {"length-like name", FileInfo{Name: "1:a", Hash: "h"}, FileInfo{Name: "a", Hash: "h", SourceRoot: "1"}},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            if ka, kb := generateKey(&tt.a), generateKey(&tt.b); ka == kb {
+                t.Errorf("both files have key %q", ka)
+            }
+        })
+    }
+
+    a := FileInfo{SourceRoot: "/m", Namespace: "n", Name: "a|b", Hash: "h"}
+    b := a
+    if generateKey(&a) != generateKey(&b) {
+        t.Errorf("identical files have different keys")
+    }
+}
+
+func TestIsAllowed(t *testing.T) {
+    setFlag(t, &fileExtensions, map[string]bool{".mp3": true, ".flac": true})
+
+    tests := []struct {
+        name string
+        want bool
+    }{
+        {"track.mp3", true},
+        {"track.MP3", true},
+        {"Track.FlAc", true},
+        {"live.at.the.bbc.mp3", true},
+        {"track.mp3.bak", false},
+        {"track.wav", false},
+        {"mp3", false},
+        {"track", false},
+        {".mp3", true},
+        {"track.", false},
+    }
+    for _, tt := range tests {
+        if got := isAllowed(tt.name); got != tt.want {
+            t.Errorf("isAllowed(%q) = %v, want %v", tt.name, got, tt.want)
+        }
+    }
+}
+
+func TestOverlappingRoots(t *testing.T) {
+    base := t.TempDir()
+    music := filepath.Join(base, "music")
+    if err := os.MkdirAll(filepath.Join(music, "rock"), 0755); err != nil {
+        t.Fatal(err)
+    }
+    alias := filepath.Join(base, "alias")
+    if err := os.Symlink(music, alias); err != nil {
+        t.Fatal(err)
+    }
+
+    tests := []struct {
+        name   string
+        target string
+        want   bool
+    }{
+        {"same directory", music, true},
+        {"nested", filepath.Join(music, "rock"), true},
+        {"nested and not yet created", filepath.Join(music, "copies"), true},
+        {"through a symlink", filepath.Join(alias, "copies"), true},
+        {"dot segments", filepath.Join(base, "other", "..", "music", "copies"), true},
+        {"parent of the source", base, false},
+        {"sibling with the same prefix", filepath.Join(base, "music-copies"), false},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            root, got := overlappingRoot(tt.target, []string{music})
+            if got != tt.want {
+                t.Errorf("overlappingRoot(%s) = %q, %v; want %v", tt.target, root, got, tt.want)
+            }
+        })
+    }
+
+    kept := dedupeRoots(DirList{filepath.Join(music, "rock"), music, alias, filepath.Join(base, "music-copies")})
+    want := DirList{music, filepath.Join(base, "music-copies")}
+    if !slices.Equal(kept, want) {
+        t.Errorf("dedupeRoots kept %v, want %v", kept, want)
+    }
+}
+
+func TestPruneEmptyDirs(t *testing.T) {
+    setFlag(t, &dedupeTarget, false)
+    base := t.TempDir()
+    root, other := filepath.Join(base, "root"), filepath.Join(base, "other")
+    files := []string{
+        "root/keep.wav",
+        "root/album1/disc1/x.wav",
+        "root/album2/y.wav",
+        "root/album2/cover.jpg",
+        "other/z.wav",
+    }
+    for _, file := range files {
+        path := filepath.Join(base, file)
+        if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+            t.Fatal(err)
+        }
+        if err := os.WriteFile(path, nil, 0644); err != nil {
+            t.Fatal(err)
+        }
+    }
+    if err := os.Mkdir(filepath.Join(root, "empty-before"), 0755); err != nil {
+        t.Fatal(err)
+    }
+
+    group := &FileInfo{Path: filepath.Join(root, "keep.wav"), Reference: true}
+    for _, file := range []string{"root/album1/disc1/x.wav", "root/album2/y.wav", "other/z.wav"} {
+        path := filepath.Join(base, file)
+        group.Children = append(group.Children, &FileInfo{Path: path})
+        if err := os.Remove(path); err != nil {
+            t.Fatal(err)
+        }
+    }
+
+    if err := pruneEmptyDirs([]*FileInfo{group}, []string{root, other}); err != nil {
+        t.Fatal(err)
+    }
+
+    for dir, want := range map[string]bool{
+        "root":              true,
+        "root/album1":       false,
+        "root/album1/disc1": false,
+        "root/album2":       true,
+        "root/empty-before": true,
+        "other":             true,
+    } {
+        _, err := os.Stat(filepath.Join(base, dir))
+        if exists := err == nil; exists != want {
+            t.Errorf("%s exists = %v, want %v", dir, exists, want)
+        }
+    }
+}
+
+// BenchmarkCopySmallFiles hashes many small files through copyBuffer and,
+// for comparison, through io.Copy, which allocates a buffer per file.
+func BenchmarkCopySmallFiles(b *testing.B) {
+    dir := b.TempDir()
+    const files, size = 100, 16 * 1024
+    paths := make([]string, files)
+    for i := range paths {
+        paths[i] = filepath.Join(dir, fmt.Sprintf("%03d.wav", i))
+        if err := os.WriteFile(paths[i], make([]byte, size), 0644); err != nil {
+            b.Fatal(err)
+        }
+    }
+
+    copiers := []struct {
+        name string
+        copy func(io.Writer, io.Reader) (int64, error)
+    }{
+        {"pooled", copyBuffer},
+        {"io.Copy", io.Copy},
+    }
+    for _, c := range copiers {
+        b.Run(c.name, func(b *testing.B) {
+            b.ReportAllocs()
+            b.SetBytes(files * size)
+            for range b.N {
+                for _, path := range paths {
+                    f, err := os.Open(path)
+                    if err != nil {
+                        b.Fatal(err)
+                    }
+                    _, err = c.copy(md5.New(), f)
+                    f.Close()
+                    if err != nil {
+                        b.Fatal(err)
+                    }
+                }
+            }
+        })
+    }
+}
+
+func TestConfirmBytesRecordsCompareErrors(t *testing.T) {
+    setFlag(t, &fuzzyName, true)
+    setFlag(t, &confirmBytes, true)
+    setFlag(t, &fileErrors, nil)
+
+    dir := t.TempDir()
+    fileMap := make(map[string]*FileInfo)
+    var fileMapMutex sync.Mutex
+    first, second := filepath.Join(dir, "Track 01.wav"), filepath.Join(dir, "track_01 (1).wav")
+    for _, path := range []string{first, second} {
+        if err := os.WriteFile(path, []byte("take"), 0644); err != nil {
+            t.Fatal(err)
+        }
+    }
+    processFile(fileJob{path: first, root: dir}, fileMap, &fileMapMutex)
+    // The first file vanishing makes the byte comparison fail.
+    if err := os.Remove(first); err != nil {
+        t.Fatal(err)
+    }
+    processFile(fileJob{path: second, root: dir}, fileMap, &fileMapMutex)
+
+    errs := sortedFileErrors()
+    if len(errs) != 1 || errs[0].Path != second {
+        t.Fatalf("recorded errors %+v, want one for %s", errs, second)
+    }
+    for _, group := range fileMap {
+        if len(group.Children) > 0 {
+            t.Errorf("%s was grouped with %s without being compared", group.Children[0].Path, group.Path)
+        }
+    }
+}
+
+func TestGroupByFingerprintIsDeterministic(t *testing.T) {
+    if _, err := exec.LookPath("sh"); err != nil {
+        t.Skip("no sh to fake the fingerprint command with")
+    }
+    dir := t.TempDir()
+    fpcalc := filepath.Join(dir, "fpcalc")
+    if err := os.WriteFile(fpcalc, []byte("#!/bin/sh\ncat \"$3.fp\"\n"), 0755); err != nil {
+        t.Fatal(err)
+    }
+    setFlag(t, &fingerprintCmd, fpcalc)
+    setFlag(t, &fingerprintMin, 0.7)
+    setFlag(t, &scope, "global")
+    setFlag(t, &infoOut, io.Writer(io.Discard))
+
+    // a and b, and b and c, are similar enough to merge, but a and c are not,
+    // so the clusters depend on which group is seen first.
+    fingerprints := map[string]string{"a.wav": "0", "b.wav": "255", "c.wav": "65535"}
+    for name, fp := range fingerprints {
+        path := filepath.Join(dir, name)
+        if err := os.WriteFile(path+".fp", []byte(`{"fingerprint":[`+fp+`]}`), 0644); err != nil {
+            t.Fatal(err)
+        }
+    }
+
+    for _, order := range [][]string{{"a.wav", "b.wav", "c.wav"}, {"c.wav", "b.wav", "a.wav"}, {"b.wav", "c.wav", "a.wav"}} {
+        var groups []*FileInfo
+        for _, name := range order {
+            groups = append(groups, &FileInfo{Name: name, Path: filepath.Join(dir, name)})
+        }
+        var got []string
+        for _, cluster := range groupByFingerprint(groups) {
+            names := cluster.Name
+            for _, child := range cluster.Children {
+                names += "+" + child.Name
+            }
+            got = append(got, names)
+        }
+        if want := []string{"a.wav+b.wav", "c.wav"}; !slices.Equal(got, want) {
+            t.Errorf("groups in order %v clustered as %v, want %v", order, got, want)
+        }
+    }
+}
+
+func TestSlowestReportsMiBPerSec(t *testing.T) {
+    setFlag(t, &slowFiles, nil)
+    recordHashTime("fast.wav", 4<<20, time.Second)
+    recordHashTime("slow.wav", 3<<20, 2*time.Second)
+
+    files := slowest()
+    if len(files) != 2 || files[0].Path != "slow.wav" {
+        t.Fatalf("slowest() = %+v, want slow.wav first", files)
+    }
+    if files[0].MiBPerSec != 1.5 || files[1].MiBPerSec != 4 {
+        t.Errorf("rates %.2f and %.2f MiB/s, want 1.50 and 4.00", files[0].MiBPerSec, files[1].MiBPerSec)
+    }
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+    "bufio"
+    "os"
+    "slices"
+    "strings"
+    "sync"
+)
+
+// deniedHashes holds the -deny-hashes list, lowercased.
+var deniedHashes map[string]bool
+
+// deniedFile is a scanned file whose hash is on the denylist. It is left out
+// of the duplicate groups and listed under "denied" in the results.
+type deniedFile struct {
+    Path string `json:"path"`
+    Hash string `json:"hash"`
+    Size int64  `json:"size"`
+}
+
+var (
+    deniedFiles []deniedFile
+    deniedMutex sync.Mutex
+)
+
+// loadDenyList reads one hash per line from path. Blank lines and lines
+// starting with # are skipped, and anything after the hash is ignored, so
+// md5sum or sha256sum output can be used as is.
+func loadDenyList(path string) (map[string]bool, error) {
+    file, err := os.Open(path)
+    if err != nil {
+        return nil, err
+    }
+    defer file.Close()
+
+    hashes := make(map[string]bool)
+    scanner := bufio.NewScanner(file)
+    for scanner.Scan() {
+        fields := strings.Fields(scanner.Text())
+        if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+            continue
+        }
+        hashes[strings.ToLower(strings.TrimPrefix(fields[0], `\`))] = true
+    }
+    return hashes, scanner.Err()
+}
+
+// checkDenied records path and reports true if any of its hashes is on the
+// denylist.
+func checkDenied(path string, size int64, hashes map[string]string) bool {
+    for _, name := range hashNames() {
+        sum := hashes[name]
+        if !deniedHashes[strings.ToLower(sum)] {
+            continue
+        }
+        log("Skipping denied file %s (%s %s)", path, name, sum)
+        deniedMutex.Lock()
+        deniedFiles = append(deniedFiles, deniedFile{Path: path, Hash: sum, Size: size})
+        deniedMutex.Unlock()
+        return true
+    }
+    return false
+}
+
+// sortedDenied returns the denied files ordered by path.
+func sortedDenied() []deniedFile {
+    deniedMutex.Lock()
+    defer deniedMutex.Unlock()
+    sorted := slices.Clone(deniedFiles)
+    slices.SortFunc(sorted, func(a, b deniedFile) int { return strings.Compare(a.Path, b.Path) })
+    return sorted
+}
@@ -0,0 +1,19 @@
+//go:build windows
+
+package main
+
+import (
+    "errors"
+    "syscall"
+)
+
+// Windows error codes for a full disk.
+const (
+    errorHandleDiskFull syscall.Errno = 39
+    errorDiskFull       syscall.Errno = 112
+)
+
+// isDiskFull reports whether err means the filesystem ran out of space.
+func isDiskFull(err error) bool {
+    return errors.Is(err, errorDiskFull) || errors.Is(err, errorHandleDiskFull)
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+    "fmt"
+    "os"
+    "sort"
+    "strconv"
+    "strings"
+    "sync"
+)
+
+// DiskWorkers maps a path on a device to the number of workers hashing files
+// from that device, as in "/Volumes/SSD=8,/Volumes/HDD1=1".
+type DiskWorkers map[string]int
+
+func (d *DiskWorkers) String() string {
+    paths := make([]string, 0, len(*d))
+    for path := range *d {
+        paths = append(paths, path)
+    }
+    sort.Strings(paths)
+
+    pairs := make([]string, len(paths))
+    for i, path := range paths {
+        pairs[i] = path + "=" + strconv.Itoa((*d)[path])
+    }
+    return strings.Join(pairs, ",")
+}
+
+func (d *DiskWorkers) Set(value string) error {
+    if *d == nil {
+        *d = make(DiskWorkers)
+    }
+    for _, pair := range strings.Split(value, ",") {
+        path, count, ok := strings.Cut(pair, "=")
+        if !ok || strings.TrimSpace(path) == "" {
+            return fmt.Errorf("expected path=workers, got %q", pair)
+        }
+        n, err := strconv.Atoi(strings.TrimSpace(count))
+        if err != nil || n < 1 {
+            return fmt.Errorf("invalid worker count in %q", pair)
+        }
+        (*d)[strings.TrimSpace(path)] = n
+    }
+    return nil
+}
+
+// diskPools routes each file to the worker pool for its device. Files on
+// devices without a -threads-per-disk entry go to the shared pool.
+type diskPools struct {
+    shared   chan fileJob
+    byDevice map[uint64]chan fileJob
+}
+
+// startDiskPools starts the shared pool of numWorkers workers and one pool
+// per -threads-per-disk device.
+func startDiskPools(fileMap map[string]*FileInfo, fileMapMutex *sync.Mutex, wg *sync.WaitGroup) (*diskPools, error) {
+    pools := &diskPools{
+        shared:   make(chan fileJob, queueSize),
+        byDevice: make(map[uint64]chan fileJob),
+    }
+    start := func(ch chan fileJob, n int) {
+        for i := 0; i < n; i++ {
+            wg.Add(1)
+            go worker(ch, fileMap, fileMapMutex, wg)
+        }
+    }
+    start(pools.shared, numWorkers)
+
+    for path, n := range threadsPerDisk {
+        info, err := os.Stat(path)
+        if err != nil {
+            return nil, err
+        }
+        dev, ok := fileDevice(info)
+        if !ok {
+            fmt.Fprintf(os.Stderr, "Warning: Device of %s is unknown on this platform, ignoring its worker count\n", path)
+            continue
+        }
+        if _, exists := pools.byDevice[dev]; exists {
+            fmt.Fprintf(os.Stderr, "Warning: %s shares a device with another -threads-per-disk entry, ignoring it\n", path)
+            continue
+        }
+        ch := make(chan fileJob, queueSize)
+        pools.byDevice[dev] = ch
+        start(ch, n)
+        log("Using %d workers for the device holding %s", n, path)
+    }
+    return pools, nil
+}
+
+// route returns the queue for a file with the given information.
+func (p *diskPools) route(info os.FileInfo) chan<- fileJob {
+    if dev, ok := fileDevice(info); ok {
+        if ch, ok := p.byDevice[dev]; ok {
+            return ch
+        }
+    }
+    return p.shared
+}
+
+// close ends every queue so the workers exit once they are drained.
+func (p *diskPools) close() {
+    close(p.shared)
+    for _, ch := range p.byDevice {
+        close(ch)
+    }
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+    "crypto/sha256"
+    "encoding/hex"
+    "fmt"
+    "path/filepath"
+    "sort"
+    "strings"
+)
+
+// dirNode is a scanned directory: the hashes of the files directly in it, the
+// subdirectories that hold scanned files, and totals for the whole subtree.
+type dirNode struct {
+    hashes  []string
+    subdirs map[string]bool
+    hash    string
+    files   int
+    size    int64
+}
+
+// dirDuplicate is a set of directories holding the same files by content,
+// including everything below them.
+type dirDuplicate struct {
+    dirs  []string
+    files int
+    size  int64
+}
+
+// findDuplicateDirs hashes every directory from the sorted hashes of the
+// files and subdirectories in it and groups directories whose hashes match.
+// Names are not compared. A group is left out when all of its directories
+// sit inside directories that are already reported, so a duplicated album is
+// listed once rather than once per disc folder.
+func findDuplicateDirs(output []*FileInfo) []dirDuplicate {
+    nodes := make(map[string]*dirNode)
+    node := func(dir string) *dirNode {
+        n, ok := nodes[dir]
+        if !ok {
+            n = &dirNode{subdirs: make(map[string]bool)}
+            nodes[dir] = n
+        }
+        return n
+    }
+    add := func(fileInfo *FileInfo) {
+        if fileInfo.InArchive {
+            return
+        }
+        dir := filepath.Dir(fileInfo.Path)
+        n := node(dir)
+        n.hashes = append(n.hashes, fileInfo.Hash)
+        n.files++
+        n.size += fileInfo.Size
+        root := filepath.Clean(fileInfo.SourceRoot)
+        for dir != root {
+            parent := filepath.Dir(dir)
+            if parent == dir {
+                break
+            }
+            node(parent).subdirs[dir] = true
+            dir = parent
+        }
+    }
+    for _, fileInfo := range output {
+        add(fileInfo)
+        for _, child := range fileInfo.Children {
+            add(child)
+        }
+    }
+
+    // Deepest first, so each subdirectory is hashed before its parent.
+    dirs := make([]string, 0, len(nodes))
+    for dir := range nodes {
+        dirs = append(dirs, dir)
+    }
+    sort.Slice(dirs, func(i, j int) bool {
+        return strings.Count(dirs[i], string(filepath.Separator)) > strings.Count(dirs[j], string(filepath.Separator))
+    })
+
+    byHash := make(map[string][]string)
+    for _, dir := range dirs {
+        n := nodes[dir]
+        entries := make([]string, 0, len(n.hashes)+len(n.subdirs))
+        for _, hash := range n.hashes {
+            entries = append(entries, "f:"+hash)
+        }
+        for subdir := range n.subdirs {
+            sub := nodes[subdir]
+            entries = append(entries, "d:"+sub.hash)
+            n.files += sub.files
+            n.size += sub.size
+        }
+        sort.Strings(entries)
+        sum := sha256.Sum256([]byte(strings.Join(entries, "\n")))
+        n.hash = hex.EncodeToString(sum[:])
+        byHash[n.hash] = append(byHash[n.hash], dir)
+    }
+
+    duplicated := func(dir string) bool {
+        n, ok := nodes[dir]
+        return ok && len(byHash[n.hash]) > 1
+    }
+    var groups []dirDuplicate
+    for _, list := range byHash {
+        if len(list) < 2 {
+            continue
+        }
+        covered := true
+        for _, dir := range list {
+            if !duplicated(filepath.Dir(dir)) {
+                covered = false
+                break
+            }
+        }
+        if covered {
+            continue
+        }
+        sort.Strings(list)
+        n := nodes[list[0]]
+        groups = append(groups, dirDuplicate{dirs: list, files: n.files, size: n.size})
+    }
+    sort.Slice(groups, func(i, j int) bool {
+        if groups[i].size != groups[j].size {
+            return groups[i].size > groups[j].size
+        }
+        return groups[i].dirs[0] < groups[j].dirs[0]
+    })
+    return groups
+}
+
+func printDuplicateDirs(groups []dirDuplicate) {
+    if len(groups) == 0 {
+        fmt.Fprintln(infoOut, "No duplicate directories found")
+        return
+    }
+
+    fmt.Fprintln(infoOut, "Duplicate directories (the same files by content, including subdirectories):")
+    for _, group := range groups {
+        fmt.Fprintf(infoOut, "  %d files, %s\n", group.files, yellow(formatSize(group.size)))
+        for _, dir := range group.dirs {
+            fmt.Fprintf(infoOut, "    %s\n", dir)
+        }
+    }
+}
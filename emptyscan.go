@@ -0,0 +1,35 @@
+package main
+
+import (
+    "fmt"
+    "maps"
+    "os"
+    "slices"
+    "strings"
+)
+
+// filesFound returns how many files passed the walk's filters, whether or
+// not they went on to be hashed.
+func filesFound() int64 {
+    return filesScanned.Load() + int64(len(silentFiles)+len(changedFiles)+len(fileErrors))
+}
+
+// warnNoFiles explains a scan that found nothing to hash, listing the
+// filters that may have excluded every file.
+func warnNoFiles() {
+    fmt.Fprintf(os.Stderr, "Warning: no eligible files were found in %s. Filters in effect:\n", strings.Join(append(slices.Clone(referenceDirs), sourceDirs...), ", "))
+    fmt.Fprintf(os.Stderr, "  only %s files are scanned\n", strings.Join(slices.Sorted(maps.Keys(fileExtensions)), ", "))
+    fmt.Fprintf(os.Stderr, "  -size: files smaller than %s are skipped\n", formatSize(int64(minSize)))
+    if len(extMinSize) > 0 {
+        fmt.Fprintf(os.Stderr, "  -size-ext: %s\n", extMinSize.String())
+    }
+    if includeRegex != "" {
+        fmt.Fprintf(os.Stderr, "  -include-regex: only paths matching %q are scanned\n", includeRegex)
+    }
+    if excludeRegex != "" {
+        fmt.Fprintf(os.Stderr, "  -exclude-regex: paths matching %q are skipped\n", excludeRegex)
+    }
+    if skipHidden {
+        fmt.Fprintln(os.Stderr, "  -skip-hidden: hidden files and directories are skipped")
+    }
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+    "errors"
+    "fmt"
+    "os"
+    "os/exec"
+    "strings"
+    "sync"
+)
+
+// splitCommand splits an -exec template into arguments at unquoted spaces.
+// Single or double quotes group words, so placeholders can sit inside a
+// longer argument.
+func splitCommand(template string) ([]string, error) {
+    var args []string
+    var current strings.Builder
+    inArg := false
+    var quote rune
+    for _, r := range template {
+        switch {
+        case quote != 0 && r == quote:
+            quote = 0
+        case quote != 0:
+            current.WriteRune(r)
+        case r == '\'' || r == '"':
+            quote = r
+            inArg = true
+        case r == ' ' || r == '\t':
+            if inArg {
+                args = append(args, current.String())
+                current.Reset()
+                inArg = false
+            }
+        default:
+            current.WriteRune(r)
+            inArg = true
+        }
+    }
+    if quote != 0 {
+        return nil, fmt.Errorf("unterminated %c quote", quote)
+    }
+    if inArg {
+        args = append(args, current.String())
+    }
+    if len(args) == 0 {
+        return nil, errors.New("empty command")
+    }
+    return args, nil
+}
+
+// execPair is one canonical file and one of its duplicates.
+type execPair struct {
+    canonical *FileInfo
+    duplicate *FileInfo
+}
+
+// runExecHooks runs the -exec command once for every duplicate of every
+// reported group, -exec-workers at a time. The command is run directly, not
+// through a shell, so paths need no quoting. Unless -exec-continue-on-error
+// is set, the first failure stops further commands from starting.
+func runExecHooks(output []*FileInfo) error {
+    args, err := splitCommand(execTemplate)
+    if err != nil {
+        return err
+    }
+
+    var (
+        mu     sync.Mutex
+        errs   []error
+        failed int
+    )
+    pairs := make(chan execPair)
+    var wg sync.WaitGroup
+    for i := 0; i < execWorkers; i++ {
+        wg.Add(1)
+        go func() {
+            defer wg.Done()
+            for pair := range pairs {
+                replacer := strings.NewReplacer(
+                    "{canonical}", pair.canonical.Path,
+                    "{duplicate}", pair.duplicate.Path,
+                    "{hash}", pair.duplicate.Hash,
+                )
+                cmdArgs := make([]string, len(args))
+                for j, arg := range args {
+                    cmdArgs[j] = replacer.Replace(arg)
+                }
+
+                log("Running %s", strings.Join(cmdArgs, " "))
+                cmd := exec.Command(cmdArgs[0], cmdArgs[1:]...)
+                cmd.Stdout = infoOut
+                cmd.Stderr = os.Stderr
+                if err := cmd.Run(); err != nil {
+                    mu.Lock()
+                    if execContinueOnError {
+                        printError("-exec failed for %s: %v\n", pair.duplicate.Path, err)
+                        failed++
+                    } else {
+                        errs = append(errs, fmt.Errorf("-exec failed for %s: %v", pair.duplicate.Path, err))
+                    }
+                    mu.Unlock()
+                }
+            }
+        }()
+    }
+
+    stopped := func() bool {
+        mu.Lock()
+        defer mu.Unlock()
+        return len(errs) > 0
+    }
+feed:
+    for group := range reportedGroups(output) {
+        for _, child := range group.Children {
+            if stopped() {
+                break feed
+            }
+            pairs <- execPair{canonical: group, duplicate: child}
+        }
+    }
+    close(pairs)
+    wg.Wait()
+
+    if len(errs) > 0 {
+        return errors.Join(errs...)
+    }
+    if failed > 0 {
+        fmt.Fprintf(os.Stderr, "Warning: -exec failed for %d duplicate(s)\n", failed)
+    }
+    return nil
+}
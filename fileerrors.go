@@ -0,0 +1,110 @@
+package main
+
+import (
+    "compress/gzip"
+    "encoding/json"
+    "fmt"
+    "io"
+    "os"
+    "slices"
+    "strings"
+    "sync"
+)
+
+// fileError is a file that could not be stat'ed or hashed. It records the
+// root it was found under so -retry-errors can process it again as part of
+// the same scan.
+type fileError struct {
+    Path      string `json:"path"`
+    Root      string `json:"root"`
+    Reference bool   `json:"reference,omitempty"`
+    Error     string `json:"error"`
+}
+
+var (
+    fileErrors      []fileError
+    fileErrorsMutex sync.Mutex
+)
+
+// recordFileError notes that job failed with err. Archive entries are not
+// recorded, since they are read again only by scanning the whole archive.
+func recordFileError(job fileJob, err error) {
+    if job.archive {
+        return
+    }
+    fileErrorsMutex.Lock()
+    fileErrors = append(fileErrors, fileError{Path: job.path, Root: job.root, Reference: job.reference, Error: err.Error()})
+    fileErrorsMutex.Unlock()
+}
+
+// sortedFileErrors returns the failed files ordered by path.
+func sortedFileErrors() []fileError {
+    fileErrorsMutex.Lock()
+    defer fileErrorsMutex.Unlock()
+    sorted := slices.Clone(fileErrors)
+    slices.SortFunc(sorted, func(a, b fileError) int { return strings.Compare(a.Path, b.Path) })
+    return sorted
+}
+
+// priorResults is the part of an earlier results file -retry-errors reads.
+type priorResults struct {
+    SchemaVersion int         `json:"schema_version"`
+    Files         []*FileInfo `json:"files"`
+    Directories   []any       `json:"directories"`
+    Errors        []fileError `json:"errors"`
+}
+
+// loadPriorResults reads a results file written by an earlier run, gzipped
+// if its name ends in .gz.
+func loadPriorResults(path string) (*priorResults, error) {
+    file, err := os.Open(path)
+    if err != nil {
+        return nil, err
+    }
+    defer file.Close()
+
+    var r io.Reader = file
+    if strings.HasSuffix(path, ".gz") {
+        gz, err := gzip.NewReader(file)
+        if err != nil {
+            return nil, err
+        }
+        defer gz.Close()
+        r = gz
+    }
+
+    var prior priorResults
+    if err := json.NewDecoder(r).Decode(&prior); err != nil {
+        return nil, err
+    }
+    if prior.SchemaVersion < 7 {
+        return nil, fmt.Errorf("written with schema version %d, before failed files were recorded", prior.SchemaVersion)
+    }
+    if prior.Directories != nil {
+        return nil, fmt.Errorf("written with -group-by dir, which leaves out the file groups")
+    }
+    return &prior, nil
+}
+
+// priorRoots returns the reference and source roots of prior's files and
+// errors, in the order first seen.
+func priorRoots(prior *priorResults) (reference, source []string) {
+    add := func(root string, isReference bool) {
+        if isReference && !slices.Contains(reference, root) {
+            reference = append(reference, root)
+        } else if !isReference && !slices.Contains(source, root) {
+            source = append(source, root)
+        }
+    }
+    for _, group := range prior.Files {
+        for _, fileInfo := range append([]*FileInfo{group}, group.Children...) {
+            if fileInfo.SourceRoot != "" {
+                add(fileInfo.SourceRoot, fileInfo.Reference)
+            }
+        }
+    }
+    for _, e := range prior.Errors {
+        add(e.Root, e.Reference)
+    }
+    return reference, source
+}
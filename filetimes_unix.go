@@ -0,0 +1,21 @@
+//go:build unix
+
+package main
+
+import (
+    "time"
+
+    "golang.org/x/sys/unix"
+)
+
+func getFileTimes(path string) (accessTime, modTime time.Time, err error) {
+    var stat unix.Stat_t
+    err = unix.Stat(path, &stat)
+    if err != nil {
+        return
+    }
+
+    accessTime = time.Unix(int64(stat.Atim.Sec), int64(stat.Atim.Nsec))
+    modTime = time.Unix(int64(stat.Mtim.Sec), int64(stat.Mtim.Nsec))
+    return
+}
@@ -0,0 +1,27 @@
+//go:build windows
+
+package main
+
+import (
+    "fmt"
+    "os"
+    "syscall"
+    "time"
+)
+
+func getFileTimes(path string) (accessTime, modTime time.Time, err error) {
+    info, err := os.Stat(path)
+    if err != nil {
+        return
+    }
+
+    data, ok := info.Sys().(*syscall.Win32FileAttributeData)
+    if !ok {
+        err = fmt.Errorf("unexpected file attributes for %s", path)
+        return
+    }
+
+    accessTime = time.Unix(0, data.LastAccessTime.Nanoseconds())
+    modTime = time.Unix(0, data.LastWriteTime.Nanoseconds())
+    return
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+    "context"
+    "fmt"
+    "os"
+    "strings"
+    "sync"
+)
+
+var (
+    findMatches int
+    findMutex   sync.Mutex
+
+    // stopFind cancels the scan once -first-match has found a file.
+    stopFind context.CancelFunc = func() {}
+)
+
+// checkFindHash prints path to stdout if one of its hashes is the -find-hash
+// digest. With -first-match only the first file found is printed, and the
+// scan is stopped.
+func checkFindHash(path string, hashes map[string]string) {
+    matched := false
+    for _, name := range hashNames() {
+        if strings.EqualFold(hashes[name], findHash) {
+            matched = true
+            break
+        }
+    }
+    if !matched {
+        return
+    }
+
+    findMutex.Lock()
+    defer findMutex.Unlock()
+    if firstMatch && findMatches > 0 {
+        // Another worker finished hashing a match first.
+        return
+    }
+    findMatches++
+    terminator := "\n"
+    if nullDelimited {
+        terminator = "\x00"
+    }
+    fmt.Fprint(os.Stdout, path+terminator)
+    if firstMatch {
+        stopFind()
+    }
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+    "path"
+    "path/filepath"
+    "sort"
+)
+
+// dirGroup is one directory in -group-by dir output.
+type dirGroup struct {
+    Directory     string     `json:"directory"`
+    AllDuplicated bool       `json:"all_duplicated"`
+    Files         []*dirFile `json:"files"`
+}
+
+// dirFile is a file in a dirGroup, with the paths of its copies in other
+// directories.
+type dirFile struct {
+    Name   string   `json:"name"`
+    Path   string   `json:"path"`
+    Hash   string   `json:"hash"`
+    Size   int64    `json:"size"`
+    Copies []string `json:"copies,omitempty"`
+}
+
+// groupByDir reorganizes duplicate groups by the directory holding each file.
+// A directory is marked all_duplicated when every file in it has a copy in
+// some other directory, so the whole folder can go.
+func groupByDir(output []*FileInfo) []*dirGroup {
+    dirs := make(map[string]*dirGroup)
+    for _, fileInfo := range output {
+        members := append([]*FileInfo{fileInfo}, fileInfo.Children...)
+        for _, member := range members {
+            dir := fileDir(member)
+            file := &dirFile{Name: member.Name, Path: member.Path, Hash: member.Hash, Size: member.Size}
+            for _, other := range members {
+                if other != member && fileDir(other) != dir {
+                    file.Copies = append(file.Copies, other.Path)
+                }
+            }
+
+            group, ok := dirs[dir]
+            if !ok {
+                group = &dirGroup{Directory: dir, AllDuplicated: true}
+                dirs[dir] = group
+            }
+            group.Files = append(group.Files, file)
+            if len(file.Copies) == 0 {
+                group.AllDuplicated = false
+            }
+        }
+    }
+
+    groups := make([]*dirGroup, 0, len(dirs))
+    for _, group := range dirs {
+        sort.Slice(group.Files, func(i, j int) bool { return group.Files[i].Path < group.Files[j].Path })
+        groups = append(groups, group)
+    }
+    sort.Slice(groups, func(i, j int) bool { return groups[i].Directory < groups[j].Directory })
+    return groups
+}
+
+// fileDir returns the directory holding fileInfo; for an archive entry that is
+// the directory inside the archive.
+func fileDir(fileInfo *FileInfo) string {
+    if fileInfo.InArchive {
+        return path.Dir(fileInfo.Path)
+    }
+    return filepath.Dir(fileInfo.Path)
+}
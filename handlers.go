@@ -0,0 +1,47 @@
+package main
+
+import (
+    "io"
+    "path/filepath"
+    "strings"
+    "sync"
+)
+
+// Handler normalizes files of one type before they are hashed, so copies that
+// differ only in ways the format does not care about still match. A FLAC
+// handler might return just the audio frames, leaving out the metadata blocks.
+// Every algorithm requested with -hash is computed over what Normalize returns.
+type Handler interface {
+    Normalize(r io.Reader) (io.Reader, error)
+}
+
+var (
+    fileHandlers      = make(map[string]Handler)
+    fileHandlersMutex sync.RWMutex
+)
+
+// RegisterHandler makes h normalize every file with extension ext, given with
+// or without the leading dot and in any case. Registering a nil Handler
+// removes the one for ext, so those files are hashed whole again.
+func RegisterHandler(ext string, h Handler) {
+    ext = strings.ToLower(ext)
+    if !strings.HasPrefix(ext, ".") {
+        ext = "." + ext
+    }
+
+    fileHandlersMutex.Lock()
+    defer fileHandlersMutex.Unlock()
+    if h == nil {
+        delete(fileHandlers, ext)
+        return
+    }
+    fileHandlers[ext] = h
+}
+
+// handlerFor returns the Handler registered for the extension of path, or nil
+// if the file is hashed whole.
+func handlerFor(path string) Handler {
+    fileHandlersMutex.RLock()
+    defer fileHandlersMutex.RUnlock()
+    return fileHandlers[strings.ToLower(filepath.Ext(path))]
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+    "os"
+    "strings"
+)
+
+// junkNames are directories that hold nothing but metadata, such as the
+// resource forks macOS adds to zip archives.
+var junkNames = map[string]bool{
+    "__MACOSX": true,
+}
+
+// hiddenName reports whether a file or directory name is skipped by
+// -skip-hidden: dotfiles, which include "._" AppleDouble files, and known
+// junk directories.
+func hiddenName(name string) bool {
+    return strings.HasPrefix(name, ".") || junkNames[name]
+}
+
+// hiddenFile reports whether the walked file or directory is skipped by
+// -skip-hidden, by name or by the platform's hidden attribute.
+func hiddenFile(info os.FileInfo) bool {
+    return hiddenName(info.Name()) || hiddenAttribute(info)
+}
+
+// hiddenPath reports whether any element of rel, a path separated by
+// separator, is a hidden name.
+func hiddenPath(rel string, separator string) bool {
+    for _, name := range strings.Split(rel, separator) {
+        if hiddenName(name) && name != "." && name != ".." {
+            return true
+        }
+    }
+    return false
+}
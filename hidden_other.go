@@ -0,0 +1,11 @@
+//go:build !windows
+
+package main
+
+import "os"
+
+// hiddenAttribute always reports false outside Windows, where hidden files
+// are the dotfiles hiddenName already catches.
+func hiddenAttribute(info os.FileInfo) bool {
+    return false
+}
@@ -0,0 +1,15 @@
+//go:build windows
+
+package main
+
+import (
+    "os"
+    "syscall"
+)
+
+// hiddenAttribute reports whether Windows marks the file hidden or as a
+// system file.
+func hiddenAttribute(info os.FileInfo) bool {
+    data, ok := info.Sys().(*syscall.Win32FileAttributeData)
+    return ok && data.FileAttributes&(syscall.FILE_ATTRIBUTE_HIDDEN|syscall.FILE_ATTRIBUTE_SYSTEM) != 0
+}
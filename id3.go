@@ -0,0 +1,233 @@
+package main
+
+import (
+    "bytes"
+    "encoding/binary"
+    "fmt"
+    "io"
+    "path/filepath"
+    "strings"
+)
+
+// tagFields are the ID3 fields compared across a duplicate group, keyed by
+// their ID3v2.3/2.4 frame IDs. The ID3v2.2 and ID3v1 equivalents are mapped
+// onto the same names.
+var tagFields = []string{"TIT2", "TPE1", "TALB", "TRCK", "TYER", "TCON"}
+
+var id3v22Frames = map[string]string{
+    "TT2": "TIT2",
+    "TP1": "TPE1",
+    "TAL": "TALB",
+    "TRK": "TRCK",
+    "TYE": "TYER",
+    "TCO": "TCON",
+    "PIC": "APIC",
+}
+
+// tagSummary records which of tagFields an MP3 carries and whether it has
+// embedded album art.
+type tagSummary struct {
+    fields   map[string]bool
+    albumArt bool
+}
+
+// score ranks tag sets by completeness; album art counts as one field.
+func (t tagSummary) score() int {
+    n := len(t.fields)
+    if t.albumArt {
+        n++
+    }
+    return n
+}
+
+func (t tagSummary) String() string {
+    s := fmt.Sprintf("%d/%d tags", len(t.fields), len(tagFields))
+    if t.albumArt {
+        s += ", album art"
+    } else {
+        s += ", no album art"
+    }
+    return s
+}
+
+// readTags summarizes the ID3v2 and ID3v1 tags of the MP3 at path.
+func readTags(path string) (tagSummary, error) {
+    tags := tagSummary{fields: make(map[string]bool)}
+
+    file, err := openSource(path)
+    if err != nil {
+        return tags, err
+    }
+    data, err := io.ReadAll(file)
+    file.Close()
+    if err != nil {
+        return tags, err
+    }
+
+    parseID3v2(data, &tags)
+    parseID3v1(data, &tags)
+    return tags, nil
+}
+
+func parseID3v2(data []byte, tags *tagSummary) {
+    if len(data) < 10 || string(data[:3]) != "ID3" {
+        return
+    }
+    major := data[3]
+    flags := data[5]
+    end := 10 + syncsafe(data[6:10])
+    if end > len(data) {
+        end = len(data)
+    }
+
+    pos := 10
+    if flags&0x40 != 0 && pos+4 <= end {
+        // Skip the extended header. Its size excludes itself in v2.3.
+        size := int(binary.BigEndian.Uint32(data[pos : pos+4]))
+        if major >= 4 {
+            size = syncsafe(data[pos : pos+4])
+        } else {
+            size += 4
+        }
+        pos += size
+    }
+
+    idLen, headerLen := 4, 10
+    if major == 2 {
+        idLen, headerLen = 3, 6
+    }
+    for pos+headerLen <= end {
+        id := string(data[pos : pos+idLen])
+        if id[0] == 0 {
+            break // padding
+        }
+
+        var size int
+        switch major {
+        case 2:
+            size = int(data[pos+3])<<16 | int(data[pos+4])<<8 | int(data[pos+5])
+            id = id3v22Frames[id]
+        case 3:
+            size = int(binary.BigEndian.Uint32(data[pos+4 : pos+8]))
+        default:
+            size = syncsafe(data[pos+4 : pos+8])
+        }
+        pos += headerLen
+        if size < 0 || pos+size > end {
+            break
+        }
+        body := data[pos : pos+size]
+        pos += size
+
+        switch id {
+        case "APIC":
+            tags.albumArt = tags.albumArt || len(body) > 0
+        case "TDRC":
+            id = "TYER"
+            fallthrough
+        default:
+            if len(body) > 1 && len(bytes.Trim(body[1:], "\x00 ")) > 0 {
+                tags.fields[id] = true
+            }
+        }
+    }
+    for id := range tags.fields {
+        if !isTagField(id) {
+            delete(tags.fields, id)
+        }
+    }
+}
+
+func parseID3v1(data []byte, tags *tagSummary) {
+    if len(data) < 128 {
+        return
+    }
+    tag := data[len(data)-128:]
+    if string(tag[:3]) != "TAG" {
+        return
+    }
+
+    text := func(b []byte) bool { return len(bytes.Trim(b, "\x00 ")) > 0 }
+    if text(tag[3:33]) {
+        tags.fields["TIT2"] = true
+    }
+    if text(tag[33:63]) {
+        tags.fields["TPE1"] = true
+    }
+    if text(tag[63:93]) {
+        tags.fields["TALB"] = true
+    }
+    if text(tag[93:97]) {
+        tags.fields["TYER"] = true
+    }
+    // ID3v1.1 stores the track number after a zero byte in the comment.
+    if tag[125] == 0 && tag[126] != 0 {
+        tags.fields["TRCK"] = true
+    }
+    if tag[127] != 0xff {
+        tags.fields["TCON"] = true
+    }
+}
+
+func isTagField(id string) bool {
+    for _, field := range tagFields {
+        if field == id {
+            return true
+        }
+    }
+    return false
+}
+
+func syncsafe(b []byte) int {
+    return int(b[0]&0x7f)<<21 | int(b[1]&0x7f)<<14 | int(b[2]&0x7f)<<7 | int(b[3]&0x7f)
+}
+
+// printTagReport lists every duplicate group in which a file about to be
+// treated as a duplicate carries richer ID3 tags than the one being kept.
+func printTagReport(output []*FileInfo) {
+    found := false
+    for _, fileInfo := range output {
+        if len(fileInfo.Children) == 0 || !isMP3(fileInfo.Name) {
+            continue
+        }
+
+        keptTags, err := readTags(fileInfo.Path)
+        if err != nil {
+            printError("Unable to read tags from %s: %v\n", fileInfo.Path, err)
+            continue
+        }
+
+        var lines []string
+        for _, child := range fileInfo.Children {
+            if !isMP3(child.Name) {
+                continue
+            }
+            childTags, err := readTags(child.Path)
+            if err != nil {
+                printError("Unable to read tags from %s: %v\n", child.Path, err)
+                continue
+            }
+            if childTags.score() > keptTags.score() {
+                lines = append(lines, fmt.Sprintf("    richer:  %s (%s)", child.Path, childTags))
+            }
+        }
+        if len(lines) == 0 {
+            continue
+        }
+
+        if !found {
+            fmt.Fprintln(infoOut, "Duplicates with richer ID3 tags than the file kept:")
+            found = true
+        }
+        fmt.Fprintf(infoOut, "  kept:    %s (%s)\n", bold(fileInfo.Path), keptTags)
+        fmt.Fprintln(infoOut, strings.Join(lines, "\n"))
+    }
+
+    if !found {
+        fmt.Fprintln(infoOut, "No duplicates have richer ID3 tags than the file kept")
+    }
+}
+
+func isMP3(name string) bool {
+    return strings.EqualFold(filepath.Ext(name), ".mp3")
+}
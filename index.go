@@ -0,0 +1,89 @@
+package main
+
+import (
+    "bufio"
+    "compress/gzip"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "io"
+    "os"
+    "strings"
+    "sync"
+    "time"
+)
+
+// indexRecord is one line of the -index-only output.
+type indexRecord struct {
+    Path      string            `json:"path"`
+    Size      int64             `json:"size"`
+    Hash      string            `json:"hash"`
+    Namespace string            `json:"namespace,omitempty"`
+    Hashes    map[string]string `json:"hashes,omitempty"`
+    MTime     string            `json:"mtime,omitempty"`
+}
+
+// indexWriter writes an indexRecord per hashed file as newline-delimited
+// JSON, gzip-compressed if the file name ends in .gz.
+type indexWriter struct {
+    mutex   sync.Mutex
+    file    *os.File
+    gz      *gzip.Writer
+    buf     *bufio.Writer
+    encoder *json.Encoder
+    count   int
+}
+
+// fileIndex is set while an -index-only scan is running.
+var fileIndex *indexWriter
+
+func createIndex(filename string) (*indexWriter, error) {
+    flags := os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+    if !forceOverwrite {
+        flags = os.O_WRONLY | os.O_CREATE | os.O_EXCL
+    }
+    file, err := os.OpenFile(filename, flags, 0644)
+    if err != nil {
+        if errors.Is(err, os.ErrExist) {
+            return nil, fmt.Errorf("%s already exists (use -force to overwrite)", filename)
+        }
+        return nil, err
+    }
+
+    w := &indexWriter{file: file}
+    var dst io.Writer = file
+    if strings.HasSuffix(filename, ".gz") {
+        w.gz = gzip.NewWriter(file)
+        dst = w.gz
+    }
+    w.buf = bufio.NewWriter(dst)
+    w.encoder = json.NewEncoder(w.buf)
+    return w, nil
+}
+
+func (w *indexWriter) add(fileInfo *FileInfo) error {
+    record := indexRecord{
+        Path:      fileInfo.Path,
+        Size:      fileInfo.Size,
+        Hash:      fileInfo.Hash,
+        Namespace: fileInfo.Namespace,
+        Hashes:    fileInfo.Hashes,
+    }
+    if !fileInfo.modTime.IsZero() {
+        record.MTime = fileInfo.modTime.UTC().Format(time.RFC3339Nano)
+    }
+
+    w.mutex.Lock()
+    defer w.mutex.Unlock()
+    w.count++
+    return w.encoder.Encode(record)
+}
+
+// close flushes the index and closes its file.
+func (w *indexWriter) close() error {
+    err := w.buf.Flush()
+    if w.gz != nil {
+        err = errors.Join(err, w.gz.Close())
+    }
+    return errors.Join(err, w.file.Close())
+}
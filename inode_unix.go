@@ -0,0 +1,27 @@
+//go:build unix
+
+package main
+
+import (
+    "os"
+    "syscall"
+)
+
+// fileInode returns the device and inode behind info when the file has more
+// than one hard link.
+func fileInode(info os.FileInfo) (inode, bool) {
+    stat, ok := info.Sys().(*syscall.Stat_t)
+    if !ok || stat.Nlink < 2 {
+        return inode{}, false
+    }
+    return inode{dev: uint64(stat.Dev), ino: uint64(stat.Ino)}, true
+}
+
+// fileDevice returns the device holding the file described by info.
+func fileDevice(info os.FileInfo) (uint64, bool) {
+    stat, ok := info.Sys().(*syscall.Stat_t)
+    if !ok {
+        return 0, false
+    }
+    return uint64(stat.Dev), true
+}
@@ -0,0 +1,46 @@
+//go:build unix
+
+package main
+
+import (
+    "os"
+    "path/filepath"
+    "testing"
+)
+
+// TestFileInodeOnlyHardLinks checks that single-link files are not tracked,
+// so the inode map stays small even with -low-memory.
+func TestFileInodeOnlyHardLinks(t *testing.T) {
+    dir := t.TempDir()
+    single, linked, link := filepath.Join(dir, "single.wav"), filepath.Join(dir, "linked.wav"), filepath.Join(dir, "link.wav")
+    for _, path := range []string{single, linked} {
+        if err := os.WriteFile(path, []byte(path), 0644); err != nil {
+            t.Fatal(err)
+        }
+    }
+    if err := os.Link(linked, link); err != nil {
+        t.Skipf("hard links not supported: %v", err)
+    }
+
+    info, err := os.Stat(single)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if _, ok := fileInode(info); ok {
+        t.Error("fileInode tracks a file with a single link")
+    }
+
+    infoA, err := os.Stat(linked)
+    if err != nil {
+        t.Fatal(err)
+    }
+    infoB, err := os.Stat(link)
+    if err != nil {
+        t.Fatal(err)
+    }
+    a, okA := fileInode(infoA)
+    b, okB := fileInode(infoB)
+    if !okA || !okB || a != b {
+        t.Errorf("hard links give inodes %v (%v) and %v (%v), want the same", a, okA, b, okB)
+    }
+}
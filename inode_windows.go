@@ -0,0 +1,18 @@
+//go:build windows
+
+package main
+
+import "os"
+
+// fileInode always reports false on Windows, where the walk's file
+// information carries no file index, so hard links are hashed like any
+// other file.
+func fileInode(info os.FileInfo) (inode, bool) {
+    return inode{}, false
+}
+
+// fileDevice always reports false on Windows, so -threads-per-disk has no
+// effect there.
+func fileDevice(info os.FileInfo) (uint64, bool) {
+    return 0, false
+}
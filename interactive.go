@@ -0,0 +1,112 @@
+package main
+
+import (
+    "fmt"
+    "os"
+    "strconv"
+    "strings"
+)
+
+// reviewGroups walks through each duplicate group with the user, deleting
+// what they choose as soon as they choose it. Quitting, or reaching the end
+// of stdin, leaves every remaining group untouched. Deleting in all remaining
+// groups unseen is only offered when groups are matched on content, as
+// -delete-source-files requires.
+func reviewGroups(output []*FileInfo) {
+    var groups []*FileInfo
+    for _, fileInfo := range output {
+        if len(fileInfo.Children) > 0 {
+            groups = append(groups, fileInfo)
+        }
+    }
+    if len(groups) == 0 {
+        return
+    }
+
+    allowAll := true
+    if inexact := inexactMatch(); inexact != "" {
+        allowAll = false
+        fmt.Fprintf(infoOut, "Each group must be reviewed: %s can group files whose content differs.\n", inexact)
+    }
+
+    deleted, deletedBytes := 0, int64(0)
+    deleteAll := false
+    for i, group := range groups {
+        members := append([]*FileInfo{group}, group.Children...)
+
+        keep := 0
+        if !deleteAll {
+            fmt.Fprintf(infoOut, "\nGroup %d of %d:\n", i+1, len(groups))
+            for n, member := range members {
+                note := ""
+                if member.readOnly() {
+                    note = " (read-only)"
+                }
+                fmt.Fprintf(infoOut, "  %d) %s%s\n", n+1, member.Path, note)
+            }
+
+            choice, ok := promptChoice(len(members), allowAll)
+            switch {
+            case !ok || choice == "q":
+                fmt.Fprintln(infoOut, "Stopped reviewing; remaining groups were left as they are")
+                printReviewSummary(deleted, deletedBytes)
+                return
+            case choice == "s":
+                continue
+            case choice == "a":
+                deleteAll = true
+            case choice == "d":
+            default:
+                keep, _ = strconv.Atoi(choice)
+                keep--
+            }
+        }
+
+        for n, member := range members {
+            if n == keep || member.readOnly() {
+                continue
+            }
+            if err := os.Remove(member.Path); err != nil {
+                printError("Unable to delete file %s: %v\n", member.Path, err)
+                continue
+            }
+            log("Deleted %s", member.Path)
+            deleted++
+            deletedBytes += member.Size
+        }
+    }
+    printReviewSummary(deleted, deletedBytes)
+}
+
+// promptChoice asks what to do with a group of n files until it gets a valid
+// answer: d, s, a (only if allowAll), q, or the number of the file to keep.
+// It reports false at the end of input.
+func promptChoice(n int, allowAll bool) (string, bool) {
+    all := ""
+    if allowAll {
+        all = ", delete in [a]ll remaining groups"
+    }
+    for {
+        fmt.Fprintf(infoOut, "Keep 1 and [d]elete the rest, keep another [1-%d], [s]kip%s, [q]uit: ", n, all)
+        input, err := stdin.ReadString('\n')
+        choice := strings.ToLower(strings.TrimSpace(input))
+        switch choice {
+        case "d", "s", "q":
+            return choice, true
+        case "a":
+            if allowAll {
+                return choice, true
+            }
+        }
+        if keep, convErr := strconv.Atoi(choice); convErr == nil && keep >= 1 && keep <= n {
+            return choice, true
+        }
+        if err != nil {
+            return "", false
+        }
+    }
+}
+
+func printReviewSummary(deleted int, deletedBytes int64) {
+    fmt.Fprintf(infoOut, "Deleted %d files, %s freed\n", deleted, green(formatSize(deletedBytes)))
+}
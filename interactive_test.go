@@ -0,0 +1,54 @@
+package main
+
+import (
+    "bufio"
+    "io"
+    "os"
+    "path/filepath"
+    "strings"
+    "testing"
+)
+
+func TestReviewDeleteAllNeedsContentMatch(t *testing.T) {
+    tests := []struct {
+        name        string
+        fingerprint bool
+        deleted     bool
+    }{
+        {"content match", false, true},
+        {"fingerprint", true, false},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            setFlag(t, &fingerprintMode, tt.fingerprint)
+            setFlag(t, &infoOut, io.Writer(io.Discard))
+            setFlag(t, &stdin, bufio.NewReader(strings.NewReader("a\n")))
+
+            dir := t.TempDir()
+            var output []*FileInfo
+            var duplicates []string
+            for _, group := range []string{"one", "two"} {
+                var members []*FileInfo
+                for _, name := range []string{group + ".wav", group + " copy.wav"} {
+                    path := filepath.Join(dir, name)
+                    if err := os.WriteFile(path, []byte(name), 0644); err != nil {
+                        t.Fatal(err)
+                    }
+                    members = append(members, &FileInfo{Name: name, Path: path})
+                }
+                members[0].Children = members[1:]
+                output = append(output, members[0])
+                duplicates = append(duplicates, members[1].Path)
+            }
+
+            reviewGroups(output)
+
+            for _, path := range duplicates {
+                _, err := os.Stat(path)
+                if deleted := os.IsNotExist(err); deleted != tt.deleted {
+                    t.Errorf("%s deleted = %v, want %v", filepath.Base(path), deleted, tt.deleted)
+                }
+            }
+        })
+    }
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+    "bufio"
+    "encoding/json"
+    "hash/fnv"
+    "os"
+    "path/filepath"
+    "slices"
+    "strconv"
+    "sync"
+    "time"
+)
+
+// spillBuckets is how many files -low-memory spreads scanned files over.
+// Every file with the same key lands in the same bucket, so grouping only
+// ever needs one bucket in memory.
+const spillBuckets = 256
+
+// spillMap stands in for fileMap under -low-memory. Scanned files are
+// appended to bucket files on disk by key and grouped after the scan.
+type spillMap struct {
+    dir     string
+    buckets [spillBuckets]spillBucket
+}
+
+type spillBucket struct {
+    mutex   sync.Mutex
+    file    *os.File
+    writer  *bufio.Writer
+    encoder *json.Encoder
+}
+
+// spilledFile is one line of a bucket file. modTime and hashSource are not
+// exported by FileInfo, so they are carried alongside.
+type spilledFile struct {
+    Key        string    `json:"key"`
+    File       *FileInfo `json:"file"`
+    ModTime    time.Time `json:"mod_time"`
+    HashSource string    `json:"hash_source,omitempty"`
+}
+
+// fileSpill is set while a -low-memory scan is running.
+var fileSpill *spillMap
+
+// newSpillMap creates the bucket files in a new directory under -tmp-dir, or
+// the system temporary directory.
+func newSpillMap() (*spillMap, error) {
+    dir, err := os.MkdirTemp(tmpDir, "dedupe-music-spill-")
+    if err != nil {
+        return nil, err
+    }
+
+    s := &spillMap{dir: dir}
+    for i := range s.buckets {
+        file, err := os.Create(filepath.Join(dir, strconv.Itoa(i)))
+        if err != nil {
+            s.remove()
+            return nil, err
+        }
+        b := &s.buckets[i]
+        b.file = file
+        b.writer = bufio.NewWriter(file)
+        b.encoder = json.NewEncoder(b.writer)
+    }
+    return s, nil
+}
+
+// add writes fileInfo to the bucket for key.
+func (s *spillMap) add(key string, fileInfo *FileInfo) error {
+    h := fnv.New32a()
+    h.Write([]byte(key))
+    b := &s.buckets[h.Sum32()%spillBuckets]
+
+    b.mutex.Lock()
+    defer b.mutex.Unlock()
+    return b.encoder.Encode(spilledFile{Key: key, File: fileInfo, ModTime: fileInfo.modTime, HashSource: fileInfo.hashSource})
+}
+
+// duplicateGroups reads the buckets back one at a time and returns the groups
+// that have duplicates. Files without any are dropped, which is what keeps
+// memory bounded by the number of duplicates rather than of files.
+func (s *spillMap) duplicateGroups() ([]*FileInfo, error) {
+    var output []*FileInfo
+    for i := range s.buckets {
+        b := &s.buckets[i]
+        if err := b.writer.Flush(); err != nil {
+            return nil, err
+        }
+        if _, err := b.file.Seek(0, 0); err != nil {
+            return nil, err
+        }
+
+        groups := make(map[string]*FileInfo)
+        decoder := json.NewDecoder(bufio.NewReader(b.file))
+        for decoder.More() {
+            var spilled spilledFile
+            if err := decoder.Decode(&spilled); err != nil {
+                return nil, err
+            }
+            fileInfo := spilled.File
+            fileInfo.modTime = spilled.ModTime
+            fileInfo.hashSource = spilled.HashSource
+
+            existing, ok := groups[spilled.Key]
+            if !ok {
+                groups[spilled.Key] = fileInfo
+                continue
+            }
+            if existing.Path == fileInfo.Path || slices.ContainsFunc(existing.Children, func(child *FileInfo) bool { return child.Path == fileInfo.Path }) {
+                continue
+            }
+            existing.Children = append(existing.Children, fileInfo)
+        }
+
+        for _, group := range groups {
+            if len(group.Children) > 0 {
+                output = append(output, group)
+            }
+        }
+    }
+    return output, nil
+}
+
+// remove closes and deletes the bucket files.
+func (s *spillMap) remove() {
+    for i := range s.buckets {
+        if file := s.buckets[i].file; file != nil {
+            file.Close()
+        }
+    }
+    os.RemoveAll(s.dir)
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+    "bytes"
+    "fmt"
+    "io"
+    "path/filepath"
+    "sort"
+    "strings"
+    "sync"
+)
+
+// magicLen is how many leading bytes sniffFormat needs.
+const magicLen = 12
+
+// headWriter keeps the first bytes written to it and discards the rest, so
+// -check-magic can reuse the read made for hashing.
+type headWriter struct {
+    buf []byte
+}
+
+func newHeadWriter() *headWriter {
+    return &headWriter{buf: make([]byte, 0, magicLen)}
+}
+
+func (h *headWriter) Write(p []byte) (int, error) {
+    if room := cap(h.buf) - len(h.buf); room > 0 {
+        h.buf = append(h.buf, p[:min(room, len(p))]...)
+    }
+    return len(p), nil
+}
+
+// extensionFormats is the format each scanned extension should contain.
+var extensionFormats = map[string]string{
+    ".wav":  "WAV",
+    ".aif":  "AIFF",
+    ".aiff": "AIFF",
+    ".mp3":  "MP3",
+}
+
+// sniffFormat names the audio format head starts with, or "" if it is not
+// recognized.
+func sniffFormat(head []byte) string {
+    switch {
+    case len(head) >= 12 && bytes.Equal(head[:4], []byte("RIFF")) && bytes.Equal(head[8:12], []byte("WAVE")):
+        return "WAV"
+    case len(head) >= 12 && bytes.Equal(head[:4], []byte("FORM")) &&
+        (bytes.Equal(head[8:12], []byte("AIFF")) || bytes.Equal(head[8:12], []byte("AIFC"))):
+        return "AIFF"
+    case bytes.HasPrefix(head, []byte("fLaC")):
+        return "FLAC"
+    case bytes.HasPrefix(head, []byte("OggS")):
+        return "Ogg"
+    case bytes.HasPrefix(head, []byte("ID3")):
+        return "MP3"
+    case len(head) >= 2 && head[0] == 0xff && head[1]&0xe0 == 0xe0:
+        return "MP3"
+    }
+    return ""
+}
+
+// magicMismatch is a file whose content is a different format than its
+// extension claims.
+type magicMismatch struct {
+    path     string
+    ext      string
+    detected string
+}
+
+var (
+    magicMismatches []magicMismatch
+    magicMutex      sync.Mutex
+)
+
+// checkMagic records path if head shows a recognized format other than the
+// one its extension promises.
+func checkMagic(path string, head []byte) {
+    ext := strings.ToLower(filepath.Ext(path))
+    expected, ok := extensionFormats[ext]
+    detected := sniffFormat(head)
+    if !ok || detected == "" || detected == expected {
+        return
+    }
+
+    log("Format mismatch: %s looks like %s", path, detected)
+    magicMutex.Lock()
+    magicMismatches = append(magicMismatches, magicMismatch{path: path, ext: ext, detected: detected})
+    magicMutex.Unlock()
+}
+
+// readHead reads the leading bytes of a file whose hash was not computed in
+// this run, such as one taken from the checkpoint or a sidecar.
+func readHead(path string) ([]byte, error) {
+    file, err := openSource(path)
+    if err != nil {
+        return nil, err
+    }
+    defer file.Close()
+
+    head := make([]byte, magicLen)
+    n, err := io.ReadFull(file, head)
+    if err == io.ErrUnexpectedEOF || err == io.EOF {
+        err = nil
+    }
+    return head[:n], err
+}
+
+func printMagicMismatches() {
+    if len(magicMismatches) == 0 {
+        fmt.Fprintln(infoOut, "No extension/format mismatches found")
+        return
+    }
+
+    sort.Slice(magicMismatches, func(i, j int) bool { return magicMismatches[i].path < magicMismatches[j].path })
+    fmt.Fprintln(infoOut, "Files whose extension does not match their format:")
+    for _, m := range magicMismatches {
+        fmt.Fprintf(infoOut, "  %s (%s, looks like %s)\n", m.path, m.ext, yellow(m.detected))
+    }
+}
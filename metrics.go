@@ -0,0 +1,44 @@
+package main
+
+import (
+    "fmt"
+    "os"
+    "path/filepath"
+    "sync/atomic"
+)
+
+// errorCount counts the errors reported during a run, for -metrics-file.
+var errorCount atomic.Int64
+
+// writeMetricsFile writes stats in the Prometheus text exposition format. The
+// file is written under a temporary name and renamed into place so the node
+// exporter's textfile collector never reads a partial file.
+func writeMetricsFile(filename string, stats runStats) error {
+    file, err := createTemp(filepath.Dir(filename), ".dedupe-music-*.prom")
+    if err != nil {
+        return err
+    }
+    defer os.Remove(file.Name())
+
+    metrics := []struct {
+        name, kind, help string
+        value            float64
+    }{
+        {"dedupe_files_scanned_total", "counter", "Files hashed during the run.", float64(stats.FilesScanned)},
+        {"dedupe_duplicate_groups", "gauge", "Files that have at least one duplicate.", float64(stats.DuplicateGroups)},
+        {"dedupe_reclaimable_bytes", "gauge", "Bytes that deleting the duplicates would free.", float64(stats.BytesReclaimable)},
+        {"dedupe_duration_seconds", "gauge", "Wall-clock duration of the run.", stats.DurationSeconds},
+        {"dedupe_errors_total", "counter", "Errors reported during the run.", float64(errorCount.Load())},
+    }
+    for _, m := range metrics {
+        fmt.Fprintf(file, "# HELP %s %s\n# TYPE %s %s\n%s %g\n", m.name, m.help, m.name, m.kind, m.name, m.value)
+    }
+
+    if err := file.Close(); err != nil {
+        return err
+    }
+    if err := os.Chmod(file.Name(), 0644); err != nil {
+        return err
+    }
+    return renameInto(file.Name(), filename)
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+    "fmt"
+    "path/filepath"
+    "runtime"
+    "strings"
+    "unicode"
+    "unicode/utf8"
+
+    "golang.org/x/text/unicode/norm"
+)
+
+// maxNameBytes is the longest file name most filesystems accept, in bytes.
+// Multibyte UTF-8 names, common in downloaded packs, reach it well before
+// 255 characters.
+const maxNameBytes = 255
+
+// maxPathBytes is the longest destination path copyFile will attempt. Go
+// already lifts the Windows MAX_PATH limit, so this mirrors Linux PATH_MAX.
+const maxPathBytes = 4096
+
+// windowsReserved are the characters Windows does not allow in file names.
+const windowsReserved = `<>:"\|?*`
+
+// matchName returns name as filenames are compared for grouping: in NFC form
+// with -normalize-unicode, otherwise as is.
+func matchName(name string) string {
+    if normalizeUnicode {
+        return norm.NFC.String(name)
+    }
+    return name
+}
+
+// sanitizeName makes name safe to create in a target directory: invalid
+// UTF-8, control characters and path separators are replaced with "_", as
+// are the characters Windows reserves when running there.
+func sanitizeName(name string) string {
+    name = strings.ToValidUTF8(name, "_")
+    name = strings.Map(func(r rune) rune {
+        if r == '/' || unicode.IsControl(r) {
+            return '_'
+        }
+        if runtime.GOOS == "windows" && strings.ContainsRune(windowsReserved, r) {
+            return '_'
+        }
+        return r
+    }, name)
+    if runtime.GOOS == "windows" {
+        name = strings.TrimRight(name, ". ")
+    }
+    if name == "" || name == "." || name == ".." {
+        name = "_"
+    }
+    return name
+}
+
+// fitName applies render to filename and, if the result is longer than
+// maxNameBytes, shortens the part before the extension until it fits. The
+// extension, and anything render adds such as a "(1)" suffix, is kept intact.
+func fitName(filename string, render func(string) string) string {
+    name := render(filename)
+    excess := len(name) - maxNameBytes
+    if excess <= 0 {
+        return name
+    }
+
+    ext := filepath.Ext(filename)
+    if len(ext) > 16 {
+        ext = "" // not a real extension
+    }
+    stem := strings.TrimSuffix(filename, ext)
+    keep := len(stem) - excess
+    if keep < 1 {
+        keep = 1
+    }
+    return render(truncateUTF8(stem, keep) + ext)
+}
+
+// truncateUTF8 returns the longest prefix of s of at most n bytes that does
+// not split a multibyte character.
+func truncateUTF8(s string, n int) string {
+    if len(s) <= n {
+        return s
+    }
+    for n > 0 && !utf8.RuneStart(s[n]) {
+        n--
+    }
+    return s[:n]
+}
+
+// checkPathLength reports an error for destination paths too long to create.
+func checkPathLength(path string) error {
+    if len(path) > maxPathBytes {
+        return fmt.Errorf("destination path is %d bytes, over the %d byte limit", len(path), maxPathBytes)
+    }
+    return nil
+}
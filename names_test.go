@@ -0,0 +1,64 @@
+package main
+
+import (
+    "os"
+    "path/filepath"
+    "strings"
+    "testing"
+    "unicode"
+    "unicode/utf8"
+)
+
+// pathologicalNames are file names that are legal on Linux but easy to
+// mishandle when building destination paths or shell commands.
+var pathologicalNames = []string{
+    "line\nbreak.wav",
+    "line_break.wav",
+    "-rf.wav",
+    "--help",
+    `it's "quoted".wav`,
+    "$(touch pwned).wav",
+    "back\\slash.wav",
+    "bad \xff\xfe utf8.wav",
+    "🎵 emoji 🎶.wav",
+    strings.Repeat("ü", 200) + ".flac",
+    strings.Repeat("x", 300),
+}
+
+func TestDestinationPathPathologicalNames(t *testing.T) {
+    setFlag(t, &renameTemplate, "{name}({n}){ext}")
+    dir := t.TempDir()
+
+    for _, name := range pathologicalNames {
+        fileInfo := &FileInfo{Name: name, Hash: "abc"}
+        for _, takenOnce := range []bool{false, true} {
+            first := takenOnce
+            destPath, err := destinationPath(dir, fileInfo, func(string) bool {
+                taken := first
+                first = false
+                return taken
+            })
+            if err != nil {
+                t.Errorf("destinationPath(%q): %v", name, err)
+                continue
+            }
+
+            base := filepath.Base(destPath)
+            if filepath.Dir(destPath) != dir {
+                t.Errorf("%q placed outside the target directory: %q", name, destPath)
+            }
+            if !utf8.ValidString(base) || len(base) > maxNameBytes || strings.IndexFunc(base, unicode.IsControl) >= 0 {
+                t.Errorf("%q gives unsafe name %q (%d bytes)", name, base, len(base))
+            }
+            if ext := filepath.Ext(name); len(ext) <= 16 && utf8.ValidString(ext) && !strings.HasSuffix(base, ext) {
+                t.Errorf("%q lost its extension: %q", name, base)
+            }
+            if takenOnce && !strings.Contains(base, "(1)") {
+                t.Errorf("%q renamed to %q, want a (1) suffix", name, base)
+            }
+            if err := os.WriteFile(destPath, nil, 0644); err != nil {
+                t.Errorf("cannot create %q: %v", destPath, err)
+            }
+        }
+    }
+}
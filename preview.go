@@ -0,0 +1,117 @@
+package main
+
+import (
+    "fmt"
+    "os"
+    "path/filepath"
+    "sort"
+    "strings"
+)
+
+// previewDir is one directory of the -preview-tree output.
+type previewDir struct {
+    name  string
+    dirs  map[string]*previewDir
+    files []previewFile
+    count int
+    size  int64
+}
+
+type previewFile struct {
+    name string
+    size int64
+}
+
+func newPreviewDir(name string) *previewDir {
+    return &previewDir{name: name, dirs: make(map[string]*previewDir)}
+}
+
+// add records a file at rel, a slash-separated path below d, and updates the
+// totals of every directory on the way down.
+func (d *previewDir) add(rel string, size int64) {
+    d.count++
+    d.size += size
+
+    dir, rest, found := strings.Cut(rel, "/")
+    if !found {
+        d.files = append(d.files, previewFile{name: rel, size: size})
+        return
+    }
+    child, ok := d.dirs[dir]
+    if !ok {
+        child = newPreviewDir(dir)
+        d.dirs[dir] = child
+    }
+    child.add(rest, size)
+}
+
+func (d *previewDir) print(indent string) {
+    fmt.Fprintf(infoOut, "%s%s/ (%d files, %s)\n", indent, d.name, d.count, formatSize(d.size))
+
+    names := make([]string, 0, len(d.dirs))
+    for name := range d.dirs {
+        names = append(names, name)
+    }
+    sort.Strings(names)
+    for _, name := range names {
+        d.dirs[name].print(indent + "  ")
+    }
+
+    sort.Slice(d.files, func(i, j int) bool { return d.files[i].name < d.files[j].name })
+    for _, file := range d.files {
+        fmt.Fprintf(infoOut, "%s  %s (%s)\n", indent, file.name, formatSize(file.size))
+    }
+}
+
+// printPreviewTree prints the layout copying output to -t would create,
+// including the names collisions would be renamed to, without touching the
+// target directory.
+func printPreviewTree(output []*FileInfo) {
+    root := newPreviewDir(targetDir)
+    planned := make(map[string]bool)
+    taken := func(path string) bool {
+        if planned[path] {
+            return true
+        }
+        _, err := os.Stat(path)
+        return err == nil
+    }
+
+    for _, group := range output {
+        for _, fileInfo := range filesToCopy(group) {
+            if existing, ok := presentInTarget(fileInfo); ok {
+                fmt.Fprintf(infoOut, "Already present: %s (as %s)\n", fileInfo.Path, existing)
+                continue
+            }
+            destPath, err := destinationPath(copyDestDir(fileInfo), fileInfo, taken)
+            if err != nil {
+                printError("Unable to place file %s: %v\n", fileInfo.Path, err)
+                continue
+            }
+            planned[destPath] = true
+
+            rel, err := filepath.Rel(targetDir, destPath)
+            if err != nil {
+                rel = filepath.Base(destPath)
+            }
+            root.add(filepath.ToSlash(rel), fileInfo.Size)
+        }
+    }
+
+    fmt.Fprintln(infoOut, "Files that would be copied:")
+    root.print("  ")
+}
+
+// formatSize renders a byte count in binary units.
+func formatSize(n int64) string {
+    const unit = 1024
+    if n < unit {
+        return fmt.Sprintf("%d B", n)
+    }
+    div, exp := int64(unit), 0
+    for m := n / unit; m >= unit; m /= unit {
+        div *= unit
+        exp++
+    }
+    return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+    "fmt"
+    "os"
+    "path/filepath"
+    "sort"
+    "strings"
+    "sync"
+    "sync/atomic"
+    "time"
+)
+
+// hashChunkSize is how much of a file is hashed between progress updates
+// and checkpointed digest states. Files no larger than this are hashed in a
+// single chunk and are not shown individually.
+const hashChunkSize = 64 * 1024 * 1024
+
+// fileProgress tracks how far hashing of one large file has got.
+type fileProgress struct {
+    name  string
+    size  int64
+    start time.Time
+    done  atomic.Int64
+}
+
+var (
+    activeFiles = make(map[*fileProgress]struct{})
+    activeMutex sync.Mutex
+)
+
+// trackFile registers a file for the progress line if it spans more than one
+// chunk. It returns nil otherwise, which the other methods accept.
+func trackFile(path string, size, offset int64) *fileProgress {
+    if size <= hashChunkSize {
+        return nil
+    }
+    p := &fileProgress{name: filepath.Base(path), size: size, start: time.Now()}
+    p.done.Store(offset)
+
+    activeMutex.Lock()
+    activeFiles[p] = struct{}{}
+    activeMutex.Unlock()
+    return p
+}
+
+func (p *fileProgress) add(n int64) {
+    if p != nil {
+        p.done.Add(n)
+    }
+}
+
+func (p *fileProgress) finish() {
+    if p == nil {
+        return
+    }
+    activeMutex.Lock()
+    delete(activeFiles, p)
+    activeMutex.Unlock()
+}
+
+// startProgress prints a progress line to stderr every second until the
+// returned function is first called: files hashed, bytes read, and how far each
+// large file in flight has got.
+func startProgress() func() {
+    if !showProgress {
+        return func() {}
+    }
+
+    done := make(chan struct{})
+    stopped := make(chan struct{})
+    go func() {
+        defer close(stopped)
+        ticker := time.NewTicker(time.Second)
+        defer ticker.Stop()
+        for {
+            select {
+            case <-ticker.C:
+                fmt.Fprintf(os.Stderr, "\r\033[K%s", progressLine())
+            case <-done:
+                fmt.Fprint(os.Stderr, "\r\033[K")
+                return
+            }
+        }
+    }()
+    var once sync.Once
+    return func() {
+        once.Do(func() {
+            close(done)
+            <-stopped
+        })
+    }
+}
+
+func progressLine() string {
+    line := fmt.Sprintf("Hashed %d files, %s read", filesScanned.Load(), formatSize(bytesRead.Load()))
+
+    activeMutex.Lock()
+    files := make([]*fileProgress, 0, len(activeFiles))
+    for p := range activeFiles {
+        files = append(files, p)
+    }
+    activeMutex.Unlock()
+    if len(files) == 0 {
+        return line
+    }
+
+    sort.Slice(files, func(i, j int) bool { return files[i].start.Before(files[j].start) })
+    parts := make([]string, len(files))
+    for i, p := range files {
+        parts[i] = fmt.Sprintf("%s %d%%", p.name, p.done.Load()*100/p.size)
+    }
+    return line + "; " + strings.Join(parts, ", ")
+}
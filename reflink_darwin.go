@@ -0,0 +1,10 @@
+//go:build darwin
+
+package main
+
+import "golang.org/x/sys/unix"
+
+// reflinkFile creates dst as an APFS clone of src.
+func reflinkFile(src, dst string) error {
+    return unix.Clonefile(src, dst, unix.CLONE_NOFOLLOW)
+}
@@ -0,0 +1,11 @@
+//go:build !linux && !darwin
+
+package main
+
+import "errors"
+
+// reflinkFile is not supported on this platform; copyFile falls back to a
+// regular copy.
+func reflinkFile(src, dst string) error {
+    return errors.ErrUnsupported
+}
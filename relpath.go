@@ -0,0 +1,77 @@
+package main
+
+import (
+    "iter"
+    "path/filepath"
+)
+
+// relativePath returns path relative to -relative-to, with forward slashes
+// so the results read the same on every host. A path outside -relative-to,
+// which -keep-absolute-outside allows, is returned absolute.
+func relativePath(path string) string {
+    if relativeTo == "" {
+        return path
+    }
+    abs, err := filepath.Abs(path)
+    if err != nil {
+        return path
+    }
+    base, err := filepath.Abs(relativeTo)
+    if err != nil || (abs != base && !insideRoot(abs, []string{base})) {
+        return abs
+    }
+    rel, err := filepath.Rel(base, abs)
+    if err != nil {
+        return abs
+    }
+    return filepath.ToSlash(rel)
+}
+
+// relativeFile returns a copy of fileInfo and its duplicates with every path
+// made relative by relativePath. The original is left as is, since copying
+// and deleting still need the real paths.
+func relativeFile(fileInfo *FileInfo) *FileInfo {
+    rel := *fileInfo
+    rel.Path = relativePath(fileInfo.Path)
+    rel.SourceRoot = relativePath(fileInfo.SourceRoot)
+    rel.Aliases = nil
+    for _, alias := range fileInfo.Aliases {
+        rel.Aliases = append(rel.Aliases, relativePath(alias))
+    }
+    rel.Children = nil
+    for _, child := range fileInfo.Children {
+        rel.Children = append(rel.Children, relativeFile(child))
+    }
+    return &rel
+}
+
+// relativeGroups applies relativeFile to each group in seq as it is read.
+func relativeGroups(seq iter.Seq[*FileInfo]) iter.Seq[*FileInfo] {
+    if relativeTo == "" {
+        return seq
+    }
+    return func(yield func(*FileInfo) bool) {
+        for fileInfo := range seq {
+            if !yield(relativeFile(fileInfo)) {
+                return
+            }
+        }
+    }
+}
+
+// rootsOutside returns the directories in roots that are not inside
+// -relative-to, whose files could not be given relative paths.
+func rootsOutside(roots []string) []string {
+    base, err := filepath.Abs(relativeTo)
+    if err != nil {
+        return roots
+    }
+    var outside []string
+    for _, root := range roots {
+        abs, err := filepath.Abs(root)
+        if err != nil || (abs != base && !insideRoot(abs, []string{base})) {
+            outside = append(outside, root)
+        }
+    }
+    return outside
+}
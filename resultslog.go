@@ -0,0 +1,65 @@
+package main
+
+import (
+    "encoding/json"
+    "os"
+    "sync"
+    "time"
+)
+
+// groupRecord is one line of the -results-append file: a duplicate group as
+// it stood when a file joined it. A group grows as the scan goes on but keeps
+// the file it was found with first, so the last line with a given first file
+// holds the most files.
+type groupRecord struct {
+    Time      time.Time `json:"time"`
+    Hash      string    `json:"hash"`
+    Size      int64     `json:"size"`
+    Namespace string    `json:"namespace,omitempty"`
+    Files     []string  `json:"files"`
+}
+
+// resultsLog appends groupRecords to a file, one write per line, so every
+// line that made it to disk is complete even if the process dies.
+type resultsLog struct {
+    mutex sync.Mutex
+    file  *os.File
+}
+
+// groupLog is set when -results-append is given.
+var groupLog *resultsLog
+
+func openResultsLog(path string) (*resultsLog, error) {
+    file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+    if err != nil {
+        return nil, err
+    }
+    return &resultsLog{file: file}, nil
+}
+
+// append writes group's current files as one line.
+func (l *resultsLog) append(group *FileInfo) error {
+    record := groupRecord{
+        Time:      time.Now().UTC(),
+        Hash:      group.Hash,
+        Size:      group.Size,
+        Namespace: group.Namespace,
+        Files:     []string{group.Path},
+    }
+    for _, child := range group.Children {
+        record.Files = append(record.Files, child.Path)
+    }
+    line, err := json.Marshal(record)
+    if err != nil {
+        return err
+    }
+
+    l.mutex.Lock()
+    defer l.mutex.Unlock()
+    _, err = l.file.Write(append(line, '\n'))
+    return err
+}
+
+func (l *resultsLog) close() error {
+    return l.file.Close()
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+    "context"
+    "errors"
+    "net"
+    "os"
+    "syscall"
+    "time"
+)
+
+const (
+    retryBaseDelay = 100 * time.Millisecond
+    retryMaxDelay  = 10 * time.Second
+)
+
+// withRetry calls fn, and up to -retries more times while it fails with a
+// transient error, waiting twice as long before each attempt. It gives up
+// early if ctx is done.
+func withRetry(ctx context.Context, path string, fn func() error) error {
+    delay := retryBaseDelay
+    for attempt := 0; ; attempt++ {
+        err := fn()
+        if err == nil || attempt >= retries || !isTransient(err) {
+            return err
+        }
+
+        log("Retrying %s in %v after: %v", path, delay, err)
+        select {
+        case <-ctx.Done():
+            return err
+        case <-time.After(delay):
+        }
+        delay = min(delay*2, retryMaxDelay)
+    }
+}
+
+// isTransient reports whether err is one that may well not happen again,
+// such as a timeout, a busy device or an interrupted call on a network mount.
+// Errors like a missing file, a denied permission or EIO from a failing disk
+// are permanent and not retried, and neither is -file-timeout or
+// -max-duration running out.
+func isTransient(err error) bool {
+    if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+        return false
+    }
+    if errors.Is(err, os.ErrNotExist) || errors.Is(err, os.ErrPermission) {
+        return false
+    }
+
+    var netErr net.Error
+    if errors.As(err, &netErr) && netErr.Timeout() {
+        return true
+    }
+    return errors.Is(err, os.ErrDeadlineExceeded) ||
+        errors.Is(err, syscall.EAGAIN) ||
+        errors.Is(err, syscall.EINTR) ||
+        errors.Is(err, syscall.EBUSY) ||
+        errors.Is(err, syscall.ETIMEDOUT) ||
+        errors.Is(err, syscall.ECONNRESET) ||
+        errors.Is(err, syscall.ESTALE)
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "io/fs"
+    "os"
+    "syscall"
+    "testing"
+)
+
+func TestIsTransient(t *testing.T) {
+    tests := []struct {
+        err  error
+        want bool
+    }{
+        {syscall.EAGAIN, true},
+        {syscall.EINTR, true},
+        {syscall.EBUSY, true},
+        {syscall.ETIMEDOUT, true},
+        {&fs.PathError{Op: "read", Path: "a.wav", Err: syscall.EINTR}, true},
+        {os.ErrDeadlineExceeded, true},
+        {syscall.EIO, false},
+        {&fs.PathError{Op: "read", Path: "a.wav", Err: syscall.EIO}, false},
+        {os.ErrNotExist, false},
+        {os.ErrPermission, false},
+        {context.DeadlineExceeded, false},
+        {fmt.Errorf("hashing: %w", context.Canceled), false},
+        {errors.New("bad header"), false},
+    }
+    for _, tt := range tests {
+        if got := isTransient(tt.err); got != tt.want {
+            t.Errorf("isTransient(%v) = %v, want %v", tt.err, got, tt.want)
+        }
+    }
+}
+
+func TestWithRetryStopsOnPermanentErrors(t *testing.T) {
+    setFlag(t, &retries, 3)
+    calls := 0
+    err := withRetry(context.Background(), "a.wav", func() error {
+        calls++
+        return syscall.EIO
+    })
+    if !errors.Is(err, syscall.EIO) || calls != 1 {
+        t.Errorf("withRetry returned %v after %d calls, want EIO after 1", err, calls)
+    }
+
+    calls = 0
+    err = withRetry(context.Background(), "a.wav", func() error {
+        calls++
+        if calls < 3 {
+            return syscall.EBUSY
+        }
+        return nil
+    })
+    if err != nil || calls != 3 {
+        t.Errorf("withRetry returned %v after %d calls, want success after 3", err, calls)
+    }
+}
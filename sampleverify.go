@@ -0,0 +1,56 @@
+package main
+
+import (
+    "context"
+    "fmt"
+    "math"
+    "math/rand/v2"
+    "os"
+    "slices"
+)
+
+// verifySample reads in full a random -sample-verify percent of the duplicate
+// groups that were matched on hashes taken from a sidecar or the checkpoint
+// rather than read from the files, and checks those hashes still hold. It
+// returns the number of false positives found.
+func verifySample(output []*FileInfo) int {
+    var candidates []*FileInfo
+    for _, group := range output {
+        if len(group.Children) == 0 {
+            continue
+        }
+        if group.hashSource != "" || slices.ContainsFunc(group.Children, func(child *FileInfo) bool { return child.hashSource != "" }) {
+            candidates = append(candidates, group)
+        }
+    }
+    if len(candidates) == 0 {
+        fmt.Fprintln(infoOut, "No matches based on sidecar or checkpoint hashes to verify")
+        return 0
+    }
+
+    total := len(candidates)
+    n := int(math.Ceil(float64(total) * sampleVerify / 100))
+    rand.Shuffle(total, func(i, j int) { candidates[i], candidates[j] = candidates[j], candidates[i] })
+    candidates = candidates[:n]
+
+    falsePositives := 0
+    for _, group := range candidates {
+        for _, member := range append([]*FileInfo{group}, group.Children...) {
+            if member.hashSource == "" {
+                continue
+            }
+            log("Verifying %s hash of %s", member.hashSource, member.Path)
+            hashes, err := fileHash(context.Background(), member.Path, nil)
+            if err != nil {
+                printError("Unable to verify %s: %v\n", member.Path, err)
+                continue
+            }
+            if hashes[hashNames()[0]] != member.Hash {
+                fmt.Fprintf(os.Stderr, "Warning: %s does not match its %s hash, so its group with %s is a false positive\n", member.Path, member.hashSource, group.Path)
+                falsePositives++
+            }
+        }
+    }
+    fmt.Fprintf(infoOut, "Verified %d of %d groups matched on sidecar or checkpoint hashes: %d false positives\n", n, total, falsePositives)
+    return falsePositives
+}
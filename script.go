@@ -0,0 +1,149 @@
+package main
+
+import (
+    "bufio"
+    "errors"
+    "fmt"
+    "os"
+    "runtime"
+    "strings"
+    "time"
+)
+
+// shellQuote quotes s for a POSIX shell. Everything inside single quotes is
+// literal, so only the single quote itself needs escaping: it ends the
+// quoted string, adds an escaped quote and starts a new one.
+func shellQuote(s string) string {
+    return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// writeScript writes to path a shell script that performs the copies to -t
+// and the deletions -delete-source-files would, in the same order, instead of
+// performing them.
+func writeScript(path string, output []*FileInfo) error {
+    flags := os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+    if !forceOverwrite {
+        flags = os.O_WRONLY | os.O_CREATE | os.O_EXCL
+    }
+    file, err := os.OpenFile(path, flags, 0755)
+    if err != nil {
+        if errors.Is(err, os.ErrExist) {
+            return fmt.Errorf("%s already exists (use -force to overwrite)", path)
+        }
+        return err
+    }
+
+    buf := bufio.NewWriter(file)
+    fmt.Fprintln(buf, "#!/bin/sh")
+    fmt.Fprintf(buf, "# Generated by dedupe-music %s at %s.\n", version, time.Now().UTC().Format(time.RFC3339))
+    fmt.Fprintln(buf, "# Review before running; it stops at the first command that fails.")
+    fmt.Fprintln(buf, "set -e")
+
+    if targetDir != "" {
+        writeCopyCommands(buf, output)
+    }
+    if deleteSourceFiles {
+        writeDeleteCommands(buf, output)
+    }
+
+    if err := buf.Flush(); err != nil {
+        file.Close()
+        return err
+    }
+    return file.Close()
+}
+
+// writeCopyCommands writes a cp for each file copyFiles would copy, to the
+// name it would be given.
+func writeCopyCommands(buf *bufio.Writer, output []*FileInfo) {
+    cp := "cp"
+    if preserveAttrs != "none" {
+        cp = "cp -p"
+    }
+    // macOS cp copies extended attributes already; GNU cp has to be asked.
+    if preserveXattr && runtime.GOOS == "linux" {
+        cp += " --preserve=xattr"
+    }
+    planned := make(map[string]bool)
+    taken := func(path string) bool {
+        if planned[path] {
+            return true
+        }
+        _, err := os.Stat(path)
+        return err == nil
+    }
+    madeDirs := make(map[string]bool)
+
+    fmt.Fprintf(buf, "\n# Copy to %s\n", targetDir)
+    for _, group := range output {
+        for _, fileInfo := range filesToCopy(group) {
+            if existing, ok := presentInTarget(fileInfo); ok {
+                fmt.Fprintf(buf, "# already present: %s (as %s)\n", commentLine(fileInfo.Path), commentLine(existing))
+                continue
+            }
+            if fileInfo.InArchive {
+                fmt.Fprintf(buf, "# inside an archive, extract by hand: %s\n", commentLine(fileInfo.Path))
+                continue
+            }
+            destDir := copyDestDir(fileInfo)
+            destPath, err := destinationPath(destDir, fileInfo, taken)
+            if err != nil {
+                printError("Unable to place file %s: %v\n", fileInfo.Path, err)
+                continue
+            }
+            planned[destPath] = true
+
+            if !madeDirs[destDir] {
+                fmt.Fprintf(buf, "mkdir -p -- %s\n", shellQuote(destDir))
+                madeDirs[destDir] = true
+            }
+            fmt.Fprintf(buf, "%s -- %s %s\n", cp, shellQuote(fileInfo.Path), shellQuote(destPath))
+            if owner := chownOwner(); owner != "" {
+                fmt.Fprintf(buf, "chown %s -- %s\n", owner, shellQuote(destPath))
+            }
+            if copyModeBits != "" {
+                fmt.Fprintf(buf, "chmod %04o -- %s\n", copyPerm, shellQuote(destPath))
+            }
+        }
+    }
+}
+
+// chownOwner returns the chown argument for -copy-uid and -copy-gid, or ""
+// when neither is given.
+func chownOwner() string {
+    switch {
+    case copyUID >= 0 && copyGID >= 0:
+        return fmt.Sprintf("%d:%d", copyUID, copyGID)
+    case copyUID >= 0:
+        return fmt.Sprint(copyUID)
+    case copyGID >= 0:
+        return fmt.Sprintf(":%d", copyGID)
+    }
+    return ""
+}
+
+// writeDeleteCommands writes an rm for each file deleteFiles would delete,
+// one group at a time.
+func writeDeleteCommands(buf *bufio.Writer, output []*FileInfo) {
+    fmt.Fprintln(buf, "\n# Delete from the source directories")
+    for _, group := range output {
+        files := filesToDelete(group)
+        if len(files) == 0 {
+            continue
+        }
+        if dedupeTarget {
+            fmt.Fprintf(buf, "# duplicates of %s\n", commentLine(group.Path))
+        } else {
+            fmt.Fprintf(buf, "# %s\n", commentLine(group.Path))
+        }
+        for _, fileInfo := range files {
+            fmt.Fprintf(buf, "rm -- %s\n", shellQuote(fileInfo.Path))
+        }
+    }
+}
+
+// commentLine makes s safe to put after a # by escaping line breaks, which
+// would otherwise end the comment and leave the rest to run as a command.
+func commentLine(s string) string {
+    return strings.NewReplacer("\\", `\\`, "\n", `\n`, "\r", `\r`).Replace(s)
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+    "fmt"
+    "os"
+    "os/exec"
+    "path/filepath"
+    "testing"
+)
+
+func TestScriptPathologicalNames(t *testing.T) {
+    if _, err := exec.LookPath("sh"); err != nil {
+        t.Skip("no sh to run the script with")
+    }
+    base := t.TempDir()
+    source, target := filepath.Join(base, "source"), filepath.Join(base, "target")
+    if err := os.Mkdir(source, 0755); err != nil {
+        t.Fatal(err)
+    }
+    setFlag(t, &targetDir, target)
+    setFlag(t, &deleteSourceFiles, true)
+    setFlag(t, &dedupeTarget, false)
+    setFlag(t, &preserveTree, false)
+    setFlag(t, &copyMode, "unique")
+    setFlag(t, &renameTemplate, "{name}({n}){ext}")
+
+    // Names over the filesystem limit can only come from archives, so they
+    // cannot be created here.
+    var names []string
+    for _, name := range pathologicalNames {
+        if len(name) <= maxNameBytes {
+            names = append(names, name)
+        }
+    }
+
+    var output []*FileInfo
+    for i, name := range names {
+        path := filepath.Join(source, name)
+        if err := os.WriteFile(path, []byte(name), 0644); err != nil {
+            t.Fatal(err)
+        }
+        output = append(output, &FileInfo{Name: name, Path: path, Hash: fmt.Sprint(i), SourceRoot: source})
+    }
+
+    script := filepath.Join(base, "dedupe.sh")
+    if err := writeScript(script, output); err != nil {
+        t.Fatal(err)
+    }
+    cmd := exec.Command("sh", script)
+    cmd.Dir = base
+    if out, err := cmd.CombinedOutput(); err != nil {
+        t.Fatalf("script failed: %v\n%s", err, out)
+    }
+
+    if _, err := os.Stat(filepath.Join(base, "pwned")); err == nil {
+        t.Error("a file name was run as a command")
+    }
+    if left, _ := os.ReadDir(source); len(left) != 0 {
+        t.Errorf("%d source files were not deleted", len(left))
+    }
+    copies, err := os.ReadDir(target)
+    if err != nil {
+        t.Fatal(err)
+    }
+    contents := make(map[string]bool)
+    for _, entry := range copies {
+        data, err := os.ReadFile(filepath.Join(target, entry.Name()))
+        if err != nil {
+            t.Fatal(err)
+        }
+        contents[string(data)] = true
+    }
+    for _, name := range names {
+        if !contents[name] {
+            t.Errorf("no copy of %q in the target directory", name)
+        }
+    }
+}
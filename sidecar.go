@@ -0,0 +1,103 @@
+package main
+
+import (
+    "bufio"
+    "encoding/hex"
+    "os"
+    "path/filepath"
+    "strings"
+    "time"
+)
+
+// sidecarDigestLen is the hex length of each digest that can be read from a
+// sidecar file, which is named after the algorithm (song.wav.md5, song.sha256).
+var sidecarDigestLen = map[string]int{
+    "md5":    32,
+    "sha1":   40,
+    "sha256": 64,
+    "sha512": 128,
+    "blake3": 64,
+}
+
+// sidecarHashes returns the requested digests of the file at path from
+// checksum files next to it. It succeeds only if every requested digest is
+// found in a sidecar that was modified after the file itself.
+func sidecarHashes(path string, modTime time.Time) (map[string]string, bool) {
+    hashes := make(map[string]string)
+    for _, name := range hashNames() {
+        digest, ok := readSidecar(path, name, modTime)
+        if !ok {
+            return nil, false
+        }
+        hashes[name] = digest
+    }
+    return hashes, true
+}
+
+// readSidecar looks for "<file>.<algo>" and then "<stem>.<algo>" beside path.
+func readSidecar(path, algo string, modTime time.Time) (string, bool) {
+    size, ok := sidecarDigestLen[algo]
+    if !ok {
+        return "", false
+    }
+
+    stem := strings.TrimSuffix(path, filepath.Ext(path))
+    for _, candidate := range []string{path + "." + algo, stem + "." + algo} {
+        info, err := os.Stat(candidate)
+        if err != nil || !info.ModTime().After(modTime) {
+            continue
+        }
+        if digest, ok := parseSidecar(candidate, filepath.Base(path), size); ok {
+            return digest, true
+        }
+    }
+    return "", false
+}
+
+// parseSidecar reads a checksum file in GNU ("<hex>  name" or "<hex> *name"),
+// BSD ("MD5 (name) = <hex>") or bare "<hex>" form. Only a line naming
+// filename, or a lone bare digest, is accepted: a song.md5 listing just
+// song.flac must not give its hash to song.wav.
+func parseSidecar(sidecar, filename string, size int) (string, bool) {
+    file, err := os.Open(sidecar)
+    if err != nil {
+        return "", false
+    }
+    defer file.Close()
+
+    var bare []string
+    scanner := bufio.NewScanner(file)
+    for scanner.Scan() {
+        line := strings.TrimSpace(scanner.Text())
+        if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, ";") {
+            continue
+        }
+
+        var digest, name string
+        if open := strings.Index(line, " ("); open > 0 && strings.Contains(line, ") = ") {
+            close := strings.LastIndex(line, ") = ")
+            name, digest = line[open+2:close], line[close+4:]
+        } else {
+            digest, name, _ = strings.Cut(line, " ")
+            name = strings.TrimPrefix(strings.TrimSpace(name), "*")
+        }
+        digest = strings.ToLower(strings.TrimSpace(digest))
+        if len(digest) != size {
+            continue
+        }
+        if _, err := hex.DecodeString(digest); err != nil {
+            continue
+        }
+
+        if name == "" {
+            bare = append(bare, digest)
+        } else if filepath.Base(filepath.FromSlash(name)) == filename {
+            return digest, true
+        }
+    }
+
+    if len(bare) == 1 {
+        return bare[0], true
+    }
+    return "", false
+}
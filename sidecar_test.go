@@ -0,0 +1,49 @@
+package main
+
+import (
+    "os"
+    "path/filepath"
+    "strings"
+    "testing"
+    "time"
+)
+
+func TestReadSidecar(t *testing.T) {
+    wavHash := strings.Repeat("a", 32)
+    flacHash := strings.Repeat("b", 32)
+
+    tests := []struct {
+        name    string
+        sidecar string
+        content string
+        want    string
+    }{
+        {"bare digest", "song.md5", wavHash + "\n", wavHash},
+        {"gnu names the file", "song.md5", wavHash + "  song.wav\n", wavHash},
+        {"gnu binary mode", "song.wav.md5", wavHash + " *song.wav\n", wavHash},
+        {"bsd names the file", "song.md5", "MD5 (song.wav) = " + wavHash + "\n", wavHash},
+        {"names the file among others", "song.md5", flacHash + "  song.flac\n" + wavHash + "  song.wav\n", wavHash},
+        {"stem sidecar names a sibling", "song.md5", flacHash + "  song.flac\n", ""},
+        {"bsd stem sidecar names a sibling", "song.md5", "MD5 (song.flac) = " + flacHash + "\n", ""},
+        {"several bare digests", "song.md5", wavHash + "\n" + flacHash + "\n", ""},
+        {"wrong length", "song.md5", wavHash[:31] + "\n", ""},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            dir := t.TempDir()
+            path := filepath.Join(dir, "song.wav")
+            if err := os.WriteFile(path, []byte("audio"), 0644); err != nil {
+                t.Fatal(err)
+            }
+            modTime := time.Now().Add(-time.Hour)
+            if err := os.WriteFile(filepath.Join(dir, tt.sidecar), []byte(tt.content), 0644); err != nil {
+                t.Fatal(err)
+            }
+
+            got, ok := readSidecar(path, "md5", modTime)
+            if got != tt.want || ok != (tt.want != "") {
+                t.Errorf("readSidecar = %q, %v; want %q", got, ok, tt.want)
+            }
+        })
+    }
+}
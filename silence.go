@@ -0,0 +1,296 @@
+package main
+
+import (
+    "encoding/binary"
+    "fmt"
+    "io"
+    "math"
+    "os"
+    "path/filepath"
+    "slices"
+    "strings"
+    "sync"
+)
+
+const (
+    // silenceWindows is how many windows spread across the sample data are
+    // read to decide whether a file is silent.
+    silenceWindows = 16
+    // silenceWindowBytes is the size of each window.
+    silenceWindowBytes = 64 * 1024
+)
+
+// silentFile is a WAV or AIFF file whose sampled audio never rises above
+// -silence-db. It is left out of the duplicate groups and listed under
+// "skipped_silent" in the results.
+type silentFile struct {
+    Path string  `json:"path"`
+    Size int64   `json:"size"`
+    Peak float64 `json:"peak_dbfs"`
+}
+
+var (
+    silentFiles []silentFile
+    silentMutex sync.Mutex
+)
+
+// pcmLayout describes where a file's samples are and how they are encoded.
+type pcmLayout struct {
+    offset    int64
+    length    int64
+    bits      int
+    float     bool
+    unsigned  bool // 8-bit WAV samples are unsigned
+    bigEndian bool
+}
+
+// checkSilent records path and reports true if it is a WAV or AIFF file whose
+// sampled peak is at or below -silence-db. Files that cannot be parsed, or use
+// an encoding other than plain PCM or float, are never treated as silent.
+func checkSilent(path string, size int64) bool {
+    ext := strings.ToLower(filepath.Ext(path))
+    if ext != ".wav" && ext != ".aif" && ext != ".aiff" {
+        return false
+    }
+
+    peak, err := samplePeak(path)
+    if err != nil {
+        log("Not checking %s for silence: %v", path, err)
+        return false
+    }
+    if peak > math.Pow(10, silenceDB/20) {
+        return false
+    }
+
+    db := math.Inf(-1)
+    if peak > 0 {
+        db = math.Round(20*math.Log10(peak)*10) / 10
+    }
+    log("Skipping silent file %s (peak %.1f dBFS)", path, db)
+    if math.IsInf(db, -1) {
+        // JSON has no -Inf; report true digital silence as the lowest a
+        // 32-bit sample can go.
+        db = -193
+    }
+    silentMutex.Lock()
+    silentFiles = append(silentFiles, silentFile{Path: path, Size: size, Peak: db})
+    silentMutex.Unlock()
+    return true
+}
+
+// sortedSilent returns the silent files ordered by path.
+func sortedSilent() []silentFile {
+    silentMutex.Lock()
+    defer silentMutex.Unlock()
+    files := slices.Clone(silentFiles)
+    slices.SortFunc(files, func(a, b silentFile) int { return strings.Compare(a.Path, b.Path) })
+    return files
+}
+
+// samplePeak returns the largest sample in windows spread across the file's
+// audio, as a fraction of full scale.
+func samplePeak(path string) (float64, error) {
+    file, err := os.Open(path)
+    if err != nil {
+        return 0, err
+    }
+    defer file.Close()
+
+    head := make([]byte, audioHeaderBytes)
+    n, err := io.ReadFull(file, head)
+    if err != nil && err != io.ErrUnexpectedEOF {
+        return 0, err
+    }
+    head = head[:n]
+
+    var layout pcmLayout
+    if strings.ToLower(filepath.Ext(path)) == ".wav" {
+        layout, err = wavLayout(head)
+    } else {
+        layout, err = aiffLayout(head)
+    }
+    if err != nil {
+        return 0, err
+    }
+    if layout.length <= 0 {
+        return 0, fmt.Errorf("no sample data")
+    }
+
+    sampleBytes := int64(layout.bits / 8)
+    window := min(int64(silenceWindowBytes), layout.length) / sampleBytes * sampleBytes
+    if window == 0 {
+        return 0, fmt.Errorf("no complete sample")
+    }
+    step := int64(0)
+    if silenceWindows > 1 {
+        step = (layout.length - window) / (silenceWindows - 1) / sampleBytes * sampleBytes
+    }
+
+    buf := make([]byte, window)
+    peak := 0.0
+    for i := range int64(silenceWindows) {
+        n, err := file.ReadAt(buf, layout.offset+i*step)
+        if err != nil && err != io.EOF {
+            return 0, err
+        }
+        peak = max(peak, layout.peak(buf[:n]))
+        if step == 0 {
+            break
+        }
+    }
+    return peak, nil
+}
+
+// peak returns the largest absolute sample in data as a fraction of full
+// scale.
+func (l pcmLayout) peak(data []byte) float64 {
+    var order binary.ByteOrder = binary.LittleEndian
+    if l.bigEndian {
+        order = binary.BigEndian
+    }
+
+    peak := 0.0
+    size := l.bits / 8
+    for pos := 0; pos+size <= len(data); pos += size {
+        b := data[pos : pos+size]
+        var v float64
+        switch {
+        case l.float && l.bits == 32:
+            v = float64(math.Float32frombits(order.Uint32(b)))
+        case l.float && l.bits == 64:
+            v = math.Float64frombits(order.Uint64(b))
+        case l.bits == 8 && l.unsigned:
+            v = (float64(b[0]) - 128) / 128
+        case l.bits == 8:
+            v = float64(int8(b[0])) / 128
+        case l.bits == 16:
+            v = float64(int16(order.Uint16(b))) / (1 << 15)
+        case l.bits == 24:
+            var u uint32
+            if l.bigEndian {
+                u = uint32(b[0])<<16 | uint32(b[1])<<8 | uint32(b[2])
+            } else {
+                u = uint32(b[2])<<16 | uint32(b[1])<<8 | uint32(b[0])
+            }
+            v = float64(int32(u<<8)>>8) / (1 << 23)
+        case l.bits == 32:
+            v = float64(int32(order.Uint32(b))) / (1 << 31)
+        }
+        if math.IsNaN(v) {
+            // A NaN is not silence.
+            return math.Inf(1)
+        }
+        peak = max(peak, math.Abs(v))
+    }
+    return peak
+}
+
+// supported reports whether the sample size is one peak can decode.
+func (l pcmLayout) supported() bool {
+    if l.float {
+        return l.bits == 32 || l.bits == 64
+    }
+    return l.bits == 8 || l.bits == 16 || l.bits == 24 || l.bits == 32
+}
+
+func wavLayout(data []byte) (pcmLayout, error) {
+    if len(data) < 12 || string(data[:4]) != "RIFF" || string(data[8:12]) != "WAVE" {
+        return pcmLayout{}, fmt.Errorf("not a WAV file")
+    }
+
+    var layout pcmLayout
+    for pos := 12; pos+8 <= len(data); {
+        id := string(data[pos : pos+4])
+        size := int(binary.LittleEndian.Uint32(data[pos+4 : pos+8]))
+        body := pos + 8
+        switch id {
+        case "fmt ":
+            if body+16 > len(data) {
+                return pcmLayout{}, fmt.Errorf("truncated fmt chunk")
+            }
+            format := binary.LittleEndian.Uint16(data[body:])
+            // WAVE_FORMAT_EXTENSIBLE keeps the real format at the start of
+            // its subformat GUID.
+            if format == 0xfffe && size >= 26 && body+26 <= len(data) {
+                format = binary.LittleEndian.Uint16(data[body+24:])
+            }
+            layout.bits = int(binary.LittleEndian.Uint16(data[body+14:]))
+            switch format {
+            case 1:
+                layout.unsigned = layout.bits == 8
+            case 3:
+                layout.float = true
+            default:
+                return pcmLayout{}, fmt.Errorf("unsupported WAV format %#x", format)
+            }
+            if !layout.supported() {
+                return pcmLayout{}, fmt.Errorf("unsupported sample size %d", layout.bits)
+            }
+        case "data":
+            if layout.bits == 0 {
+                return pcmLayout{}, fmt.Errorf("data chunk before fmt chunk")
+            }
+            layout.offset = int64(body)
+            layout.length = int64(size)
+            return layout, nil
+        }
+        pos = body + size + size%2
+    }
+    return pcmLayout{}, fmt.Errorf("no data chunk in the first %d bytes", audioHeaderBytes)
+}
+
+func aiffLayout(data []byte) (pcmLayout, error) {
+    if len(data) < 12 || string(data[:4]) != "FORM" || (string(data[8:12]) != "AIFF" && string(data[8:12]) != "AIFC") {
+        return pcmLayout{}, fmt.Errorf("not an AIFF file")
+    }
+    aifc := string(data[8:12]) == "AIFC"
+
+    layout := pcmLayout{bigEndian: true}
+    for pos := 12; pos+8 <= len(data); {
+        id := string(data[pos : pos+4])
+        size := int(binary.BigEndian.Uint32(data[pos+4 : pos+8]))
+        body := pos + 8
+        switch id {
+        case "COMM":
+            if body+18 > len(data) {
+                return pcmLayout{}, fmt.Errorf("truncated COMM chunk")
+            }
+            layout.bits = int(binary.BigEndian.Uint16(data[body+6:]))
+            if aifc {
+                if body+22 > len(data) {
+                    return pcmLayout{}, fmt.Errorf("truncated COMM chunk")
+                }
+                switch compression := string(data[body+18 : body+22]); compression {
+                case "NONE", "twos":
+                case "sowt":
+                    layout.bigEndian = false
+                case "fl32", "FL32":
+                    layout.float, layout.bits = true, 32
+                case "fl64", "FL64":
+                    layout.float, layout.bits = true, 64
+                default:
+                    return pcmLayout{}, fmt.Errorf("unsupported AIFF-C compression %q", compression)
+                }
+            }
+            // Sample sizes that are not a whole number of bytes are stored
+            // padded to the next byte.
+            layout.bits = (layout.bits + 7) / 8 * 8
+            if !layout.supported() {
+                return pcmLayout{}, fmt.Errorf("unsupported sample size %d", layout.bits)
+            }
+        case "SSND":
+            if layout.bits == 0 {
+                return pcmLayout{}, fmt.Errorf("SSND chunk before COMM chunk")
+            }
+            if body+8 > len(data) {
+                return pcmLayout{}, fmt.Errorf("truncated SSND chunk")
+            }
+            skip := int64(binary.BigEndian.Uint32(data[body:]))
+            layout.offset = int64(body) + 8 + skip
+            layout.length = int64(size) - 8 - skip
+            return layout, nil
+        }
+        pos = body + size + size%2
+    }
+    return pcmLayout{}, fmt.Errorf("no SSND chunk in the first %d bytes", audioHeaderBytes)
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+    "cmp"
+    "container/heap"
+    "slices"
+    "sync"
+    "time"
+)
+
+// slowestCount is how many of the slowest files to hash are reported.
+const slowestCount = 10
+
+// slowFile is one of the slowest files to hash in the run.
+type slowFile struct {
+    Path      string  `json:"path"`
+    Seconds   float64 `json:"seconds"`
+    MiBPerSec float64 `json:"mib_per_sec"`
+    duration  time.Duration
+    size      int64
+}
+
+// slowHeap is a min-heap on duration, so the fastest of the files kept is
+// the one dropped when a slower file comes along.
+type slowHeap []slowFile
+
+func (h slowHeap) Len() int           { return len(h) }
+func (h slowHeap) Less(i, j int) bool { return h[i].duration < h[j].duration }
+func (h slowHeap) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+func (h *slowHeap) Push(x any)        { *h = append(*h, x.(slowFile)) }
+func (h *slowHeap) Pop() any {
+    old := *h
+    x := old[len(old)-1]
+    *h = old[:len(old)-1]
+    return x
+}
+
+var (
+    slowFiles slowHeap
+    slowMutex sync.Mutex
+)
+
+// recordHashTime keeps path if it is among the slowest files hashed so far.
+func recordHashTime(path string, size int64, d time.Duration) {
+    slowMutex.Lock()
+    defer slowMutex.Unlock()
+    if len(slowFiles) == slowestCount {
+        if d <= slowFiles[0].duration {
+            return
+        }
+        heap.Pop(&slowFiles)
+    }
+    heap.Push(&slowFiles, slowFile{Path: path, duration: d, size: size})
+}
+
+// slowest returns the slowest files to hash, slowest first.
+func slowest() []slowFile {
+    slowMutex.Lock()
+    files := slices.Clone(slowFiles)
+    slowMutex.Unlock()
+
+    slices.SortFunc(files, func(a, b slowFile) int { return cmp.Compare(b.duration, a.duration) })
+    for i := range files {
+        files[i].Seconds = files[i].duration.Seconds()
+        if files[i].duration > 0 {
+            files[i].MiBPerSec = float64(files[i].size) / (1 << 20) / files[i].duration.Seconds()
+        }
+    }
+    return files
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+    "database/sql"
+    "fmt"
+    "os"
+    "sort"
+
+    _ "modernc.org/sqlite"
+)
+
+const sqliteSchema = `CREATE TABLE files (
+    id          INTEGER PRIMARY KEY,
+    name        TEXT    NOT NULL,
+    path        TEXT    NOT NULL,
+    hash        TEXT    NOT NULL,
+    size        INTEGER NOT NULL,
+    source_root TEXT    NOT NULL,
+    reference   INTEGER NOT NULL,
+    in_archive  INTEGER NOT NULL,
+    canonical   INTEGER NOT NULL
+);
+CREATE TABLE duplicates (
+    canonical_id INTEGER NOT NULL REFERENCES files(id),
+    duplicate_id INTEGER NOT NULL REFERENCES files(id)
+);
+CREATE TABLE hashes (
+    file_id   INTEGER NOT NULL REFERENCES files(id),
+    algorithm TEXT    NOT NULL,
+    digest    TEXT    NOT NULL
+);
+CREATE INDEX files_hash ON files(hash);
+CREATE INDEX files_size ON files(size);
+CREATE INDEX duplicates_canonical ON duplicates(canonical_id);
+`
+
+// writeSQLite writes output to a new SQLite database at path, using a
+// pure-Go driver so no sqlite3 binary or cgo toolchain is needed.
+func writeSQLite(path string, output []*FileInfo) error {
+    if _, err := os.Stat(path); err == nil {
+        if !forceOverwrite {
+            return fmt.Errorf("%s already exists (use -force to overwrite)", path)
+        }
+        if err := os.Remove(path); err != nil {
+            return err
+        }
+    }
+
+    db, err := sql.Open("sqlite", path)
+    if err != nil {
+        return err
+    }
+    if err := writeSQL(db, output); err != nil {
+        db.Close()
+        return err
+    }
+    return db.Close()
+}
+
+// writeSQL creates the schema and inserts every file in a single transaction.
+func writeSQL(db *sql.DB, output []*FileInfo) error {
+    if _, err := db.Exec(sqliteSchema); err != nil {
+        return err
+    }
+    tx, err := db.Begin()
+    if err != nil {
+        return err
+    }
+    defer tx.Rollback()
+
+    insertFile, err := tx.Prepare("INSERT INTO files VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)")
+    if err != nil {
+        return err
+    }
+    insertHash, err := tx.Prepare("INSERT INTO hashes VALUES (?, ?, ?)")
+    if err != nil {
+        return err
+    }
+    insertDuplicate, err := tx.Prepare("INSERT INTO duplicates VALUES (?, ?)")
+    if err != nil {
+        return err
+    }
+
+    id := 0
+    insert := func(fileInfo *FileInfo, canonical bool) (int, error) {
+        id++
+        _, err := insertFile.Exec(id, fileInfo.Name, fileInfo.Path, fileInfo.Hash, fileInfo.Size,
+            fileInfo.SourceRoot, fileInfo.Reference, fileInfo.InArchive, canonical)
+        if err != nil {
+            return 0, err
+        }
+
+        algorithms := make([]string, 0, len(fileInfo.Hashes))
+        for algorithm := range fileInfo.Hashes {
+            algorithms = append(algorithms, algorithm)
+        }
+        sort.Strings(algorithms)
+        for _, algorithm := range algorithms {
+            if _, err := insertHash.Exec(id, algorithm, fileInfo.Hashes[algorithm]); err != nil {
+                return 0, err
+            }
+        }
+        return id, nil
+    }
+
+    for _, fileInfo := range output {
+        canonicalID, err := insert(fileInfo, true)
+        if err != nil {
+            return err
+        }
+        for _, child := range fileInfo.Children {
+            childID, err := insert(child, false)
+            if err != nil {
+                return err
+            }
+            if _, err := insertDuplicate.Exec(canonicalID, childID); err != nil {
+                return err
+            }
+        }
+    }
+    return tx.Commit()
+}
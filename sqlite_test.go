@@ -0,0 +1,61 @@
+package main
+
+import (
+    "database/sql"
+    "path/filepath"
+    "testing"
+)
+
+func TestWriteSQLite(t *testing.T) {
+    path := filepath.Join(t.TempDir(), "results.db")
+    output := []*FileInfo{{
+        Name: "it's.wav", Path: "/music/it's.wav", Hash: "h1", Size: 300 << 20, SourceRoot: "/music",
+        Hashes: map[string]string{"md5": "h1", "sha256": "s1"},
+        Children: []*FileInfo{
+            {Name: "it's.wav", Path: "/Downloads/it's.wav", Hash: "h1", Size: 300 << 20, SourceRoot: "/Downloads"},
+            {Name: "copy.wav", Path: "/ref/copy.wav", Hash: "h1", Size: 300 << 20, SourceRoot: "/ref", Reference: true},
+        },
+    }}
+    if err := writeSQLite(path, output); err != nil {
+        t.Fatal(err)
+    }
+
+    setFlag(t, &forceOverwrite, false)
+    if err := writeSQLite(path, output); err == nil {
+        t.Error("writeSQLite replaced an existing database without -force")
+    }
+
+    db, err := sql.Open("sqlite", path)
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer db.Close()
+
+    var count int
+    err = db.QueryRow(`SELECT count(*) FROM duplicates d
+        JOIN files f ON f.id = d.duplicate_id
+        WHERE f.size > 100 * 1024 * 1024 AND f.path LIKE '/Downloads/%'`).Scan(&count)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if count != 1 {
+        t.Errorf("found %d large duplicates in /Downloads, want 1", count)
+    }
+
+    var name string
+    var reference, canonical bool
+    err = db.QueryRow("SELECT name, reference, canonical FROM files WHERE path = ?", "/ref/copy.wav").Scan(&name, &reference, &canonical)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if name != "copy.wav" || !reference || canonical {
+        t.Errorf("got name %q, reference %v, canonical %v", name, reference, canonical)
+    }
+
+    if err := db.QueryRow("SELECT count(*) FROM hashes WHERE file_id = 1").Scan(&count); err != nil {
+        t.Fatal(err)
+    }
+    if count != 2 {
+        t.Errorf("stored %d hashes for the canonical file, want 2", count)
+    }
+}
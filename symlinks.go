@@ -0,0 +1,101 @@
+package main
+
+import (
+    "os"
+    "path/filepath"
+    "slices"
+)
+
+// walkRoot walks root like filepath.Walk. Without -follow-symlinks links are
+// reported as they are and never followed. With it, a link to a file is
+// reported with its target's info, and a link to a directory is walked as if
+// the directory were at the link. Each real directory is walked at most once,
+// so a link back up the tree cannot loop. With -follow-only-within-roots, a
+// link whose target is outside every -s and -reference directory is reported
+// unfollowed, as without -follow-symlinks.
+func walkRoot(root string, fn filepath.WalkFunc) error {
+    if !followSymlinks {
+        return filepath.Walk(root, fn)
+    }
+
+    w := &linkWalker{fn: fn, seen: make(map[string]bool)}
+    if followWithinRoots {
+        for _, dir := range slices.Concat(sourceDirs, referenceDirs) {
+            w.roots = append(w.roots, resolvePath(dir))
+        }
+    }
+    return w.walk(resolvePath(root), root)
+}
+
+type linkWalker struct {
+    fn filepath.WalkFunc
+    // seen holds the real paths of the directories walked so far.
+    seen map[string]bool
+    // roots are the resolved directories links must stay within, if any.
+    roots []string
+}
+
+// walk walks real, a path with no symlinks in it, reporting the paths below
+// it as below as.
+func (w *linkWalker) walk(real, as string) error {
+    return filepath.Walk(real, func(path string, info os.FileInfo, err error) error {
+        reported := as
+        if rel, relErr := filepath.Rel(real, path); relErr == nil && rel != "." {
+            reported = filepath.Join(as, rel)
+        }
+        if err != nil {
+            return w.fn(reported, info, err)
+        }
+        if info.IsDir() {
+            if w.seen[path] {
+                return filepath.SkipDir
+            }
+            w.seen[path] = true
+        }
+        if info.Mode()&os.ModeSymlink == 0 {
+            return w.fn(reported, info, nil)
+        }
+
+        target, targetInfo, ok := w.follow(reported, path)
+        if !ok {
+            return w.fn(reported, info, nil)
+        }
+        if !targetInfo.IsDir() {
+            return w.fn(reported, targetInfo, nil)
+        }
+        if w.seen[target] {
+            log("Not following symlink %s to %s, which is already being scanned", reported, target)
+            return nil
+        }
+        return w.walk(target, reported)
+    })
+}
+
+// follow resolves the link at path, shown to the user as reported. It reports
+// false for a broken link, left to checkBrokenLink, and for one that leads
+// outside the roots of -follow-only-within-roots.
+func (w *linkWalker) follow(reported, path string) (string, os.FileInfo, bool) {
+    target, err := filepath.EvalSymlinks(path)
+    if err != nil {
+        return "", nil, false
+    }
+    if w.roots != nil && !withinRoots(target, w.roots) {
+        log("Not following symlink %s, which leads outside the scanned directories to %s", reported, target)
+        return "", nil, false
+    }
+    info, err := os.Stat(target)
+    if err != nil {
+        return "", nil, false
+    }
+    return target, info, true
+}
+
+// withinRoots reports whether path is one of roots or inside one of them.
+func withinRoots(path string, roots []string) bool {
+    for _, root := range roots {
+        if filepath.Clean(path) == filepath.Clean(root) {
+            return true
+        }
+    }
+    return insideRoot(path, roots)
+}
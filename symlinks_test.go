@@ -0,0 +1,110 @@
+package main
+
+import (
+    "os"
+    "path/filepath"
+    "slices"
+    "testing"
+)
+
+// linkTree builds a source root with links to a file and a directory inside
+// it, a link back up to the root, and links leading outside it.
+func linkTree(t *testing.T) string {
+    t.Helper()
+    base := t.TempDir()
+    for _, dir := range []string{"root/sub", "outside/shared"} {
+        if err := os.MkdirAll(filepath.Join(base, dir), 0755); err != nil {
+            t.Fatal(err)
+        }
+    }
+    for _, file := range []string{"root/a.wav", "root/sub/b.wav", "outside/secret.wav", "outside/shared/c.wav"} {
+        if err := os.WriteFile(filepath.Join(base, file), []byte(file), 0644); err != nil {
+            t.Fatal(err)
+        }
+    }
+    links := map[string]string{
+        "root/file.wav":  "sub/b.wav",
+        "root/inside":    "sub",
+        "root/sub/up":    "..",
+        "root/escape":    "../outside/shared",
+        "root/etc.wav":   filepath.Join(base, "outside/secret.wav"),
+        "root/chain.wav": "etc.wav",
+        "root/gone.wav":  "missing.wav",
+    }
+    for link, target := range links {
+        if err := os.Symlink(target, filepath.Join(base, link)); err != nil {
+            t.Skipf("symlinks not supported: %v", err)
+        }
+    }
+    return filepath.Join(base, "root")
+}
+
+// walkedFiles returns the regular files walkRoot reports under root,
+// relative to it.
+func walkedFiles(t *testing.T, root string) []string {
+    t.Helper()
+    var files []string
+    err := walkRoot(root, func(path string, info os.FileInfo, err error) error {
+        if err != nil {
+            return err
+        }
+        if info.Mode().IsRegular() {
+            rel, _ := filepath.Rel(root, path)
+            files = append(files, filepath.ToSlash(rel))
+        }
+        return nil
+    })
+    if err != nil {
+        t.Fatal(err)
+    }
+    slices.Sort(files)
+    return files
+}
+
+func TestWalkRootSymlinks(t *testing.T) {
+    root := linkTree(t)
+    setFlag(t, &sourceDirs, DirList{root})
+    setFlag(t, &referenceDirs, nil)
+
+    tests := []struct {
+        name   string
+        follow bool
+        within bool
+        want   []string
+    }{
+        {"not followed", false, false, []string{"a.wav", "sub/b.wav"}},
+        // "inside" is walked before "sub" and is the same directory, so its
+        // files are listed there; "up" leads back to the root and is skipped.
+        {"followed", true, false, []string{"a.wav", "chain.wav", "escape/c.wav", "etc.wav", "file.wav", "inside/b.wav"}},
+        {"within roots", true, true, []string{"a.wav", "file.wav", "inside/b.wav"}},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            setFlag(t, &followSymlinks, tt.follow)
+            setFlag(t, &followWithinRoots, tt.within)
+            if got := walkedFiles(t, root); !slices.Equal(got, tt.want) {
+                t.Errorf("walked %v, want %v", got, tt.want)
+            }
+        })
+    }
+}
+
+func TestWithinRoots(t *testing.T) {
+    roots := []string{"/music/library", "/music/incoming"}
+    tests := []struct {
+        path string
+        want bool
+    }{
+        {"/music/library", true},
+        {"/music/library/a.wav", true},
+        {"/music/library/../incoming/b.wav", true},
+        {"/music/library-old/a.wav", false},
+        {"/music", false},
+        {"/etc/passwd", false},
+    }
+    for _, tt := range tests {
+        if got := withinRoots(filepath.FromSlash(tt.path), roots); got != tt.want {
+            t.Errorf("withinRoots(%q) = %v, want %v", tt.path, got, tt.want)
+        }
+    }
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+    "context"
+    "errors"
+    "os"
+    "path/filepath"
+    "strconv"
+)
+
+// targetIndex maps the size and hash of files already in -t to their path,
+// for -skip-existing.
+var targetIndex map[string]string
+
+func contentKey(size int64, hash string) string {
+    return strconv.FormatInt(size, 10) + "|" + hash
+}
+
+// indexTarget hashes the files already in -t. Only files the same size as
+// something about to be copied can match, so no others are read.
+func indexTarget(output []*FileInfo) error {
+    targetIndex = make(map[string]string)
+
+    sizes := make(map[int64]bool)
+    for _, fileInfo := range output {
+        sizes[fileInfo.Size] = true
+    }
+
+    return filepath.Walk(targetDir, func(path string, info os.FileInfo, err error) error {
+        if err != nil {
+            if errors.Is(err, os.ErrPermission) || os.IsNotExist(err) {
+                return nil
+            }
+            return err
+        }
+        if !info.Mode().IsRegular() || !sizes[info.Size()] {
+            return nil
+        }
+
+        hashes, err := fileHash(context.Background(), path, nil)
+        if err != nil {
+            printError("Unable to hash target file %s: %v\n", path, err)
+            return nil
+        }
+        targetIndex[contentKey(info.Size(), hashes[hashNames()[0]])] = path
+        return nil
+    })
+}
+
+// presentInTarget returns the path of a file in -t with the same content as
+// fileInfo, if -skip-existing found one.
+func presentInTarget(fileInfo *FileInfo) (string, bool) {
+    path, ok := targetIndex[contentKey(fileInfo.Size, fileInfo.Hash)]
+    return path, ok
+}
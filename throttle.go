@@ -0,0 +1,79 @@
+package main
+
+import (
+    "context"
+    "io"
+    "sync"
+    "time"
+)
+
+// rateLimiter is a token bucket shared by every worker, so -max-read-mbps
+// caps the aggregate read rate rather than the rate of each file.
+type rateLimiter struct {
+    mu     sync.Mutex
+    rate   float64 // bytes per second
+    burst  float64
+    tokens float64
+    last   time.Time
+}
+
+// readLimiter throttles file reads; nil means unlimited.
+var readLimiter *rateLimiter
+
+func newRateLimiter(bytesPerSecond float64, burst int) *rateLimiter {
+    return &rateLimiter{
+        rate:   bytesPerSecond,
+        burst:  float64(burst),
+        tokens: float64(burst),
+        last:   time.Now(),
+    }
+}
+
+// wait takes n bytes from the bucket, sleeping until they are available or
+// ctx is done. The bucket may go into debt so reads larger than the burst
+// still make progress.
+func (l *rateLimiter) wait(ctx context.Context, n int) error {
+    l.mu.Lock()
+    now := time.Now()
+    l.tokens += now.Sub(l.last).Seconds() * l.rate
+    if l.tokens > l.burst {
+        l.tokens = l.burst
+    }
+    l.last = now
+    l.tokens -= float64(n)
+
+    var delay time.Duration
+    if l.tokens < 0 {
+        delay = time.Duration(-l.tokens / l.rate * float64(time.Second))
+    }
+    l.mu.Unlock()
+
+    if delay <= 0 {
+        return nil
+    }
+    timer := time.NewTimer(delay)
+    defer timer.Stop()
+    select {
+    case <-timer.C:
+        return nil
+    case <-ctx.Done():
+        return ctx.Err()
+    }
+}
+
+// throttledReader charges every read from r against limiter.
+type throttledReader struct {
+    ctx     context.Context
+    r       io.Reader
+    limiter *rateLimiter
+}
+
+func (t *throttledReader) Read(p []byte) (int, error) {
+    n, err := t.r.Read(p)
+    if n > 0 {
+        if werr := t.limiter.wait(t.ctx, n); werr != nil {
+            return n, werr
+        }
+    }
+    return n, err
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+    "errors"
+    "io"
+    "os"
+    "path/filepath"
+)
+
+// createTemp creates a temporary file that will later be renamed over a file
+// in destDir. It is created in -tmp-dir if set, otherwise in destDir itself
+// so the rename is atomic.
+func createTemp(destDir, pattern string) (*os.File, error) {
+    dir := destDir
+    if tmpDir != "" {
+        dir = tmpDir
+    }
+    return os.CreateTemp(dir, pattern)
+}
+
+// renameInto moves the temporary file at tmpPath to dest. When -tmp-dir is on
+// another filesystem the rename fails, and the content is copied over dest
+// instead, which is no longer atomic.
+func renameInto(tmpPath, dest string) error {
+    err := os.Rename(tmpPath, dest)
+    var linkErr *os.LinkError
+    if err == nil || tmpDir == "" || !errors.As(err, &linkErr) {
+        return err
+    }
+
+    src, err := os.Open(tmpPath)
+    if err != nil {
+        return err
+    }
+    defer src.Close()
+    info, err := src.Stat()
+    if err != nil {
+        return err
+    }
+
+    dst, err := os.OpenFile(dest, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, info.Mode())
+    if err != nil {
+        return err
+    }
+    if _, err := io.Copy(dst, src); err != nil {
+        dst.Close()
+        return err
+    }
+    if err := dst.Close(); err != nil {
+        return err
+    }
+    return os.Remove(tmpPath)
+}
+
+// checkWritable reports an error unless a file can be created in dir.
+func checkWritable(dir string) error {
+    info, err := os.Stat(dir)
+    if err != nil {
+        return err
+    }
+    if !info.IsDir() {
+        return &os.PathError{Op: "stat", Path: dir, Err: errors.New("not a directory")}
+    }
+    file, err := os.CreateTemp(dir, ".dedupe-music-check-*")
+    if err != nil {
+        return err
+    }
+    file.Close()
+    return os.Remove(filepath.Join(dir, filepath.Base(file.Name())))
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+    "fmt"
+    "io"
+    "sort"
+)
+
+// truncatedPair is a file whose content is an exact prefix of a larger file's,
+// typically a download that was cut short.
+type truncatedPair struct {
+    partial  *FileInfo
+    complete *FileInfo
+}
+
+// findTruncated looks among files with the same normalized name for one whose
+// content is a prefix of another's. Only one file per distinct hash is
+// compared, and each partial file is paired with the smallest file it
+// prefixes.
+func findTruncated(output []*FileInfo) []truncatedPair {
+    byName := make(map[string][]*FileInfo)
+    seen := make(map[string]bool)
+    add := func(fileInfo *FileInfo) {
+        if seen[fileInfo.Hash] {
+            return
+        }
+        seen[fileInfo.Hash] = true
+        name := normalizeName(fileInfo.Name)
+        byName[name] = append(byName[name], fileInfo)
+    }
+    for _, fileInfo := range output {
+        add(fileInfo)
+        for _, child := range fileInfo.Children {
+            add(child)
+        }
+    }
+
+    names := make([]string, 0, len(byName))
+    for name, files := range byName {
+        if len(files) > 1 {
+            names = append(names, name)
+        }
+    }
+    sort.Strings(names)
+
+    var pairs []truncatedPair
+    for _, name := range names {
+        files := byName[name]
+        sort.Slice(files, func(i, j int) bool { return files[i].Size < files[j].Size })
+
+        for i, partial := range files {
+            for _, complete := range files[i+1:] {
+                if complete.Size == partial.Size {
+                    continue
+                }
+                match, err := hasPrefix(complete, partial)
+                if err != nil {
+                    printError("Unable to compare %s with %s: %v\n", partial.Path, complete.Path, err)
+                    continue
+                }
+                if match {
+                    log("Truncated copy: %s is a prefix of %s", partial.Path, complete.Path)
+                    pairs = append(pairs, truncatedPair{partial: partial, complete: complete})
+                    break
+                }
+            }
+        }
+    }
+    return pairs
+}
+
+// hasPrefix reports whether the first partial.Size bytes of complete hash to
+// partial's hash, leaving out any -skip-header-bytes as hashing does.
+func hasPrefix(complete, partial *FileInfo) (bool, error) {
+    file, err := openSource(complete.Path)
+    if err != nil {
+        return false, err
+    }
+    defer file.Close()
+
+    if skipHeaderBytes > 0 {
+        if err := skipTo(file, skipHeaderBytes, -1); err != nil {
+            return false, err
+        }
+    }
+    sum, err := hashAlgorithms[hashNames()[0]].Hash(io.LimitReader(file, partial.Size-skipHeaderBytes))
+    if err != nil {
+        return false, err
+    }
+    return sum == partial.Hash, nil
+}
+
+func printTruncated(pairs []truncatedPair) {
+    if len(pairs) == 0 {
+        fmt.Fprintln(infoOut, "No truncated copies found")
+        return
+    }
+
+    fmt.Fprintln(infoOut, "Truncated copies (each is an exact prefix of the file below it):")
+    for _, pair := range pairs {
+        fmt.Fprintf(infoOut, "  %s (%s)\n", yellow(pair.partial.Path), formatSize(pair.partial.Size))
+        fmt.Fprintf(infoOut, "    %s (%s)\n", pair.complete.Path, formatSize(pair.complete.Size))
+    }
+}
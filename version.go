@@ -0,0 +1,53 @@
+package main
+
+import (
+    "fmt"
+    "runtime/debug"
+)
+
+// Build metadata, normally set at link time:
+//
+//	go build -ldflags "-X main.version=v1.2.0 -X main.commit=$(git rev-parse HEAD) -X main.buildDate=$(date -u +%Y-%m-%dT%H:%M:%SZ)"
+//
+// Anything left unset is filled in from the module and VCS information the
+// Go toolchain embeds in the binary.
+var (
+    version   = "dev"
+    commit    = ""
+    buildDate = ""
+)
+
+func init() {
+    info, ok := debug.ReadBuildInfo()
+    if !ok {
+        return
+    }
+    if version == "dev" && info.Main.Version != "" && info.Main.Version != "(devel)" {
+        version = info.Main.Version
+    }
+    for _, setting := range info.Settings {
+        switch setting.Key {
+        case "vcs.revision":
+            if commit == "" {
+                commit = setting.Value
+            }
+        case "vcs.time":
+            if buildDate == "" {
+                buildDate = setting.Value
+            }
+        }
+    }
+}
+
+// versionString describes the running build for -version.
+func versionString() string {
+    s := "dedupe-music " + version
+    c, d := commit, buildDate
+    if c == "" {
+        c = "unknown"
+    }
+    if d == "" {
+        d = "unknown"
+    }
+    return s + fmt.Sprintf(" (commit %s, built %s)", c, d)
+}
@@ -0,0 +1,241 @@
+package main
+
+import (
+    "context"
+    "fmt"
+    "os"
+    "path/filepath"
+    "strings"
+    "sync"
+    "time"
+
+    "github.com/fsnotify/fsnotify"
+)
+
+// watchSources keeps running after the initial scan. Files created or changed
+// under the source directories are hashed once they have been quiet for
+// watchDebounce, get the same copy/delete actions as the initial scan, and
+// the results file is rewritten. It returns when ctx is cancelled.
+func watchSources(ctx context.Context, output []*FileInfo, outputFile string) error {
+    watcher, err := fsnotify.NewWatcher()
+    if err != nil {
+        return err
+    }
+    defer watcher.Close()
+
+    for _, dir := range sourceDirs {
+        if err := addWatches(watcher, dir); err != nil {
+            return err
+        }
+    }
+
+    fileMap := make(map[string]*FileInfo, len(output))
+    for _, fileInfo := range output {
+        key := generateKey(fileInfo)
+        if _, exists := fileMap[key]; exists {
+            key += "|" + fileInfo.Path
+        }
+        fileMap[key] = fileInfo
+    }
+
+    fmt.Fprintf(infoOut, "Watching %s for changes (Ctrl-C to stop)\n", strings.Join(sourceDirs, ", "))
+
+    pending := newDebouncer(watchDebounce)
+    ticker := time.NewTicker(watchDebounce / 2)
+    defer ticker.Stop()
+
+    for {
+        select {
+        case <-ctx.Done():
+            fmt.Fprintln(infoOut, "Stopped watching")
+            return nil
+
+        case event, ok := <-watcher.Events:
+            if !ok {
+                return nil
+            }
+            if !event.Has(fsnotify.Create) && !event.Has(fsnotify.Write) {
+                continue
+            }
+
+            info, err := os.Stat(event.Name)
+            if err != nil {
+                continue
+            }
+            if info.IsDir() {
+                // Files can land in a new directory before it is watched.
+                if err := addWatches(watcher, event.Name); err != nil {
+                    printError("Unable to watch %s: %v\n", event.Name, err)
+                }
+                filepath.Walk(event.Name, func(path string, info os.FileInfo, err error) error {
+                    if err == nil && info.Mode().IsRegular() {
+                        pending.touch(path, time.Now())
+                    }
+                    return nil
+                })
+                continue
+            }
+            pending.touch(event.Name, time.Now())
+
+        case err, ok := <-watcher.Errors:
+            if !ok {
+                return nil
+            }
+            printError("%v\n", err)
+
+        case <-ticker.C:
+            ready := pending.ready(time.Now())
+            if len(ready) == 0 {
+                continue
+            }
+
+            for _, path := range ready {
+                processWatchedFile(path, fileMap)
+            }
+
+            output := make([]*FileInfo, 0, len(fileMap))
+            for key, fileInfo := range fileMap {
+                fileMap[key] = selectCanonical(fileInfo)
+                output = append(output, fileMap[key])
+            }
+            if err := rewriteJSONFile(outputFile, output); err != nil {
+                printError("Unable to update %s: %v\n", outputFile, err)
+                continue
+            }
+            log("Results updated in %s", outputFile)
+        }
+    }
+}
+
+// debouncer collects the paths of files being written and hands each one out
+// once it has been quiet for a while, so a file written in many bursts is
+// processed once.
+type debouncer struct {
+    quiet   time.Duration
+    pending map[string]time.Time
+}
+
+func newDebouncer(quiet time.Duration) *debouncer {
+    return &debouncer{quiet: quiet, pending: make(map[string]time.Time)}
+}
+
+// touch records that path changed at now.
+func (d *debouncer) touch(path string, now time.Time) {
+    d.pending[path] = now
+}
+
+// ready removes and returns the paths that have not changed for the quiet
+// period as of now.
+func (d *debouncer) ready(now time.Time) []string {
+    var paths []string
+    for path, last := range d.pending {
+        if now.Sub(last) >= d.quiet {
+            paths = append(paths, path)
+            delete(d.pending, path)
+        }
+    }
+    return paths
+}
+
+// addWatches watches dir and every directory below it.
+func addWatches(watcher *fsnotify.Watcher, dir string) error {
+    return filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+        if err != nil {
+            return nil
+        }
+        if info.IsDir() {
+            return watcher.Add(path)
+        }
+        return nil
+    })
+}
+
+// processWatchedFile hashes a new or changed file, adds it to fileMap, and
+// applies the configured copy/delete actions to it.
+func processWatchedFile(path string, fileMap map[string]*FileInfo) {
+    info, err := os.Stat(path)
+    if err != nil || !info.Mode().IsRegular() || info.Size() < minSizeFor(path) {
+        return
+    }
+    if !isAllowed(path) || !pathAllowed(path) {
+        return
+    }
+
+    root := ""
+    for _, dir := range sourceDirs {
+        if insideRoot(path, []string{dir}) {
+            root = dir
+            break
+        }
+    }
+
+    if skipHidden {
+        rel := path
+        if root != "" {
+            rel, _ = filepath.Rel(root, path)
+        }
+        if hiddenPath(rel, string(filepath.Separator)) {
+            return
+        }
+    }
+
+    // A modified file is hashed afresh, so drop what was recorded before.
+    forgetPath(fileMap, path)
+
+    var fileMapMutex sync.Mutex
+    fileInfo := processFile(fileJob{path: path, root: root}, fileMap, &fileMapMutex)
+    if fileInfo == nil {
+        return
+    }
+
+    isNew := fileMap[generateKey(fileInfo)] == fileInfo
+    if isNew {
+        fmt.Fprintf(infoOut, "New file: %s\n", path)
+    } else {
+        fmt.Fprintf(infoOut, "Duplicate file: %s\n", path)
+    }
+
+    if targetDir != "" && (isNew || copyMode == "all") {
+        if existing, ok := presentInTarget(fileInfo); ok {
+            fmt.Fprintf(infoOut, "Already present: %s (as %s)\n", path, existing)
+        } else {
+            log("Copying file: %s", path)
+            if _, err := copyFile(path, copyDestDir(fileInfo), fileInfo); err != nil {
+                printError("Unable to copy file %s: %v\n", path, err)
+                return
+            }
+        }
+    }
+
+    // main refuses -delete-source-files with inexact matching, but since a
+    // watched file is deleted straight after its copy, or without one if it
+    // matched, check again rather than risk losing a file that differs.
+    if deleteSourceFiles && !fileInfo.readOnly() && inexactMatch() == "" {
+        if err := os.RemoveAll(path); err != nil {
+            printError("Unable to delete file %s: %v\n", path, err)
+        }
+    }
+}
+
+// forgetPath removes path from whichever group in fileMap holds it.
+func forgetPath(fileMap map[string]*FileInfo, path string) {
+    for key, group := range fileMap {
+        if group.Path == path {
+            if len(group.Children) == 0 {
+                delete(fileMap, key)
+                return
+            }
+            next := group.Children[0]
+            next.Children = group.Children[1:]
+            fileMap[key] = next
+            return
+        }
+
+        for i, child := range group.Children {
+            if child.Path == path {
+                group.Children = append(group.Children[:i], group.Children[i+1:]...)
+                return
+            }
+        }
+    }
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+    "os"
+    "path/filepath"
+    "testing"
+    "time"
+)
+
+func TestDebouncerBurst(t *testing.T) {
+    d := newDebouncer(100 * time.Millisecond)
+    start := time.Now()
+    for i := range 50 {
+        d.touch("/music/take.wav", start.Add(time.Duration(i)*time.Millisecond))
+    }
+    last := start.Add(49 * time.Millisecond)
+
+    if got := d.ready(last.Add(50 * time.Millisecond)); len(got) != 0 {
+        t.Fatalf("ready during the quiet period = %v, want none", got)
+    }
+    if got := d.ready(last.Add(100 * time.Millisecond)); len(got) != 1 || got[0] != "/music/take.wav" {
+        t.Fatalf("ready after the quiet period = %v, want the file once", got)
+    }
+    if got := d.ready(last.Add(time.Second)); len(got) != 0 {
+        t.Fatalf("ready after processing = %v, want none", got)
+    }
+}
+
+func TestWatchNeverDeletesInexactMatch(t *testing.T) {
+    setFlag(t, &fuzzyName, true)
+    setFlag(t, &deleteSourceFiles, true)
+    setFlag(t, &minSize, SizeFlag(0))
+
+    files := map[string]string{
+        "Track 01.wav":     "first take",
+        "Track_01 (1).wav": "second take",
+    }
+    dir, fileMap := scanFiles(t, files, "Track 01.wav")
+    setFlag(t, &sourceDirs, DirList{dir})
+
+    path := filepath.Join(dir, "Track_01 (1).wav")
+    if err := os.WriteFile(path, []byte(files["Track_01 (1).wav"]), 0644); err != nil {
+        t.Fatal(err)
+    }
+    processWatchedFile(path, fileMap)
+    if _, err := os.Stat(path); err != nil {
+        t.Errorf("%s was deleted although its content differs from its group", path)
+    }
+}
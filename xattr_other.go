@@ -0,0 +1,11 @@
+//go:build !linux && !darwin
+
+package main
+
+import "errors"
+
+// copyXattrs is not supported on this platform; copies are made without
+// extended attributes.
+func copyXattrs(src, dst string) error {
+    return errors.ErrUnsupported
+}
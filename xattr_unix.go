@@ -0,0 +1,71 @@
+//go:build linux || darwin
+
+package main
+
+import (
+    "bytes"
+    "errors"
+    "fmt"
+
+    "golang.org/x/sys/unix"
+)
+
+// copyXattrs copies the extended attributes of src to dst. On macOS these
+// include Finder tags and the resource fork, which is kept in the
+// com.apple.ResourceFork attribute. A filesystem without extended
+// attributes gives an error matching errors.ErrUnsupported.
+func copyXattrs(src, dst string) error {
+    names, err := listXattrs(src)
+    if err != nil {
+        return err
+    }
+    for _, name := range names {
+        value, err := getXattr(src, name)
+        if err != nil {
+            return fmt.Errorf("reading %s: %w", name, err)
+        }
+        if err := unix.Setxattr(dst, name, value, 0); err != nil {
+            return fmt.Errorf("setting %s: %w", name, err)
+        }
+    }
+    return nil
+}
+
+// listXattrs returns the names of path's extended attributes.
+func listXattrs(path string) ([]string, error) {
+    buf, err := readXattrBuffer(func(b []byte) (int, error) { return unix.Listxattr(path, b) })
+    if err != nil {
+        return nil, err
+    }
+    var names []string
+    for _, name := range bytes.Split(buf, []byte{0}) {
+        if len(name) > 0 {
+            names = append(names, string(name))
+        }
+    }
+    return names, nil
+}
+
+func getXattr(path, name string) ([]byte, error) {
+    return readXattrBuffer(func(b []byte) (int, error) { return unix.Getxattr(path, name, b) })
+}
+
+// readXattrBuffer calls read first to learn the size needed and then to fill
+// a buffer of that size, trying again if the attribute grew in between.
+func readXattrBuffer(read func([]byte) (int, error)) ([]byte, error) {
+    for {
+        size, err := read(nil)
+        if err != nil || size == 0 {
+            return nil, err
+        }
+        buf := make([]byte, size)
+        n, err := read(buf)
+        if errors.Is(err, unix.ERANGE) {
+            continue
+        }
+        if err != nil {
+            return nil, err
+        }
+        return buf[:n], nil
+    }
+}